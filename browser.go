@@ -0,0 +1,43 @@
+package pitstop
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// OpenBrowser returns a Step that opens url in the default browser. It
+// is intended to be used as a post step, typically after WaitForHTTP and
+// wrapped in Once so the browser isn't opened again on every rebuild:
+//
+//	Post: []pitstop.Step{
+//		pitstop.WaitForHTTP("http://localhost:3000", 5*time.Second),
+//		pitstop.Once(pitstop.OpenBrowser("http://localhost:3000")),
+//	}
+//
+// Failing to open a browser isn't treated as a build error. If the platform's
+// opener isn't available a warning is logged and nil is returned.
+func OpenBrowser(url string) Step {
+	return stepFunc(func(sc stepContext) error {
+		command, args := browserCommand(url)
+		cmd := exec.Command(command, args...)
+		err := cmd.Start()
+		if err != nil {
+			sc.log.infof("Warning: unable to open browser: %v", err)
+			return nil
+		}
+		// Reap the opener once it exits; we don't care how it went.
+		go cmd.Wait()
+		return nil
+	})
+}
+
+// browserCommand returns the command used to open url on this platform.
+func browserCommand(url string) (string, []string) {
+	switch runtime.GOOS {
+	case "darwin":
+		return "open", []string{url}
+	case "windows":
+		return "rundll32", []string{"url.dll,FileProtocolHandler", url}
+	}
+	return "xdg-open", []string{url}
+}
@@ -0,0 +1,15 @@
+package pitstop_test
+
+import (
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestOpenBrowser_noOpener(t *testing.T) {
+	// With an empty PATH the platform's opener can't be found.
+	t.Setenv("PATH", "")
+	if err := pitstop.OpenBrowser("http://localhost:3000").Build(); err != nil {
+		t.Errorf("OpenBrowser() err = %v; want nil when no opener is available", err)
+	}
+}
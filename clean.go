@@ -0,0 +1,93 @@
+package pitstop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Clean returns a BuildFunc that removes path and everything inside it, such
+// as a build output directory that is regenerated by a later step. It works
+// like CleanWithin using the current directory as the root, so it refuses to
+// remove the current directory itself or anything outside of it.
+func Clean(path string) BuildFunc {
+	return CleanWithin(".", path)
+}
+
+// CleanWithin returns a BuildFunc that removes path and everything inside it,
+// as long as path is inside root. Relative paths are resolved from the
+// current working directory. Unlike running "rm -rf", the path is checked
+// every time before anything is removed, and an error is returned rather than
+// removing root itself, a filesystem root such as "/", or anything outside of
+// root. Symlinks in the parent directories of path are resolved before it is
+// checked, so a symlink can't be used to escape root. If path is itself a
+// symlink only the link is removed, not what it points to. A path that
+// doesn't exist has nothing to clean, so nil is returned.
+func CleanWithin(root, path string) BuildFunc {
+	return func() error {
+		target, err := cleanTarget(root, path)
+		if err != nil {
+			return fmt.Errorf("error cleaning %q: %w", path, err)
+		}
+		if target == "" {
+			return nil
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("error cleaning %q: %w", path, err)
+		}
+		return nil
+	}
+}
+
+// errUnsafeClean is returned by cleanTarget for paths that must not be
+// removed.
+var errUnsafeClean = errors.New("pitstop: refusing to clean")
+
+// cleanTarget resolves path, returning the path that should be removed or ""
+// if there is nothing to remove. An error wrapping errUnsafeClean is returned
+// if the resolved path isn't strictly inside root.
+func cleanTarget(root, path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("%w an empty path", errUnsafeClean)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(abs) == abs {
+		return "", fmt.Errorf("%w a filesystem root", errUnsafeClean)
+	}
+	realRoot, err := realPath(root)
+	if err != nil {
+		return "", err
+	}
+	// Only the parent is resolved, since RemoveAll removes a symlink rather
+	// than following it.
+	parent, err := realPath(filepath.Dir(abs))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(parent, filepath.Base(abs))
+	rel, err := filepath.Rel(realRoot, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w a path that isn't inside %q", errUnsafeClean, root)
+	}
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return "", nil
+	}
+	return target, nil
+}
+
+// realPath returns the absolute path of path with any symlinks resolved.
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
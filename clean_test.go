@@ -0,0 +1,87 @@
+package pitstop_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestCleanWithin(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":           "",
+		"build/app":         "",
+		"build/assets/a.js": "",
+	})
+	defer os.RemoveAll(dir)
+	outside := writeFiles(t, map[string]string{"keep/file": ""})
+	defer os.RemoveAll(outside)
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("setup: creating symlink: %v", err)
+	}
+
+	for name, path := range map[string]string{
+		"root":            dir,
+		"parent":          filepath.Join(dir, ".."),
+		"filesystem root": "/",
+		"outside":         filepath.Join(outside, "keep"),
+		"through symlink": filepath.Join(dir, "link", "keep"),
+		"empty":           "",
+	} {
+		if err := pitstop.CleanWithin(dir, path)(); err == nil {
+			t.Errorf("%s: CleanWithin(%q) err = nil; want an error", name, path)
+		}
+	}
+	for _, path := range []string{filepath.Join(dir, "main.go"), filepath.Join(outside, "keep", "file")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed: %v", path, err)
+		}
+	}
+
+	if err := pitstop.CleanWithin(dir, filepath.Join(dir, "build"))(); err != nil {
+		t.Fatalf("CleanWithin() err = %v; want nil", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build")); !os.IsNotExist(err) {
+		t.Errorf("build still exists; want it removed")
+	}
+	if err := pitstop.CleanWithin(dir, filepath.Join(dir, "build"))(); err != nil {
+		t.Errorf("CleanWithin() of a missing path err = %v; want nil", err)
+	}
+
+	// Cleaning a symlink removes the link, not what it points to.
+	if err := pitstop.CleanWithin(dir, filepath.Join(dir, "link"))(); err != nil {
+		t.Fatalf("CleanWithin() of a symlink err = %v; want nil", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "link")); !os.IsNotExist(err) {
+		t.Errorf("link still exists; want it removed")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "keep", "file")); err != nil {
+		t.Errorf("symlink target was removed: %v", err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"tmp/app": ""})
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("setup: getting working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("setup: changing working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	for _, path := range []string{".", "..", "../other"} {
+		if err := pitstop.Clean(path)(); err == nil {
+			t.Errorf("Clean(%q) err = nil; want an error", path)
+		}
+	}
+	if err := pitstop.Clean("./tmp")(); err != nil {
+		t.Fatalf("Clean() err = %v; want nil", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tmp")); !os.IsNotExist(err) {
+		t.Errorf("tmp still exists; want it removed")
+	}
+}
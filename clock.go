@@ -0,0 +1,44 @@
+package pitstop
+
+import "time"
+
+// Clock is used by the Poller to tell time and wait between scans. It exists so
+// that tests can control time rather than relying on real sleeps.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is a Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) newTimer(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTimer(d)
+	return t.C, func() { t.Stop() }
+}
+
+// timerClock is implemented by Clocks that can stop waiting early, so that a
+// long wait the poller no longer needs doesn't hold onto a timer.
+type timerClock interface {
+	newTimer(d time.Duration) (<-chan time.Time, func())
+}
+
+// newTimer works like clock.After, but also returns a func that stops waiting
+// if clock supports it, and otherwise does nothing.
+func newTimer(clock Clock, d time.Duration) (<-chan time.Time, func()) {
+	if tc, ok := clock.(timerClock); ok {
+		return tc.newTimer(d)
+	}
+	return clock.After(d), func() {}
+}
@@ -0,0 +1,124 @@
+package pitstop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a Poller in a form that can be checked into a repo, such
+// as a .pitstop.yaml file:
+//
+//	dir: .
+//	scan_interval: 250ms
+//	ignore: [tmp/, "*.log"]
+//	include: ["*.go", "*.html"]
+//	pre:
+//	  - command: go
+//	    args: [build, -o, ./tmp/app, .]
+//	run:
+//	  command: ./tmp/app
+//	post:
+//	  - command: echo
+//	    args: [started]
+type Config struct {
+	Dir          string          `yaml:"dir"`
+	ScanInterval time.Duration   `yaml:"scan_interval"`
+	Ignore       []string        `yaml:"ignore"`
+	Include      []string        `yaml:"include"`
+	Pre          []CommandConfig `yaml:"pre"`
+	Run          *CommandConfig  `yaml:"run"`
+	Post         []CommandConfig `yaml:"post"`
+	// ExpandEnv expands references to environment variables, such as
+	// ${GOOS}, in every command and its args each time it runs, as described
+	// by BuildCommandEnv. It is off by default so that args containing a "$"
+	// are passed through untouched.
+	ExpandEnv bool `yaml:"expand_env"`
+}
+
+// CommandConfig describes a single command in a Config.
+type CommandConfig struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+}
+
+// LoadConfig reads the YAML config file at path and returns a Poller that uses
+// BuildCommand for each of its pre and post commands and RunCommand for its
+// run command. Unknown keys, such as a misspelled "incldue", are reported as
+// errors rather than ignored. A relative dir is resolved from the directory
+// containing the config file, and an empty dir means that directory. The
+// commands themselves are still run from the current working directory.
+func LoadConfig(path string) (*Poller, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(&cfg)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error loading config %q: %w", path, err)
+	}
+	if !filepath.IsAbs(cfg.Dir) {
+		cfg.Dir = filepath.Join(filepath.Dir(path), cfg.Dir)
+	}
+	p, err := cfg.Poller()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config %q: %w", path, err)
+	}
+	return p, nil
+}
+
+// Poller validates the config and returns a Poller built from it.
+func (cfg Config) Poller() (*Poller, error) {
+	if cfg.Run == nil {
+		return nil, fmt.Errorf("run is required")
+	}
+	if cfg.Run.Command == "" {
+		return nil, fmt.Errorf("run: command is required")
+	}
+	pre, err := buildCommands("pre", cfg.Pre, cfg.ExpandEnv)
+	if err != nil {
+		return nil, err
+	}
+	post, err := buildCommands("post", cfg.Post, cfg.ExpandEnv)
+	if err != nil {
+		return nil, err
+	}
+	run := RunCommand(cfg.Run.Command, cfg.Run.Args...)
+	if cfg.ExpandEnv {
+		run = RunCommandEnv(nil, cfg.Run.Command, cfg.Run.Args...)
+	}
+	return &Poller{
+		Dir:          cfg.Dir,
+		ScanInterval: cfg.ScanInterval,
+		Ignore:       cfg.Ignore,
+		Include:      cfg.Include,
+		Pre:          pre,
+		Run:          run,
+		Post:         post,
+	}, nil
+}
+
+// buildCommands converts each of the provided commands into a Step, using
+// BuildCommandEnv if expand is true.
+func buildCommands(phase string, cmds []CommandConfig, expand bool) ([]Step, error) {
+	var steps []Step
+	for i, cmd := range cmds {
+		if cmd.Command == "" {
+			return nil, fmt.Errorf("%s[%d]: command is required", phase, i)
+		}
+		if expand {
+			steps = append(steps, BuildCommandEnv(nil, cmd.Command, cmd.Args...))
+		} else {
+			steps = append(steps, BuildCommand(cmd.Command, cmd.Args...))
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,194 @@
+package pitstop_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestLoadConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, contents string) string {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("setup: creating temp dir: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		path := filepath.Join(dir, ".pitstop.yaml")
+		err = ioutil.WriteFile(path, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("setup: writing config: %v", err)
+		}
+		return path
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		path := writeConfig(t, `
+dir: ./src
+scan_interval: 250ms
+ignore: [tmp/, "*.log"]
+include: ["*.go"]
+pre:
+  - command: echo
+    args: [building]
+  - command: echo
+run:
+  command: tail
+post:
+  - command: echo
+    args: [done]
+`)
+		p, err := pitstop.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig() err = %v; want nil", err)
+		}
+		// Relative dirs are resolved from the config file's directory.
+		if want := filepath.Join(filepath.Dir(path), "src"); p.Dir != want {
+			t.Errorf("Dir = %q; want %q", p.Dir, want)
+		}
+		if p.ScanInterval != 250*time.Millisecond {
+			t.Errorf("ScanInterval = %v; want %v", p.ScanInterval, 250*time.Millisecond)
+		}
+		if want := []string{"tmp/", "*.log"}; !reflect.DeepEqual(p.Ignore, want) {
+			t.Errorf("Ignore = %v; want %v", p.Ignore, want)
+		}
+		if want := []string{"*.go"}; !reflect.DeepEqual(p.Include, want) {
+			t.Errorf("Include = %v; want %v", p.Include, want)
+		}
+		if len(p.Pre) != 2 || len(p.Post) != 1 || p.Run == nil {
+			t.Fatalf("len(Pre), len(Post) = %d, %d; want 2, 1 and a non-nil Run", len(p.Pre), len(p.Post))
+		}
+		stop, err := pitstop.Run(p.Pre, p.Run, p.Post)
+		if err != nil {
+			t.Fatalf("Run() err = %v; want nil", err)
+		}
+		stop()
+	})
+
+	for name, tc := range map[string]struct {
+		contents string
+		errMsg   string
+	}{
+		"missing run": {
+			contents: "pre:\n  - command: echo\n",
+			errMsg:   "run is required",
+		},
+		"run without command": {
+			contents: "run:\n  args: [hi]\n",
+			errMsg:   "run: command is required",
+		},
+		"pre without command": {
+			contents: "pre:\n  - args: [hi]\nrun:\n  command: tail\n",
+			errMsg:   "pre[0]: command is required",
+		},
+		"invalid yaml": {
+			contents: "run: [",
+			errMsg:   "error loading config",
+		},
+		"unknown key": {
+			contents: "exlude: [tmp/]\nrun:\n  command: tail\n",
+			errMsg:   "field exlude not found",
+		},
+		"empty": {
+			contents: "",
+			errMsg:   "run is required",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := pitstop.LoadConfig(writeConfig(t, tc.contents))
+			if err == nil {
+				t.Fatalf("LoadConfig() err = nil; want an error")
+			}
+			if !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("LoadConfig() err = %v; want it to contain %q", err, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	abs, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(abs)
+
+	for cfgDir, want := range map[string]string{
+		"":        dir,
+		".":       dir,
+		"../app":  filepath.Join(filepath.Dir(dir), "app"),
+		"web/src": filepath.Join(dir, "web", "src"),
+		abs:       abs,
+	} {
+		path := filepath.Join(dir, ".pitstop.yaml")
+		contents := fmt.Sprintf("dir: %q\nrun:\n  command: tail\n", cfgDir)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("setup: writing config: %v", err)
+		}
+		p, err := pitstop.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig(dir=%q) err = %v; want nil", cfgDir, err)
+		}
+		if p.Dir != want {
+			t.Errorf("LoadConfig(dir=%q) Dir = %q; want %q", cfgDir, p.Dir, want)
+		}
+	}
+}
+
+func TestLoadConfig_expandEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".pitstop.yaml")
+	t.Setenv("PITSTOP_NAME", "api")
+	t.Setenv("PITSTOP_APP", "tail")
+	for _, tc := range []struct {
+		expand bool
+		run    string
+		want   string
+	}{
+		{expand: false, run: "tail", want: "${PITSTOP_NAME}"},
+		{expand: true, run: "${PITSTOP_APP}", want: "api"},
+	} {
+		contents := fmt.Sprintf(`
+expand_env: %t
+pre:
+  - command: printf
+    args: ["%%s", "${PITSTOP_NAME}"]
+run:
+  command: %s
+`, tc.expand, tc.run)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("setup: writing config: %v", err)
+		}
+		p, err := pitstop.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig() err = %v; want nil", err)
+		}
+		output := captureStdout(t)
+		// The run command isn't looked up on PATH before it is expanded.
+		stop, err := p.Once(context.Background())
+		got := output()
+		if err != nil {
+			t.Fatalf("Once() with expand_env: %t err = %v; want nil", tc.expand, err)
+		}
+		stop()
+		if got != tc.want {
+			t.Errorf("output with expand_env: %t = %q; want %q", tc.expand, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pitstop
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CopyFile returns a BuildFunc that copies the file at src to dst, preserving
+// its file mode. Any missing parent directories of dst are created, and if dst
+// already exists it is overwritten. If src is a symlink, the file it points to
+// is copied.
+func CopyFile(src, dst string) BuildFunc {
+	return func() error {
+		err := copyFile(src, dst)
+		if err != nil {
+			return fmt.Errorf("error copying %q to %q: %w", src, dst, err)
+		}
+		return nil
+	}
+}
+
+// CopyDir returns a BuildFunc that copies the directory at src, along with
+// all of its contents, to dst. File modes are preserved, empty directories are
+// copied, and symlinks are recreated as symlinks rather than copying the files
+// they point to. Files that already exist in dst are overwritten, but files in
+// dst that aren't in src are left alone.
+func CopyDir(src, dst string) BuildFunc {
+	return func() error {
+		err := copyDir(src, dst)
+		if err != nil {
+			return fmt.Errorf("error copying %q to %q: %w", src, dst, err)
+		}
+		return nil
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%q is a directory", src)
+	}
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	// OpenFile only applies the mode to new files, so make sure an existing
+	// dst ends up with the same mode as src.
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			err := os.MkdirAll(target, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			err = os.Remove(target)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package pitstop_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestCopyFile(t *testing.T) {
+	src := writeFiles(t, map[string]string{
+		"config.json": "{}",
+	})
+	defer os.RemoveAll(src)
+	err := os.Chmod(filepath.Join(src, "config.json"), 0640)
+	if err != nil {
+		t.Fatalf("setup: chmod: %v", err)
+	}
+	dst, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dst)
+	target := filepath.Join(dst, "nested", "config.json")
+
+	for i := 0; i < 2; i++ {
+		// The second copy checks that an existing dst is overwritten.
+		err := pitstop.CopyFile(filepath.Join(src, "config.json"), target)()
+		if err != nil {
+			t.Fatalf("CopyFile() err = %v; want nil", err)
+		}
+	}
+	b, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatalf("reading copied file: %v", err)
+	}
+	if string(b) != "{}" {
+		t.Errorf("copied contents = %q; want %q", b, "{}")
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("stat copied file: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Errorf("copied mode = %v; want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+
+	err = pitstop.CopyFile(filepath.Join(src, "missing"), target)()
+	if err == nil {
+		t.Errorf("CopyFile() err = nil for a missing src; want an error")
+	}
+}
+
+func TestCopyDir(t *testing.T) {
+	src := writeFiles(t, map[string]string{
+		"index.html":     "<html>",
+		"css/app.css":    "body {}",
+		"js/lib/dep.js":  "dep",
+		"js/lib/main.js": "main",
+	})
+	defer os.RemoveAll(src)
+	err := os.Mkdir(filepath.Join(src, "empty"), 0700)
+	if err != nil {
+		t.Fatalf("setup: creating empty dir: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		err = os.Symlink("app.css", filepath.Join(src, "css", "link.css"))
+		if err != nil {
+			t.Fatalf("setup: creating symlink: %v", err)
+		}
+	}
+	dst, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dst)
+	target := filepath.Join(dst, "public")
+
+	for i := 0; i < 2; i++ {
+		// The second copy checks that existing files and symlinks in dst are
+		// overwritten.
+		err := pitstop.CopyDir(src, target)()
+		if err != nil {
+			t.Fatalf("CopyDir() err = %v; want nil", err)
+		}
+	}
+	for name, want := range map[string]string{
+		"index.html":     "<html>",
+		"css/app.css":    "body {}",
+		"js/lib/dep.js":  "dep",
+		"js/lib/main.js": "main",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("reading copied %s: %v", name, err)
+			continue
+		}
+		if string(b) != want {
+			t.Errorf("copied %s = %q; want %q", name, b, want)
+		}
+	}
+	info, err := os.Stat(filepath.Join(target, "empty"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("empty directory wasn't copied: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		link, err := os.Readlink(filepath.Join(target, "css", "link.css"))
+		if err != nil {
+			t.Errorf("reading copied symlink: %v", err)
+		} else if link != "app.css" {
+			t.Errorf("copied symlink = %q; want %q", link, "app.css")
+		}
+	}
+}
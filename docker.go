@@ -0,0 +1,81 @@
+package pitstop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ComposeRun returns a RunStep that starts service from the Docker Compose
+// file at file, rebuilding its image first, and streams the service's logs
+// while it runs. If file is empty, Compose looks for its default files in the
+// current directory. For example, a poller might use:
+//
+//	p := pitstop.Poller{
+//		Dir: ".",
+//		Run: pitstop.ComposeRun("compose.yaml", "api"),
+//	}
+//
+// The service is started with "docker compose up --build --detach --wait",
+// so the RunStep doesn't return until the service is running, or healthy if
+// it has a healthcheck, and an error is returned if it fails to start. The
+// stop func stops the service with "docker compose stop" without removing
+// its container or the rest of the stack; see OnShutdown for running
+// "docker compose down" once the poller exits. An error describing how to
+// install Compose is returned if the docker command, or its compose plugin,
+// can't be found. The --wait flag requires Docker Compose v2.1.0 or newer.
+func ComposeRun(file, service string) RunStep {
+	args := func(extra ...string) []string {
+		args := []string{"compose"}
+		if file != "" {
+			args = append(args, "-f", file)
+		}
+		return append(args, extra...)
+	}
+	up := args("up", "--build", "--detach", "--wait", service)
+	upCmd := BuildCommand("docker", up...)
+	stopCmd := BuildCommand("docker", args("stop", service)...)
+	fn := runFunc(func(sc stepContext) (func(), error) {
+		if err := checkCompose(); err != nil {
+			return nil, err
+		}
+		// Only the logs written from now on are streamed, so output from
+		// earlier runs of the service isn't repeated.
+		since := time.Now().Format(time.RFC3339Nano)
+		if err := buildStep(sc, upCmd); err != nil {
+			return nil, fmt.Errorf("error starting %s: %w", service, err)
+		}
+		stopService := func() {
+			if err := buildStep(sc, stopCmd); err != nil {
+				sc.log.errorf("Error stopping %s: %v", service, err)
+			}
+		}
+		// The args change with since, so the logs command is started
+		// directly rather than being created once up front.
+		logsArgs := args("logs", "--follow", "--since", since, service)
+		logs, err := processCommand(context.Background(), RunOptions{}, "docker", logsArgs, nil)(sc)
+		if err != nil {
+			stopService()
+			return nil, fmt.Errorf("error streaming logs for %s: %w", service, err)
+		}
+		return func() {
+			logs.Stop()
+			stopService()
+		}, nil
+	})
+	return describeRun(fn, "docker", up)
+}
+
+// checkCompose returns an error if Docker Compose can't be run.
+func checkCompose() error {
+	err := exec.Command("docker", "compose", "version").Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return &CommandNotFoundError{Command: "docker", Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("error running docker compose, make sure the Compose plugin is installed (https://docs.docker.com/compose/install/): %w", err)
+	}
+	return nil
+}
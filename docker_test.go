@@ -0,0 +1,98 @@
+package pitstop_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+// fakeDocker is a docker command that records its args in $DOCKER_CALLS,
+// fails to start services while $DOCKER_FAIL exists, and writes a log line
+// followed by $DOCKER_CALLS.logs when asked to follow logs.
+const fakeDocker = `#!/bin/sh
+echo "$*" >> "$DOCKER_CALLS"
+case "$*" in
+*" up "*) test ! -e "$DOCKER_FAIL" ;;
+*" logs "*) echo "api | listening"; touch "$DOCKER_CALLS.logs"; exec sleep 10 ;;
+esac
+`
+
+func TestComposeRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake docker command is a shell script")
+	}
+	dir := writeFiles(t, map[string]string{"bin/docker": fakeDocker})
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(filepath.Join(dir, "bin", "docker"), 0700); err != nil {
+		t.Fatalf("setup: making docker executable: %v", err)
+	}
+	calls := filepath.Join(dir, "calls")
+	fail := filepath.Join(dir, "fail")
+	t.Setenv("PATH", filepath.Join(dir, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("DOCKER_CALLS", calls)
+	t.Setenv("DOCKER_FAIL", fail)
+	readCalls := func() []string {
+		b, _ := ioutil.ReadFile(calls)
+		os.Remove(calls)
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	output := captureStdout(t)
+	stop, err := pitstop.ComposeRun("compose.yaml", "api").Run()
+	if err != nil {
+		output()
+		t.Fatalf("run() err = %v; want nil", err)
+	}
+	if err := pitstop.WaitForFile(calls+".logs", 2*time.Second)(); err != nil {
+		t.Errorf("logs weren't streamed: %v", err)
+	}
+	stop()
+	if got := output(); !strings.Contains(got, "api | listening") {
+		t.Errorf("output = %q; want it to contain the service's logs", got)
+	}
+	got := readCalls()
+	if len(got) != 4 {
+		t.Fatalf("docker calls = %q; want 4", got)
+	}
+	for i, want := range []string{
+		"compose version",
+		"compose -f compose.yaml up --build --detach --wait api",
+		"compose -f compose.yaml logs --follow --since ",
+		"compose -f compose.yaml stop api",
+	} {
+		if !strings.HasPrefix(got[i], want) {
+			t.Errorf("docker call %d = %q; want it to start with %q", i, got[i], want)
+		}
+	}
+
+	// A service that fails to start returns an error without streaming logs.
+	if err := ioutil.WriteFile(fail, nil, 0600); err != nil {
+		t.Fatalf("setup: writing fail file: %v", err)
+	}
+	output = captureStdout(t)
+	_, err = pitstop.ComposeRun("", "api").Run()
+	output()
+	if err == nil {
+		t.Errorf("run() err = nil for a service that failed to start; want an error")
+	}
+	want := []string{"compose version", "compose up --build --detach --wait api"}
+	if got := readCalls(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("docker calls = %q; want %q", got, want)
+	}
+}
+
+func TestComposeRun_notInstalled(t *testing.T) {
+	t.Setenv("PATH", "")
+	_, err := pitstop.ComposeRun("compose.yaml", "api").Run()
+	var notFound *pitstop.CommandNotFoundError
+	if !errors.As(err, &notFound) || notFound.Command != "docker" {
+		t.Errorf("run() err = %v; want a CommandNotFoundError for docker", err)
+	}
+}
@@ -0,0 +1,60 @@
+package pitstop
+
+import "fmt"
+
+// DryRunBuild returns a Step that prints the step described by desc
+// rather than running it. It always returns nil. This is useful for checking
+// the order of a pipeline, eg DryRunBuild("go build -o app .") can stand in for
+// the equivalent BuildCommand while composing Pre and Post.
+func DryRunBuild(desc string) Step {
+	return stepFunc(func(sc stepContext) error {
+		sc.log.infof("Dry run: skipping %s", desc)
+		return nil
+	})
+}
+
+// DryRunRun returns a RunStep that prints the step described by desc rather
+// than running it. It always returns a stop func that does nothing.
+func DryRunRun(desc string) RunStep {
+	return runFunc(func(sc stepContext) (func(), error) {
+		sc.log.infof("Dry run: skipping %s", desc)
+		return func() {}, nil
+	})
+}
+
+// dryRunSteps replaces each of the provided Steps with one that only logs
+// what it would have done. Steps that describe themselves, such as those
+// created by BuildCommand and friends, log their description, and any others
+// log which step they are.
+func dryRunSteps(log logger, phase string, steps []Step) []Step {
+	ret := make([]Step, len(steps))
+	for i, step := range steps {
+		desc := step.Describe()
+		if desc == "" {
+			desc = fmt.Sprintf("skipping %s step %d of %d", phase, i+1, len(steps))
+		}
+		ret[i] = BuildFunc(func() error {
+			log.infof("Dry run: %s", desc)
+			return nil
+		})
+	}
+	return ret
+}
+
+// dryRunRun replaces run, or start if it isn't nil, with a RunStep that only
+// logs what it would have done.
+func dryRunRun(log logger, run RunStep, start ProcessStep) RunStep {
+	var desc string
+	if start != nil {
+		desc = start.Describe()
+	} else if run != nil {
+		desc = run.Describe()
+	}
+	if desc == "" {
+		desc = "skipping run step"
+	}
+	return RunFunc(func() (func(), error) {
+		log.infof("Dry run: %s", desc)
+		return func() {}, nil
+	})
+}
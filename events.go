@@ -0,0 +1,215 @@
+package pitstop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// EventType describes what happened in an Event.
+type EventType string
+
+const (
+	// ChangeDetected is published when the poller notices changed files.
+	ChangeDetected EventType = "change_detected"
+	// BuildStarted is published right before the Pre functions are called.
+	BuildStarted EventType = "build_started"
+	// BuildFinished is published after a build completes or fails.
+	BuildFinished EventType = "build_finished"
+	// AppStopped is published after the running app is stopped.
+	AppStopped EventType = "app_stopped"
+)
+
+// Event describes something that happened while a Poller was running.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// ChangedFiles is set for ChangeDetected events.
+	ChangedFiles []string
+	// Err, Duration, and Result are set for BuildFinished events. Err is nil
+	// if the build was successful. Result records how long each phase of the
+	// build took and which one failed, as with RunWithResult. A build that
+	// only ran the steps of Rules or Handlers, without restarting the app,
+	// only has a PreDuration.
+	Err      error
+	Duration time.Duration
+	Result   RunResult
+	// Port is set for successful BuildFinished events that started an app
+	// created by AutoPort, and is the port the app was given.
+	Port int
+}
+
+// eventBuffer is how many events each subscriber can fall behind by before
+// new events are dropped.
+const eventBuffer = 64
+
+// Events returns a channel that receives every Event published by the poller
+// from now on, along with a cancel func that unsubscribes and closes the
+// channel. Each call returns a new channel. Events are never allowed to stall
+// the poller; if a subscriber falls too far behind, new events are dropped
+// until it catches up. Once the poller stops, every channel is closed after
+// the final AppStopped event, so ranging over one ends when the poller does.
+// Calling cancel after that does nothing.
+func (p *Poller) Events() (<-chan Event, func()) {
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+	return p.subscribe()
+}
+
+// subscribe does the work of Events. eventsMu must be held.
+func (p *Poller) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	p.subscribers = append(p.subscribers, ch)
+	cancel := func() {
+		p.eventsMu.Lock()
+		defer p.eventsMu.Unlock()
+		for i, sub := range p.subscribers {
+			if sub == ch {
+				p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+	return ch, cancel
+}
+
+// startPolling records that the poll loop is running, until closeEvents is
+// called once it returns.
+func (p *Poller) startPolling() {
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+	p.polling = true
+}
+
+// closeEvents closes and removes every subscriber's channel, and records that
+// the poll loop isn't running anymore.
+func (p *Poller) closeEvents() {
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+	for _, ch := range p.subscribers {
+		close(ch)
+	}
+	p.subscribers = nil
+	p.polling = false
+}
+
+// ErrPollerStopped is returned by WaitForBuild if the poller stops before the
+// next build finishes.
+var ErrPollerStopped = errors.New("pitstop: poller stopped")
+
+// ErrPollerNotRunning is returned by WaitForBuild if the poller isn't polling,
+// either because it was never started or because it has already stopped.
+var ErrPollerNotRunning = errors.New("pitstop: poller isn't running")
+
+// WaitForBuild blocks until the poller's next build finishes, and returns its
+// RunResult along with its error, which is nil if the build succeeded. It is
+// meant for scripts and tests that need a fresh build before they carry on,
+// such as running end to end tests once the app has been rebuilt. A build
+// already in progress when WaitForBuild is called counts as the next one.
+// Any number of goroutines can wait at once, and each of them is told about
+// the same build. If ctx is done first ctx.Err() is returned, and if the
+// poller stops first ErrPollerStopped is returned. If the poller isn't
+// polling, ErrPollerNotRunning is returned right away rather than waiting for
+// a build that will never happen.
+func (p *Poller) WaitForBuild(ctx context.Context) (RunResult, error) {
+	// Subscribing while checking polling means either the poller is stopped
+	// or the channel will be closed once it is.
+	p.eventsMu.Lock()
+	if !p.polling {
+		p.eventsMu.Unlock()
+		return RunResult{}, ErrPollerNotRunning
+	}
+	events, cancel := p.subscribe()
+	p.eventsMu.Unlock()
+	defer cancel()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return RunResult{}, ErrPollerStopped
+			}
+			if e.Type == BuildFinished {
+				return e.Result, e.Err
+			}
+		case <-ctx.Done():
+			return RunResult{}, ctx.Err()
+		}
+	}
+}
+
+// publish sends e to every subscriber without blocking, and writes it to
+// JSONOutput if that is set.
+func (p *Poller) publish(e Event) {
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+	p.writeJSON(newJSONEvent(e))
+	for _, ch := range p.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// jsonError is the type of the JSONOutput lines written for each error the
+// poller prints. Errors aren't published as Events, since they are already
+// reported by BuildFinished events and OnError.
+const jsonError = "error"
+
+// jsonEvent is the schema of each line written to JSONOutput. Fields are only
+// included for the types that set them, except that build_finished lines
+// always include duration_ms.
+type jsonEvent struct {
+	Type         string   `json:"type"`
+	Time         string   `json:"time"`
+	ChangedFiles []string `json:"changed_files,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	DurationMS   *float64 `json:"duration_ms,omitempty"`
+	Port         int      `json:"port,omitempty"`
+}
+
+// newJSONEvent converts e to the schema used by JSONOutput.
+func newJSONEvent(e Event) jsonEvent {
+	je := jsonEvent{
+		Type:         string(e.Type),
+		Time:         formatJSONTime(e.Time),
+		ChangedFiles: e.ChangedFiles,
+		Port:         e.Port,
+	}
+	if e.Err != nil {
+		je.Error = e.Err.Error()
+	}
+	if e.Type == BuildFinished {
+		ms := float64(e.Duration) / float64(time.Millisecond)
+		je.DurationMS = &ms
+	}
+	return je
+}
+
+// formatJSONTime formats t the way every JSONOutput line does, in UTC.
+func formatJSONTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// logJSONError writes an error line for msg to JSONOutput if it is set.
+func (p *Poller) logJSONError(t time.Time, msg string) {
+	p.eventsMu.Lock()
+	defer p.eventsMu.Unlock()
+	p.writeJSON(jsonEvent{Type: jsonError, Time: formatJSONTime(t), Error: msg})
+}
+
+// writeJSON writes je as a single line to JSONOutput if it is set. It must be
+// called with eventsMu held so that lines are never interleaved. Write
+// errors are ignored, the same as for the poller's other output.
+func (p *Poller) writeJSON(je jsonEvent) {
+	if p.JSONOutput == nil {
+		return
+	}
+	b, err := json.Marshal(je)
+	if err != nil {
+		return
+	}
+	p.JSONOutput.Write(append(b, '\n'))
+}
@@ -0,0 +1,335 @@
+package pitstop_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestPoller_Events(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	errBuild := errors.New("build failed")
+	var fail bool
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Pre: []pitstop.Step{
+			pitstop.BuildFunc(func() error {
+				if fail {
+					return errBuild
+				}
+				fail = true
+				return nil
+			}),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			return func() {}, nil
+		}),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	next := func() pitstop.Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for an event")
+		}
+		return pitstop.Event{}
+	}
+
+	if e := next(); e.Type != pitstop.BuildStarted {
+		t.Errorf("event.Type = %v; want %v", e.Type, pitstop.BuildStarted)
+	}
+	if e := next(); e.Type != pitstop.BuildFinished || e.Err != nil {
+		t.Errorf("event = %+v; want a successful %v event", e, pitstop.BuildFinished)
+	}
+
+	touch(t, dir, "main.go")
+	e := next()
+	if e.Type != pitstop.ChangeDetected {
+		t.Fatalf("event.Type = %v; want %v", e.Type, pitstop.ChangeDetected)
+	}
+	if want := []string{filepath.Join(dir, "main.go")}; !reflect.DeepEqual(e.ChangedFiles, want) {
+		t.Errorf("event.ChangedFiles = %v; want %v", e.ChangedFiles, want)
+	}
+	if e := next(); e.Type != pitstop.AppStopped {
+		t.Errorf("event.Type = %v; want %v", e.Type, pitstop.AppStopped)
+	}
+	if e := next(); e.Type != pitstop.BuildStarted {
+		t.Errorf("event.Type = %v; want %v", e.Type, pitstop.BuildStarted)
+	}
+	if e := next(); e.Type != pitstop.BuildFinished || !errors.Is(e.Err, errBuild) {
+		t.Errorf("event = %+v; want a failed %v event", e, pitstop.BuildFinished)
+	}
+	p.Stop()
+}
+
+func TestPoller_JSONOutput(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	var fail bool
+	var jsonOut, logOut syncBuffer
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Pre: []pitstop.Step{
+			pitstop.BuildFunc(func() error {
+				if fail {
+					return errors.New("build failed")
+				}
+				fail = true
+				return nil
+			}),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			return func() {}, nil
+		}),
+		JSONOutput: &jsonOut,
+		LogOutput:  &logOut,
+	}
+	output := captureStdout(t)
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		output()
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	// Wait for the initial build before changing a file.
+	for e := range events {
+		if e.Type == pitstop.BuildFinished {
+			break
+		}
+	}
+	touch(t, dir, "main.go")
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(jsonOut.String(), "\n") < 7 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Stop()
+	if got := output(); got != "" {
+		t.Errorf("stdout = %q; want everything printed to LogOutput", got)
+	}
+	if got := logOut.String(); !strings.Contains(got, "Error running: build failed") {
+		t.Errorf("LogOutput = %q; want it to contain the build error", got)
+	}
+
+	// The touched file is still newer than the last build, so it may have
+	// triggered more builds before the poller stopped.
+	lines := strings.Split(strings.TrimSuffix(jsonOut.String(), "\n"), "\n")
+	if len(lines) > 7 {
+		lines = lines[:7]
+	}
+	var got []map[string]interface{}
+	for _, line := range lines {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("JSONOutput line %q isn't valid JSON: %v", line, err)
+		}
+		ts, ok := fields["time"].(string)
+		if _, err := time.Parse(time.RFC3339Nano, ts); !ok || err != nil {
+			t.Errorf("line %q has time %v; want an RFC 3339 time", line, fields["time"])
+		}
+		delete(fields, "time")
+		if _, ok := fields["duration_ms"].(float64); ok {
+			fields["duration_ms"] = "set"
+		}
+		got = append(got, fields)
+	}
+	want := []map[string]interface{}{
+		{"type": "build_started"},
+		{"type": "build_finished", "duration_ms": "set"},
+		{"type": "change_detected", "changed_files": []interface{}{filepath.Join(dir, "main.go")}},
+		{"type": "app_stopped"},
+		{"type": "build_started"},
+		{"type": "build_finished", "duration_ms": "set", "error": "build failed"},
+		{"type": "error", "error": "Error running: build failed"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSONOutput lines = %v; want %v", got, want)
+	}
+}
+
+func TestPoller_Events_closed(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			return func() {}, nil
+		}),
+	}
+	events, _ := p.Events()
+	canceled, cancel := p.Events()
+	cancel()
+	if _, ok := <-canceled; ok {
+		t.Errorf("receive from canceled channel ok = true; want it closed")
+	}
+	// Calling cancel again, or after the poller stops, must not panic.
+	cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+
+	var last pitstop.Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if e.Type == pitstop.BuildFinished {
+				// Stop once the app is running, so it is stopped on the way out.
+				go p.Stop()
+			}
+			if !ok {
+				if last.Type != pitstop.AppStopped {
+					t.Errorf("last event.Type = %v; want %v", last.Type, pitstop.AppStopped)
+				}
+				return
+			}
+			last = e
+		case <-timeout:
+			t.Fatalf("events channel wasn't closed after Stop")
+		}
+	}
+}
+
+func TestPoller_WaitForBuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	errBuild := errors.New("build failed")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var builds int
+	p := pitstop.Poller{
+		Dir:         dir,
+		DisableScan: true,
+		Pre: []pitstop.Step{
+			pitstop.BuildFunc(func() error {
+				builds++
+				started <- struct{}{}
+				<-release
+				if builds > 1 {
+					return errBuild
+				}
+				return nil
+			}),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			return func() {}, nil
+		}),
+	}
+	type result struct {
+		result pitstop.RunResult
+		err    error
+	}
+	// wait starts n goroutines waiting for the next build, and gives them
+	// time to start waiting before it returns.
+	wait := func(n int) <-chan result {
+		results := make(chan result, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				r, err := p.WaitForBuild(context.Background())
+				results <- result{r, err}
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		return results
+	}
+	next := func(results <-chan result) result {
+		t.Helper()
+		select {
+		case r := <-results:
+			return r
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for WaitForBuild to return")
+		}
+		return result{}
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-started
+	// Every waiter is told about the build that is in progress.
+	results := wait(3)
+	release <- struct{}{}
+	for i := 0; i < 3; i++ {
+		if r := next(results); r.err != nil || r.result.FailedPhase != "" {
+			t.Errorf("WaitForBuild() = %+v, %v; want a successful build", r.result, r.err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.WaitForBuild(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForBuild() with no build err = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitForBuild() took %v to return after its deadline", elapsed)
+	}
+
+	results = wait(1)
+	p.Trigger()
+	<-started
+	release <- struct{}{}
+	if r := next(results); !errors.Is(r.err, errBuild) || r.result.FailedPhase != "pre" {
+		t.Errorf("WaitForBuild() = %+v, %v; want a build that failed in pre with %v", r.result, r.err, errBuild)
+	}
+
+	results = wait(1)
+	p.Stop()
+	if r := next(results); !errors.Is(r.err, pitstop.ErrPollerStopped) {
+		t.Errorf("WaitForBuild() once the poller stopped err = %v; want %v", r.err, pitstop.ErrPollerStopped)
+	}
+	if r := next(wait(1)); !errors.Is(r.err, pitstop.ErrPollerNotRunning) {
+		t.Errorf("WaitForBuild() after the poller stopped err = %v; want %v", r.err, pitstop.ErrPollerNotRunning)
+	}
+}
+
+func TestPoller_WaitForBuild_notStarted(t *testing.T) {
+	p := pitstop.Poller{
+		Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.WaitForBuild(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, pitstop.ErrPollerNotRunning) {
+			t.Errorf("WaitForBuild() err = %v; want %v", err, pitstop.ErrPollerNotRunning)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("WaitForBuild() on a poller that was never started didn't return")
+	}
+}
@@ -0,0 +1,57 @@
+package pitstop
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// BuildCommandEnv works like BuildCommand, but every time the Step runs,
+// references to variables such as ${GOOS} or $GOOS in command and args are
+// replaced with their values, so changing a variable takes effect on the next
+// build without restarting pitstop. Values are looked up in env, or in the
+// environment if env is nil. A variable that isn't set is replaced with an
+// empty string, and "$$" is replaced with a single "$". Each arg is expanded
+// on its own and never split into more args, even if its value contains
+// spaces, since no shell is involved. For example:
+//
+//	pitstop.BuildCommandEnv(nil, "go", "build", "-o", "./tmp/app-${GOOS}", ".")
+//
+// Since every "$" is treated as the start of a reference, BuildCommand should
+// be used instead of BuildCommandEnv for args that contain a "$" of their own,
+// such as a regexp. A command that contains a reference isn't checked for on
+// PATH before polling starts.
+func BuildCommandEnv(env map[string]string, command string, args ...string) Step {
+	return describeBuild(BuildFunc(func() error {
+		command, args := expandCommand(env, command, args)
+		return buildCommand(exec.Command(command, args...), nil, nil, command, args)
+	}), command, args)
+}
+
+// RunCommandEnv works like BuildCommandEnv, but for RunCommand. The command
+// and args are expanded every time the app is started.
+func RunCommandEnv(env map[string]string, command string, args ...string) RunStep {
+	return describeRun(runFunc(func(sc stepContext) (func(), error) {
+		command, args := expandCommand(env, command, args)
+		return runProcess(processCommand(context.Background(), RunOptions{}, command, args, nil))(sc)
+	}), command, args)
+}
+
+// expandCommand returns command and args with the variables they reference
+// replaced as described by BuildCommandEnv.
+func expandCommand(env map[string]string, command string, args []string) (string, []string) {
+	lookup := func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if env == nil {
+			return os.Getenv(name)
+		}
+		return env[name]
+	}
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = os.Expand(arg, lookup)
+	}
+	return os.Expand(command, lookup), expanded
+}
@@ -0,0 +1,53 @@
+package pitstop_test
+
+import (
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestBuildCommandEnv(t *testing.T) {
+	t.Setenv("PITSTOP_GREETING", "hello there")
+	t.Setenv("PITSTOP_FORMAT", "%s|")
+	build := pitstop.BuildCommandEnv(nil, "printf", "${PITSTOP_FORMAT}", "$PITSTOP_GREETING", "${PITSTOP_UNSET}x", "$$5")
+	output := captureStdout(t)
+	err := build.Build()
+	if err == nil {
+		// Variables are looked up again every time the step runs.
+		t.Setenv("PITSTOP_GREETING", "goodbye")
+		err = build.Build()
+	}
+	got := output()
+	if err != nil {
+		t.Fatalf("build() err = %v; want nil", err)
+	}
+	if want := "hello there|x|$5|goodbye|x|$5|"; got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+
+	// A map replaces the environment entirely.
+	env := map[string]string{"FORMAT": "<%s>", "NAME": "map"}
+	output = captureStdout(t)
+	err = pitstop.BuildCommandEnv(env, "printf", "$FORMAT", "$NAME", "$PITSTOP_GREETING").Build()
+	got = output()
+	if err != nil {
+		t.Fatalf("build() with a map err = %v; want nil", err)
+	}
+	if want := "<map><>"; got != want {
+		t.Errorf("output with a map = %q; want %q", got, want)
+	}
+}
+
+func TestRunCommandEnv(t *testing.T) {
+	t.Setenv("PITSTOP_APP", "tail")
+	stop, err := pitstop.RunCommandEnv(nil, "${PITSTOP_APP}").Run()
+	if err != nil {
+		t.Fatalf("RunCommandEnv() err = %v; want nil", err)
+	}
+	stop()
+
+	t.Setenv("PITSTOP_APP", "pitstop-missing-command")
+	if _, err := pitstop.RunCommandEnv(nil, "${PITSTOP_APP}").Run(); err == nil {
+		t.Errorf("RunCommandEnv() with a missing command err = nil; want an error")
+	}
+}
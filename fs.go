@@ -0,0 +1,56 @@
+package pitstop
+
+import (
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// DidChangeFS works like DidChange, but scans fsys rather than a directory on
+// disk. This makes it possible to check an embedded or in-memory filesystem,
+// such as a testing/fstest.MapFS, as long as it reports file mtimes.
+func DidChangeFS(fsys fs.FS, since time.Time) bool {
+	return didChangeFS(fsys, since, -1)
+}
+
+// didChangeFS is used by DidChangeFS and DidChangeMaxDepth. A negative
+// maxDepth means there is no limit.
+func didChangeFS(fsys fs.FS, since time.Time, maxDepth int) bool {
+	var changed bool
+	fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == "." {
+				return err
+			}
+			// Skip anything that can't be read, such as a directory without
+			// read permission, rather than missing changes everywhere else.
+			return nil
+		}
+		if d.IsDir() {
+			if maxDepth >= 0 && fsDepth(path) > maxDepth {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// The file was removed after its directory was read.
+			return nil
+		}
+		if info.ModTime().After(since) {
+			changed = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return changed
+}
+
+// fsDepth returns how many directories deep the slash separated path is in
+// an fs.FS. The root, ".", has a depth of 0.
+func fsDepth(path string) int {
+	if path == "." {
+		return 0
+	}
+	return strings.Count(path, "/") + 1
+}
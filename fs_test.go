@@ -0,0 +1,84 @@
+package pitstop_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestDidChangeFS(t *testing.T) {
+	since := time.Now()
+	before, after := since.Add(-time.Hour), since.Add(time.Hour)
+	for name, tc := range map[string]struct {
+		fsys fstest.MapFS
+		want bool
+	}{
+		"no changes": {
+			fsys: fstest.MapFS{
+				"main.go":         {ModTime: before},
+				"web/index.html":  {ModTime: before},
+				"web/css/app.css": {ModTime: before},
+			},
+			want: false,
+		},
+		"changed file": {
+			fsys: fstest.MapFS{
+				"main.go":        {ModTime: before},
+				"web/index.html": {ModTime: after},
+			},
+			want: true,
+		},
+		"nested change": {
+			fsys: fstest.MapFS{
+				"main.go":         {ModTime: before},
+				"web/css/app.css": {ModTime: after},
+			},
+			want: true,
+		},
+		"empty": {
+			fsys: fstest.MapFS{},
+			want: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := pitstop.DidChangeFS(tc.fsys, since); got != tc.want {
+				t.Errorf("DidChangeFS() = %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// unreadableFS is a MapFS where the directory named bad can't be read.
+type unreadableFS struct {
+	fstest.MapFS
+	bad string
+}
+
+func (u unreadableFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == u.bad {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrPermission}
+	}
+	return u.MapFS.ReadDir(name)
+}
+
+func TestDidChangeFS_unreadable(t *testing.T) {
+	since := time.Now()
+	fsys := unreadableFS{
+		MapFS: fstest.MapFS{
+			"a.go":             {ModTime: since.Add(-time.Hour)},
+			"secret/secret.go": {ModTime: since.Add(-time.Hour)},
+			"z.go":             {ModTime: since.Add(time.Hour)},
+		},
+		bad: "secret",
+	}
+	if _, err := fs.ReadDir(fsys, "secret"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("setup: ReadDir(secret) err = %v; want a permission error", err)
+	}
+	if !pitstop.DidChangeFS(fsys, since) {
+		t.Errorf("DidChangeFS() = false; want the change after the unreadable directory to be found")
+	}
+}
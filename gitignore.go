@@ -0,0 +1,202 @@
+package pitstop
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern from a .gitignore style file.
+type ignoreRule struct {
+	// segments is the pattern split on "/". Unanchored patterns are prefixed
+	// with a "**" segment so they can match at any depth.
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// readIgnoreFile reads and parses the .gitignore style file at path.
+func readIgnoreFile(path string) ([]ignoreRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseIgnore(f)
+}
+
+// LoadIgnoreFile reads the .gitignore style patterns from the file at path,
+// such as a .pitstopignore file, so they can be used as the Ignore or Include
+// patterns of a Poller or Watcher. Blank lines and comments starting with "#"
+// are skipped.
+func LoadIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading ignore file: %w", err)
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if _, ok := parseIgnoreRule(line); ok {
+			patterns = append(patterns, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error loading ignore file %q: %w", path, err)
+	}
+	return patterns, nil
+}
+
+// parseIgnore parses .gitignore style rules from r, skipping blank lines and
+// comments.
+func parseIgnore(r io.Reader) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rule, ok := parseIgnoreRule(scanner.Text())
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseIgnoreRule parses a single .gitignore style pattern. false is returned
+// if the line doesn't contain a pattern.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	line = strings.TrimRight(line, "\r")
+	// Trailing spaces are ignored unless they are escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	// A pattern containing a slash anywhere but the end is relative to the
+	// directory the ignore file is in. Otherwise it can match at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	// path.Match uses ^ to negate a character class, while gitignore uses !.
+	line = strings.Replace(line, "[!", "[^", -1)
+	rule.segments = strings.Split(line, "/")
+	if !anchored {
+		rule.segments = append([]string{"**"}, rule.segments...)
+	}
+	return rule, true
+}
+
+// match reports whether the rule matches rel, a slash separated path relative
+// to the directory the rule was defined in.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where a "**"
+// pattern segment matches zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], segments[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// ignored reports whether path, found while walking root, is matched by any
+// of the ignore rules loaded for its parent directories. Rules closer to path
+// take precedence, as do later rules within the same file.
+func ignored(ignores map[string][]ignoreRule, root, path string, isDir bool) bool {
+	if len(ignores) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	var ignore bool
+	dir := root
+	for i := range segments {
+		sub := strings.Join(segments[i:], "/")
+		if matched, ok := matchRules(ignores[dir], sub, isDir); ok {
+			ignore = matched
+		}
+		dir = filepath.Join(dir, segments[i])
+	}
+	return ignore
+}
+
+// matchRules matches rel against each of the rules in order. The last rule to
+// match decides the result, so a negated rule can undo an earlier match. ok is
+// false if none of the rules match.
+func matchRules(rules []ignoreRule, rel string, isDir bool) (matched, ok bool) {
+	for _, rule := range rules {
+		if rule.match(rel, isDir) {
+			matched, ok = !rule.negate, true
+		}
+	}
+	return matched, ok
+}
+
+// matchFile reports whether the file at rel, a slash separated path, is
+// matched by rules either directly or because one of its parent directories
+// is, which mirrors how a .gitignore rule like "build/" covers every file in
+// the build directory.
+func matchFile(rules []ignoreRule, rel string) bool {
+	segments := strings.Split(rel, "/")
+	for i := 1; i < len(segments); i++ {
+		if matched, _ := matchRules(rules, strings.Join(segments[:i], "/"), true); matched {
+			return true
+		}
+	}
+	matched, _ := matchRules(rules, rel, false)
+	return matched
+}
+
+// parseIgnorePatterns parses each of the provided .gitignore style patterns.
+func parseIgnorePatterns(patterns []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		rule, ok := parseIgnoreRule(pattern)
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,237 @@
+package pitstop
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Go returns a Poller for the common case of rebuilding and restarting a Go
+// app whenever a file in dir changes. Each build runs "go build -o binOut ."
+// inside dir, then the binary is run with runArgs. binOut is relative to the
+// current directory and defaults to tmp/app inside dir. It is excluded from
+// the scan so the build's output doesn't trigger another build.
+//
+// The returned Poller can be customized further before calling Poll:
+//
+//	p := pitstop.Go(".", "./tmp/app", "-port", "3000")
+//	p.Ignore = []string{"node_modules/"}
+//	if err := p.Poll(); err != nil {
+//		log.Fatal(err)
+//	}
+func Go(dir, binOut string, runArgs ...string) *Poller {
+	return GoPackage(dir, ".", binOut, runArgs...)
+}
+
+// GoPackage works like Go, but builds pkg rather than the package in dir. pkg
+// is resolved relative to dir, just like it would be if "go build" was run
+// from inside dir, so it can be a path such as "./cmd/server" or an import
+// path.
+func GoPackage(dir, pkg, binOut string, runArgs ...string) *Poller {
+	return GoWith(dir, GoBuildOptions{Package: pkg, Output: binOut}, runArgs...)
+}
+
+// GoBuildOptions are used to customize how GoWith builds an app.
+type GoBuildOptions struct {
+	// Package is the package to build, resolved relative to the directory
+	// being built. This defaults to ".".
+	Package string
+
+	// Output is where the binary is written. It is relative to the current
+	// directory and defaults to tmp/app inside the directory being built.
+	Output string
+
+	// Tags is a list of build tags, such as "dev", passed using -tags.
+	Tags []string
+
+	// Ldflags is passed to the build as the value of -ldflags, such as
+	// "-X main.version=1.2.3". It is passed as a single argument without
+	// involving a shell, so it doesn't need to be quoted.
+	Ldflags string
+
+	// Env is a list of additional environment variables, in the form
+	// "KEY=value", used when running go build, such as "CGO_ENABLED=0".
+	Env []string
+
+	// AtomicOutput builds each binary to a new file and only moves it to
+	// Output once the build succeeds, so a failed build never leaves a
+	// partial binary behind and the build never writes to the binary the app
+	// is running from, which can fail with "text file busy". On Windows a
+	// running binary can't be replaced, so each successful build is run from
+	// its own file instead, and older builds are removed once they are no
+	// longer running. Builds are written to a directory next to Output, named
+	// after it with ".builds" added, which is excluded from the scan.
+	AtomicOutput bool
+}
+
+// GoWith works like Go, but uses opts to customize the build.
+func GoWith(dir string, opts GoBuildOptions, runArgs ...string) *Poller {
+	if dir == "" {
+		dir = "."
+	}
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = "."
+	}
+	binOut := opts.Output
+	if binOut == "" {
+		binOut = filepath.Join(dir, "tmp", "app")
+	}
+	// The binary is built from inside dir, and an absolute path also keeps
+	// exec from looking up a bare name like "app" in $PATH.
+	if abs, err := filepath.Abs(binOut); err == nil {
+		binOut = abs
+	}
+	goArgs := func(out string) []string {
+		args := []string{"build", "-o", out}
+		if len(opts.Tags) > 0 {
+			args = append(args, "-tags", strings.Join(opts.Tags, ","))
+		}
+		if opts.Ldflags != "" {
+			args = append(args, "-ldflags", opts.Ldflags)
+		}
+		return append(args, pkg)
+	}
+	goBuild := func(out string) error {
+		args := goArgs(out)
+		cmd := exec.Command("go", args...)
+		cmd.Dir = dir
+		if len(opts.Env) > 0 {
+			cmd.Env = append(os.Environ(), opts.Env...)
+		}
+		return buildCommand(cmd, nil, nil, "go", args)
+	}
+	args := goArgs(binOut)
+	build := describeBuild(BuildFunc(func() error {
+		return goBuild(binOut)
+	}), "go", args)
+	run := RunCommand(binOut, runArgs...)
+	exclude := []string{binOut}
+	if opts.AtomicOutput {
+		out := &atomicOutput{path: binOut, dir: binOut + ".builds"}
+		build = describeBuild(BuildFunc(func() error {
+			return out.build(goBuild)
+		}), "go", args)
+		run = describeRun(out.run(runArgs), binOut, runArgs)
+		exclude = append(exclude, out.dir)
+	}
+	return &Poller{
+		Dir:            dir,
+		ExcludeOutputs: exclude,
+		Pre:            []Step{build},
+		Run:            run,
+	}
+}
+
+// atomicOutput builds binaries for GoBuildOptions.AtomicOutput. Each build is
+// written to a new file in dir, and then moved to path once it succeeds.
+type atomicOutput struct {
+	path string
+	dir  string
+
+	mu sync.Mutex
+	// current is the binary the next run starts, which is only different
+	// from path on Windows.
+	current string
+}
+
+// build calls goBuild to build a binary to a new file in dir, and then puts
+// it in place as described by AtomicOutput.
+func (ao *atomicOutput) build(goBuild func(out string) error) error {
+	if err := os.MkdirAll(ao.dir, 0755); err != nil {
+		return fmt.Errorf("error building: %w", err)
+	}
+	ext := filepath.Ext(ao.path)
+	f, err := ioutil.TempFile(ao.dir, strings.TrimSuffix(filepath.Base(ao.path), ext)+"-*"+ext)
+	if err != nil {
+		return fmt.Errorf("error building: %w", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := goBuild(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+	if runtime.GOOS == "windows" {
+		ao.current = tmp
+		ao.removeOldBuilds()
+		return nil
+	}
+	// Renaming over a running binary is fine everywhere else, since the app
+	// keeps the file it was started from open.
+	if err := os.Rename(tmp, ao.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error replacing %q: %w", ao.path, err)
+	}
+	ao.current = ao.path
+	return nil
+}
+
+// removeOldBuilds removes every build in dir other than the current one.
+// Builds that are still running can't be removed on Windows, so they are left
+// for a later build to remove.
+func (ao *atomicOutput) removeOldBuilds() {
+	entries, err := ioutil.ReadDir(ao.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(ao.dir, entry.Name())
+		if path != ao.current {
+			os.Remove(path)
+		}
+	}
+}
+
+// run returns a RunStep that starts the most recently built binary with args.
+func (ao *atomicOutput) run(args []string) RunStep {
+	return runFunc(func(sc stepContext) (func(), error) {
+		ao.mu.Lock()
+		current := ao.current
+		ao.mu.Unlock()
+		if current == "" {
+			// Nothing has been built yet, so run whatever is at path.
+			current = ao.path
+		}
+		return runProcess(processCommand(context.Background(), RunOptions{}, current, args, nil))(sc)
+	})
+}
+
+// GoGenerate returns a Step that runs "go generate" for each of pkgs, or
+// for "./..." if none are provided. Generating code on every rebuild can be
+// slow, so consider wrapping it in RunWhenChanged with the files that feed
+// generation as its inputs.
+func GoGenerate(pkgs ...string) Step {
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	return BuildCommand("go", append([]string{"generate"}, pkgs...)...)
+}
+
+// TestGate returns a Step that runs "go test" for each of pkgs, or for
+// "./..." if none are provided, and returns an error if any of the tests
+// fail. Steps after it in Pre aren't run when it fails, so a broken test suite
+// keeps the app from being rebuilt:
+//
+//	pre := []pitstop.Step{
+//		pitstop.TestGate("./..."),
+//		pitstop.BuildCommand("go", "build", "-o", "./tmp/app", "."),
+//	}
+//
+// A Poller stops the running app before running Pre, so a failing test leaves
+// no app running until the tests pass again, unless
+// Poller.KeepLastGoodOnFailure is set to keep the previous app running.
+func TestGate(pkgs ...string) Step {
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	return BuildCommand("go", append([]string{"test"}, pkgs...)...)
+}
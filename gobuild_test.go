@@ -0,0 +1,276 @@
+package pitstop_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestGoPackage(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod":             "module example.com/app\n",
+		"cmd/server/main.go": "package main\n\nimport \"os\"\n\nfunc main() { os.WriteFile(os.Args[1], nil, 0600) }\n",
+	})
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "bin", "server")
+	ran := filepath.Join(dir, "ran")
+
+	p := pitstop.GoPackage(dir, "./cmd/server", bin, ran)
+	if len(p.ExcludeOutputs) != 1 || p.ExcludeOutputs[0] != bin {
+		t.Errorf("ExcludeOutputs = %v; want [%s]", p.ExcludeOutputs, bin)
+	}
+	stop, err := pitstop.Run(p.Pre, p.Run, p.Post)
+	if err != nil {
+		t.Fatalf("Run() err = %v; want nil", err)
+	}
+	defer stop()
+	if _, err := os.Stat(bin); err != nil {
+		t.Fatalf("binary wasn't built: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(ran); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("app wasn't run with its args")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGoWith(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod": "module example.com/app\n",
+		"main.go": "package main\n\nimport \"os\"\n\nvar mode, version string\n\n" +
+			"func main() { os.WriteFile(os.Args[1], []byte(mode+\" \"+version), 0600) }\n",
+		"dev.go": "//go:build dev\n\npackage main\n\nfunc init() { mode = \"dev\" }\n",
+	})
+	defer os.RemoveAll(dir)
+	ran := filepath.Join(dir, "ran")
+
+	p := pitstop.GoWith(dir, pitstop.GoBuildOptions{
+		Output:  filepath.Join(dir, "app"),
+		Tags:    []string{"dev"},
+		Ldflags: "-X 'main.version=1.2.3 beta'",
+		Env:     []string{"CGO_ENABLED=0"},
+	}, ran)
+	stop, err := pitstop.Run(p.Pre, p.Run, p.Post)
+	if err != nil {
+		t.Fatalf("Run() err = %v; want nil", err)
+	}
+	defer stop()
+	var got []byte
+	deadline := time.Now().Add(2 * time.Second)
+	for len(got) == 0 && time.Now().Before(deadline) {
+		got, _ = ioutil.ReadFile(ran)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := "dev 1.2.3 beta"; string(got) != want {
+		t.Errorf("app output = %q; want %q", got, want)
+	}
+}
+
+func TestGoGenerate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod":  "module example.com/app\n",
+		"main.go": "package main\n\n//go:generate sh -c \"echo package main > generated.go\"\n\nfunc main() {}\n",
+	})
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("setup: getting working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("setup: changing working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	for _, pkgs := range [][]string{nil, {"."}} {
+		out := filepath.Join(dir, "generated.go")
+		os.Remove(out)
+		if err := pitstop.GoGenerate(pkgs...).Build(); err != nil {
+			t.Fatalf("GoGenerate(%q)() err = %v; want nil", pkgs, err)
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatalf("GoGenerate(%q)() didn't generate a file: %v", pkgs, err)
+		}
+		if want := "package main\n"; string(b) != want {
+			t.Errorf("generated file = %q; want %q", b, want)
+		}
+	}
+}
+
+func TestTestGate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"main.go":      "package main\n\nfunc main() {}\n",
+		"main_test.go": "package main\n\nimport \"testing\"\n\nfunc TestApp(t *testing.T) {}\n",
+	})
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("setup: getting working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("setup: changing working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	var runs, stops int
+	run := pitstop.RunFunc(func() (func(), error) {
+		runs++
+		return func() { stops++ }, nil
+	})
+	output := captureStdout(t)
+	stop, err := pitstop.Run([]pitstop.Step{pitstop.TestGate()}, run, nil)
+	if err != nil {
+		output()
+		t.Fatalf("Run() with passing tests err = %v; want nil", err)
+	}
+	if runs != 1 {
+		t.Errorf("app started %d times with passing tests; want 1", runs)
+	}
+
+	failing := "package main\n\nimport \"testing\"\n\nfunc TestApp(t *testing.T) { t.Fatal(\"broken\") }\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "main_test.go"), []byte(failing), 0600); err != nil {
+		output()
+		t.Fatalf("setup: writing failing test: %v", err)
+	}
+	_, err = pitstop.Run([]pitstop.Step{pitstop.TestGate(".")}, run, nil)
+	got := output()
+	if err == nil {
+		t.Errorf("Run() with failing tests err = nil; want an error")
+	}
+	if runs != 1 || stops != 0 {
+		t.Errorf("with failing tests the app was started %d times and stopped %d times; want 1 and 0", runs, stops)
+	}
+	if !strings.Contains(got, "broken") {
+		t.Errorf("output = %q; want it to contain the test failure", got)
+	}
+	stop()
+}
+
+func TestTestGate_KeepLastGoodOnFailure(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"main.go":      "package main\n\nfunc main() {}\n",
+		"main_test.go": "package main\n\nimport \"testing\"\n\nfunc TestApp(t *testing.T) {}\n",
+	})
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("setup: getting working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("setup: changing working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	var mu sync.Mutex
+	var runs, stops int
+	clock := newFakeClock(time.Now())
+	p := &pitstop.Poller{
+		Dir:                   dir,
+		ScanInterval:          time.Hour,
+		Clock:                 clock,
+		KeepLastGoodOnFailure: true,
+		Pre:                   []pitstop.Step{pitstop.TestGate(".")},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs++
+			return func() {
+				mu.Lock()
+				defer mu.Unlock()
+				stops++
+			}, nil
+		}),
+	}
+	output := captureStdout(t)
+	if err := p.Start(); err != nil {
+		output()
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	clock.waitForBlock(t)
+
+	failing := "package main\n\nimport \"testing\"\n\nfunc TestApp(t *testing.T) { t.Fatal(\"broken\") }\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "main_test.go"), []byte(failing), 0600); err != nil {
+		p.Stop()
+		output()
+		t.Fatalf("setup: writing failing test: %v", err)
+	}
+	p.Trigger()
+	clock.waitForBlock(t)
+	running := p.Running()
+	mu.Lock()
+	gotRuns, gotStops := runs, stops
+	mu.Unlock()
+	p.Stop()
+	got := output()
+
+	if !running || gotRuns != 1 || gotStops != 0 {
+		t.Errorf("after failing tests Running() = %v, and the app was started %d times and stopped %d times; want true, 1, and 0", running, gotRuns, gotStops)
+	}
+	if !strings.Contains(got, "broken") {
+		t.Errorf("output = %q; want it to contain the test failure", got)
+	}
+}
+
+func TestGoWith_AtomicOutput(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod":  "module example.com/app\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "tmp", "app")
+
+	p := pitstop.GoWith(dir, pitstop.GoBuildOptions{Output: bin, AtomicOutput: true})
+	builds := bin + ".builds"
+	if want := []string{bin, builds}; !reflect.DeepEqual(p.ExcludeOutputs, want) {
+		t.Errorf("ExcludeOutputs = %v; want %v", p.ExcludeOutputs, want)
+	}
+	if err := pitstop.Chain(p.Pre...).Build(); err != nil {
+		t.Fatalf("build err = %v; want nil", err)
+	}
+	before, err := os.Stat(bin)
+	if err != nil {
+		t.Fatalf("binary wasn't built: %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(builds); len(entries) != 0 {
+		t.Errorf("%d files left in %s; want the build moved into place", len(entries), builds)
+	}
+
+	// A failed build leaves the previous binary alone.
+	err = ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {\n"), 0600)
+	if err != nil {
+		t.Fatalf("setup: writing main.go: %v", err)
+	}
+	if err := pitstop.Chain(p.Pre...).Build(); err == nil {
+		t.Fatalf("build err = nil; want a build error")
+	}
+	after, err := os.Stat(bin)
+	if err != nil {
+		t.Fatalf("binary was removed by a failed build: %v", err)
+	}
+	if !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("binary was replaced by a failed build")
+	}
+	if entries, _ := ioutil.ReadDir(builds); len(entries) != 0 {
+		t.Errorf("%d files left in %s after a failed build; want none", len(entries), builds)
+	}
+
+	stop, err := p.Run.Run()
+	if err != nil {
+		t.Fatalf("Run() err = %v; want nil", err)
+	}
+	stop()
+}
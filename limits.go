@@ -0,0 +1,62 @@
+package pitstop
+
+import "os/exec"
+
+// Limits constrains the resources a command and every process it starts can
+// use, so a runaway build or app can't take down the rest of the machine. They
+// are enforced by putting the command in a new cgroup, which is only
+// supported on Linux with cgroup v2. On other platforms Limits are ignored.
+//
+// The cgroup is created inside Parent, which has to have the memory and cpu
+// controllers available. Most distros mount cgroup v2 at /sys/fs/cgroup, but
+// an unprivileged user can normally only create cgroups inside one delegated
+// to them, such as one made by running pitstop with:
+//
+//	systemd-run --user --scope -p Delegate=yes pitstop
+//
+// and the kernel doesn't allow the cgroup a process is in to enable
+// controllers for its children unless it is the root cgroup, so pitstop's own
+// cgroup usually can't be used as Parent. An error describing what is missing
+// is returned when the command is started if the cgroup can't be set up. The
+// cgroup is removed once the command exits, after killing anything it
+// started that is still running.
+type Limits struct {
+	// MemoryBytes is the most memory the processes can use together. If they
+	// need more than that they are killed by the kernel's out of memory
+	// killer. 0 means there is no limit.
+	MemoryBytes int64
+
+	// CPUs is how many CPUs worth of time the processes can use together,
+	// such as 1.5 for one and a half CPUs. They are slowed down rather than
+	// killed when they hit the limit. 0 means there is no limit.
+	CPUs float64
+
+	// Parent is the cgroup v2 directory the command's cgroup is created in,
+	// such as "/sys/fs/cgroup/pitstop". It defaults to the cgroup pitstop is
+	// running in.
+	Parent string
+}
+
+// enabled reports whether any limits are set.
+func (l Limits) enabled() bool {
+	return l.MemoryBytes > 0 || l.CPUs > 0
+}
+
+// BuildCommandLimits works like BuildCommand, but the command and every
+// process it starts are constrained by limits. See Limits for what that
+// requires.
+func BuildCommandLimits(limits Limits, command string, args ...string) Step {
+	return describeBuild(stepFunc(func(sc stepContext) error {
+		cmd := exec.Command(command, args...)
+		if limits.enabled() {
+			cg, err := newCgroup(limits)
+			if err != nil {
+				return &BuildError{Command: command, Args: args, ExitCode: -1, Err: err}
+			}
+			defer cg.remove(sc.log)
+			cg.apply(cmd)
+			defer cg.started()
+		}
+		return buildCommand(cmd, nil, nil, command, args)
+	}), command, args)
+}
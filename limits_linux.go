@@ -0,0 +1,207 @@
+package pitstop
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupCount makes the name of each cgroup pitstop creates unique.
+var cgroupCount int64
+
+// cgroup is a cgroup v2 directory created to enforce Limits on a command.
+type cgroup struct {
+	dir string
+	f   *os.File
+}
+
+// newCgroup creates a cgroup enforcing limits.
+func newCgroup(limits Limits) (*cgroup, error) {
+	parent := limits.Parent
+	if parent == "" {
+		var err error
+		parent, err = ownCgroup()
+		if err != nil {
+			return nil, fmt.Errorf("error finding pitstop's cgroup: %w", err)
+		}
+	}
+	var controllers []string
+	if limits.MemoryBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if limits.CPUs > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(parent, fmt.Sprintf("pitstop-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupCount, 1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cgroup: %w", err)
+	}
+	cg := &cgroup{dir: dir}
+	var err error
+	if limits.MemoryBytes > 0 {
+		err = cg.write("memory.max", fmt.Sprint(limits.MemoryBytes))
+	}
+	if err == nil && limits.CPUs > 0 {
+		// cpu.max is how many microseconds of CPU time can be used in each
+		// period of 100ms.
+		const period = 100000
+		err = cg.write("cpu.max", fmt.Sprintf("%d %d", int64(limits.CPUs*period), period))
+	}
+	if err == nil {
+		cg.f, err = os.Open(dir)
+		if err != nil {
+			err = fmt.Errorf("error opening cgroup: %w", err)
+		}
+	}
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return cg, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 that pitstop is in.
+func ownCgroup() (string, error) {
+	mount, err := cgroupMount()
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		// The cgroup v2 hierarchy is the one with an ID of 0 and no
+		// controllers listed.
+		if path := strings.TrimPrefix(line, "0::"); path != line {
+			return filepath.Join(mount, path), nil
+		}
+	}
+	return "", errors.New("cgroup v2 isn't in use")
+}
+
+// cgroupMount returns where the cgroup v2 filesystem is mounted.
+func cgroupMount() (string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup v2 isn't mounted")
+}
+
+// enableControllers makes sure controllers can be used by the cgroups
+// created inside parent.
+func enableControllers(parent string, controllers []string) error {
+	b, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("error reading the controllers of cgroup %s: %w", parent, err)
+	}
+	available := strings.Fields(string(b))
+	var enable []string
+	for _, c := range controllers {
+		if !containsString(available, c) {
+			return fmt.Errorf("the %s controller isn't available in cgroup %s", c, parent)
+		}
+		enable = append(enable, "+"+c)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("error reading the controllers of cgroup %s: %w", parent, err)
+	}
+	enabled := strings.Fields(string(b))
+	var missing []string
+	for i, c := range controllers {
+		if !containsString(enabled, c) {
+			missing = append(missing, enable[i])
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	err = ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(missing, " ")), 0644)
+	if errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("error enabling controllers in cgroup %s, which can't be done for a cgroup with processes in it; set Limits.Parent to a cgroup delegated to pitstop: %w", parent, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error enabling controllers in cgroup %s: %w", parent, err)
+	}
+	return nil
+}
+
+// containsString reports whether s is in list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// write sets the cgroup's interface file name to value.
+func (cg *cgroup) write(name, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(cg.dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("error setting %s of cgroup %s: %w", name, cg.dir, err)
+	}
+	return nil
+}
+
+// apply configures cmd to start inside the cgroup, so that nothing it starts
+// can escape before it is moved there.
+func (cg *cgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.f.Fd())
+}
+
+// started releases what is only needed to start the command, once it has
+// been started or failed to start.
+func (cg *cgroup) started() {
+	cg.f.Close()
+}
+
+// remove kills anything still running in the cgroup and removes it, logging
+// any errors to log.
+func (cg *cgroup) remove(log logger) {
+	// cgroup.kill needs Linux 5.14, and without it the cgroup can only be
+	// removed if nothing is still running in it.
+	cg.write("cgroup.kill", "1")
+	for i := 0; i < 10; i++ {
+		err := os.Remove(cg.dir)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			log.debugf("Error removing cgroup: %v", err)
+			return
+		}
+		// Killed processes take a moment to leave the cgroup.
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.debugf("Error removing cgroup %s: still in use", cg.dir)
+}
@@ -0,0 +1,74 @@
+package pitstop_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestLimits(t *testing.T) {
+	parent := os.Getenv("PITSTOP_TEST_CGROUP")
+	if parent == "" {
+		t.Skip("set PITSTOP_TEST_CGROUP to a cgroup v2 directory with the memory and cpu controllers to run this test")
+	}
+	limits := pitstop.Limits{MemoryBytes: 64 << 20, CPUs: 0.5, Parent: parent}
+	proc, err := pitstop.ProcessCommand(pitstop.RunOptions{Limits: limits}, "tail", "-f", "/dev/null").Start()
+	if err != nil {
+		t.Fatalf("ProcessCommand() err = %v; want nil", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(proc.PID()), "cgroup"))
+	if err != nil {
+		proc.Stop()
+		t.Fatalf("reading the app's cgroup: %v", err)
+	}
+	var dir string
+	for _, line := range strings.Split(string(b), "\n") {
+		if path := strings.TrimPrefix(line, "0::"); path != line {
+			dir = filepath.Join(parent, filepath.Base(path))
+		}
+	}
+	for name, want := range map[string]string{
+		"memory.max": "67108864",
+		"cpu.max":    "50000 100000",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || strings.TrimSpace(string(got)) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	proc.Stop()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("cgroup %s still exists after the app stopped: %v", dir, err)
+	}
+}
+
+func TestLimits_unavailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu io\n"), 0644); err != nil {
+		t.Fatalf("setup: writing cgroup.controllers: %v", err)
+	}
+	limits := pitstop.Limits{MemoryBytes: 64 << 20, Parent: dir}
+
+	want := "memory controller isn't available"
+	if _, err := pitstop.RunCommandWith(pitstop.RunOptions{Limits: limits}, "tail").Run(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("RunCommandWith() err = %v; want it to contain %q", err, want)
+	}
+	err = pitstop.BuildCommandLimits(limits, "true").Build()
+	var buildErr *pitstop.BuildError
+	if !errors.As(err, &buildErr) || !strings.Contains(err.Error(), want) {
+		t.Errorf("BuildCommandLimits() err = %v; want a *BuildError containing %q", err, want)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d entries in the parent cgroup; want no cgroup left behind", len(entries))
+	}
+}
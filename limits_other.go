@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package pitstop
+
+import "os/exec"
+
+// cgroup does nothing, since Limits are only enforced on Linux.
+type cgroup struct{}
+
+func newCgroup(limits Limits) (*cgroup, error) {
+	return &cgroup{}, nil
+}
+
+func (cg *cgroup) apply(cmd *exec.Cmd) {}
+
+func (cg *cgroup) started() {}
+
+func (cg *cgroup) remove(log logger) {}
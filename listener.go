@@ -0,0 +1,102 @@
+package pitstop
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// listenerFDEnv is the environment variable that tells an app started with
+// RunOptions.Listener which file descriptor its listener is on.
+const listenerFDEnv = "PITSTOP_LISTENER_FD"
+
+// ListenerRun returns a RunStep that listens on the TCP address addr the
+// first time it is run, and then starts the app using the RunStep returned by
+// run every time it is run, including the first. The same listener is
+// given to run every time and is kept open for as long as pitstop runs, so
+// the port stays bound while the app restarts. Connections made between one
+// app exiting and the next one starting wait in the listener's backlog
+// rather than being refused, and with Poller.KeepLastGoodOnFailure the new
+// app is accepting connections before the old one is stopped. For example:
+//
+//	p := pitstop.Poller{
+//		Dir: ".",
+//		Pre: []pitstop.Step{pitstop.BuildCommand("go", "build", "-o", "tmp/app", ".")},
+//		Run: pitstop.ListenerRun(":3000", func(ln net.Listener) pitstop.RunStep {
+//			return pitstop.RunCommandWith(pitstop.RunOptions{Listener: ln}, "./tmp/app")
+//		}),
+//	}
+//
+// The app has to cooperate by serving on the listener it is given rather than
+// listening on the port itself, which would fail because pitstop already has
+// it bound; see RunOptions.Listener for how it is passed and Listen for a
+// helper that Go apps can use. If the listener can't be created, an error is
+// returned and it is tried again the next time the RunStep is run.
+//
+// An app that can't accept a listener this way can get some of the same
+// benefit by setting the SO_REUSEPORT socket option before it binds the port,
+// which on Linux and most BSDs lets a new instance bind while the old one is
+// still running. Connections still queued for the old instance when it exits
+// are dropped, though, so it is only a partial fix.
+func ListenerRun(addr string, run func(ln net.Listener) RunStep) RunStep {
+	var mu sync.Mutex
+	var ln net.Listener
+	return runFunc(func(sc stepContext) (func(), error) {
+		mu.Lock()
+		if ln == nil {
+			var err error
+			ln, err = net.Listen("tcp", addr)
+			if err != nil {
+				mu.Unlock()
+				return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+			}
+		}
+		l := ln
+		mu.Unlock()
+		return runStep(sc, run(l))
+	})
+}
+
+// Listen is a helper for Go apps run with RunOptions.Listener. If the app was
+// given a listener by pitstop it is returned, and otherwise Listen listens on
+// the TCP address addr, so the app also works when it isn't run by pitstop.
+// For example:
+//
+//	ln, err := pitstop.Listen(":3000")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(http.Serve(ln, handler))
+func Listen(addr string) (net.Listener, error) {
+	v := os.Getenv(listenerFDEnv)
+	if v == "" {
+		return net.Listen("tcp", addr)
+	}
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s=%q: %w", listenerFDEnv, v, err)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("error using the listener from pitstop: %w", err)
+	}
+	return ln, nil
+}
+
+// listenerFile returns a duplicate of ln's file descriptor that can be passed
+// to a child process.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("error passing listener to the app: %T doesn't have a file descriptor", ln)
+	}
+	f, err := filer.File()
+	if err != nil {
+		return nil, fmt.Errorf("error passing listener to the app: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,203 @@
+package pitstop
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// LiveReloader is an http.Handler that tells connected browsers to reload the
+// page whenever Reload is called. It serves both a tiny JavaScript snippet and
+// the websocket endpoint that snippet connects to, so if it is mounted at
+// "/livereload" pages only need to include:
+//
+//	<script src="http://localhost:35729/livereload"></script>
+//
+// Browsers that lose their connection, such as when the process serving the
+// LiveReloader restarts, will keep trying to reconnect and reload the page once
+// they succeed.
+type LiveReloader struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+// LiveReload returns a new LiveReloader with no connected clients.
+func LiveReload() *LiveReloader {
+	return &LiveReloader{
+		clients: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Reload sends a reload message to every connected browser. It never blocks,
+// even if there are no clients connected or a client is slow to read.
+func (lr *LiveReloader) Reload() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for ch := range lr.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A reload is already pending for this client.
+		}
+	}
+}
+
+// OnBuildEnd calls Reload if err is nil. It is intended to be used as a
+// Poller's OnBuildEnd callback so browsers reload after every successful
+// rebuild. If the app takes a moment to start, add WaitForHTTP to Post so
+// browsers don't reload before it can serve the page.
+func (lr *LiveReloader) OnBuildEnd(err error) {
+	if err == nil {
+		lr.Reload()
+	}
+}
+
+func (lr *LiveReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		w.Header().Set("Content-Type", "application/javascript")
+		io.WriteString(w, liveReloadJS)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key header", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ch := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[ch] = struct{}{}
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, ch)
+		lr.mu.Unlock()
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		discardFrames(rw.Reader)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-ch:
+			if err := writeTextFrame(conn, "reload"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept header value for the
+// provided Sec-WebSocket-Key, as described in RFC 6455.
+func websocketAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+"258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// writeTextFrame writes msg to w as a single unmasked websocket text frame.
+func writeTextFrame(w io.Writer, msg string) error {
+	frame := []byte{0x81}
+	switch n := len(msg); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(n))
+		frame = append(append(frame, 127), size[:]...)
+	}
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// discardFrames reads and discards websocket frames from r until the client
+// sends a close frame or the connection fails. Browsers don't send us
+// anything we care about, but we still need to notice when they go away.
+func discardFrames(r *bufio.Reader) {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		if header[0]&0x0F == 0x8 {
+			return
+		}
+		n := uint64(header[1] & 0x7F)
+		switch n {
+		case 126:
+			var size [2]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(size[:]))
+		case 127:
+			var size [8]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(size[:])
+		}
+		if header[1]&0x80 != 0 {
+			// Skip the masking key.
+			n += 4
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+			return
+		}
+	}
+}
+
+const liveReloadJS = `(function() {
+  var src = document.currentScript.src.replace(/^http/, "ws");
+  var connected = false;
+  function connect() {
+    var ws = new WebSocket(src);
+    ws.onopen = function() {
+      if (connected) {
+        location.reload();
+      }
+      connected = true;
+    };
+    ws.onmessage = function(e) {
+      if (e.data === "reload") {
+        location.reload();
+      }
+    };
+    ws.onclose = function() {
+      setTimeout(connect, 1000);
+    };
+  }
+  connect();
+})();
+`
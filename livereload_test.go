@@ -0,0 +1,93 @@
+package pitstop_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestLiveReloader(t *testing.T) {
+	lr := pitstop.LiveReload()
+	server := httptest.NewServer(lr)
+	defer server.Close()
+
+	t.Run("script", func(t *testing.T) {
+		res, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("GET %s err = %v; want nil", server.URL, err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		if !strings.Contains(string(body), "WebSocket") {
+			t.Errorf("GET %s body = %q; want a websocket script", server.URL, body)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		// No clients are connected yet, so this shouldn't block.
+		lr.Reload()
+
+		conns := make([]net.Conn, 2)
+		readers := make([]*bufio.Reader, 2)
+		for i := range conns {
+			conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+			if err != nil {
+				t.Fatalf("Dial() err = %v; want nil", err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "GET / HTTP/1.1\r\n"+
+				"Host: localhost\r\n"+
+				"Upgrade: websocket\r\n"+
+				"Connection: Upgrade\r\n"+
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+				"Sec-WebSocket-Version: 13\r\n\r\n")
+			br := bufio.NewReader(conn)
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("ReadResponse() err = %v; want nil", err)
+			}
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("StatusCode = %d; want %d", res.StatusCode, http.StatusSwitchingProtocols)
+			}
+			if got, want := res.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+				t.Errorf("Sec-WebSocket-Accept = %q; want %q", got, want)
+			}
+			conns[i], readers[i] = conn, br
+		}
+
+		for i, conn := range conns {
+			// The client might not be registered the instant the handshake
+			// finishes, so keep asking for reloads until one arrives.
+			done := make(chan struct{})
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					case <-time.After(10 * time.Millisecond):
+						lr.Reload()
+					}
+				}
+			}()
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			frame := make([]byte, 8)
+			_, err := io.ReadFull(readers[i], frame)
+			close(done)
+			if err != nil {
+				t.Fatalf("client %d: reading frame err = %v; want nil", i, err)
+			}
+			if got, want := string(frame), "\x81\x06reload"; got != want {
+				t.Errorf("client %d: frame = %q; want %q", i, got, want)
+			}
+		}
+	})
+}
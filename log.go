@@ -0,0 +1,189 @@
+package pitstop
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Verbosity controls how much a Poller prints while it is running.
+type Verbosity int
+
+const (
+	// Silent only prints errors.
+	Silent Verbosity = -1
+	// Normal prints when the app is built, run, and stopped, along with any
+	// errors. This is the default.
+	Normal Verbosity = 0
+	// Verbose prints everything Normal does, as well as every scan, each of
+	// the files that changed, and how long every build step took.
+	Verbose Verbosity = 1
+)
+
+func (v Verbosity) String() string {
+	switch {
+	case v <= Silent:
+		return "silent"
+	case v >= Verbose:
+		return "verbose"
+	}
+	return "normal"
+}
+
+// logger prints messages that are at or below its verbosity.
+type logger struct {
+	verbosity   Verbosity
+	noLifecycle bool
+	// out is where messages are printed, defaulting to os.Stdout.
+	out io.Writer
+	// onError is called with every error, including ones repeats
+	// suppresses, if it is set.
+	onError func(msg string)
+	// repeats suppresses repeated errors if it is set. It is a pointer so
+	// that every copy of the logger shares it.
+	repeats *errorRepeats
+}
+
+// infof prints informational messages, which are hidden by Silent.
+func (l logger) infof(format string, args ...interface{}) {
+	if l.verbosity >= Normal {
+		fmt.Fprintf(l.output(), format+"\n", args...)
+	}
+}
+
+// lifecyclef prints the routine messages about the app being built, run, and
+// stopped. They are hidden by Silent or noLifecycle.
+func (l logger) lifecyclef(format string, args ...interface{}) {
+	if !l.noLifecycle {
+		l.infof(format, args...)
+	}
+}
+
+// debugf prints messages that are only shown with Verbose.
+func (l logger) debugf(format string, args ...interface{}) {
+	if l.verbosity >= Verbose {
+		fmt.Fprintf(l.output(), format+"\n", args...)
+	}
+}
+
+// errorf prints errors, which are always shown unless they repeat the last
+// error and repeats suppresses them.
+func (l logger) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if l.onError != nil {
+		l.onError(msg)
+	}
+	if l.repeats == nil {
+		fmt.Fprintln(l.output(), msg)
+		return
+	}
+	for _, line := range l.repeats.filter(msg) {
+		fmt.Fprintln(l.output(), line)
+	}
+}
+
+// flushRepeats prints how many times the last error was suppressed, if it
+// has been since it was last printed.
+func (l logger) flushRepeats() {
+	if l.repeats == nil {
+		return
+	}
+	if line, ok := l.repeats.flush(); ok {
+		fmt.Fprintln(l.output(), line)
+	}
+}
+
+// output returns where the logger prints. os.Stdout is looked up on every
+// call rather than saved so that it can be swapped out, such as in tests.
+func (l logger) output() io.Writer {
+	if l.out == nil {
+		return os.Stdout
+	}
+	return l.out
+}
+
+// errorRepeats keeps track of the last error printed so that identical ones
+// can be counted rather than printed again. A summary of how many were
+// suppressed is printed once window has passed since the error was last
+// printed, or once limit of them have been suppressed if limit is positive.
+type errorRepeats struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	window time.Duration
+	limit  int
+
+	last       string
+	printed    time.Time
+	suppressed int
+}
+
+// filter returns the lines that should be printed for the error msg.
+func (r *errorRepeats) filter(msg string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if msg == r.last {
+		r.suppressed++
+		if now.Sub(r.printed) < r.window && (r.limit <= 0 || r.suppressed < r.limit) {
+			return nil
+		}
+		r.printed = now
+		return []string{r.summary()}
+	}
+	// A different error is always printed, after finishing off the last one.
+	var lines []string
+	if r.suppressed > 0 {
+		lines = append(lines, r.summary())
+	}
+	r.last, r.printed = msg, now
+	return append(lines, msg)
+}
+
+// flush returns the summary of any errors suppressed since the last one was
+// printed.
+func (r *errorRepeats) flush() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.suppressed == 0 {
+		return "", false
+	}
+	return r.summary(), true
+}
+
+// summary describes how many errors were suppressed and resets the count.
+func (r *errorRepeats) summary() string {
+	times := "times"
+	if r.suppressed == 1 {
+		times = "time"
+	}
+	line := fmt.Sprintf("(same error, suppressed %d %s)", r.suppressed, times)
+	r.suppressed = 0
+	return line
+}
+
+// timedSteps wraps each of steps so that it logs how long it took.
+func (l logger) timedSteps(phase string, steps []Step) []Step {
+	ret := make([]Step, len(steps))
+	for i, step := range steps {
+		desc, step := fmt.Sprintf("%s step %d of %d", phase, i+1, len(steps)), step
+		ret[i] = stepFunc(func(sc stepContext) error {
+			start := time.Now()
+			err := buildStep(sc, step)
+			l.debugf("Finished %s in %v", desc, time.Since(start))
+			return err
+		})
+	}
+	return ret
+}
+
+// timedRun wraps run so that it logs how long it took to start the app.
+func (l logger) timedRun(run RunStep) RunStep {
+	return runFunc(func(sc stepContext) (func(), error) {
+		start := time.Now()
+		stop, err := runStep(sc, run)
+		l.debugf("Finished run step in %v", time.Since(start))
+		return stop, err
+	})
+}
@@ -0,0 +1,139 @@
+package pitstop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Manager supervises several Pollers as one, such as in a monorepo where a
+// frontend in web/ and a backend in server/ each have their own pipeline:
+//
+//	m := pitstop.Manager{
+//		Pollers: []*pitstop.Poller{
+//			{Dir: "web", Pre: []pitstop.Step{pitstop.BuildCommand("npm", "run", "build")}, Run: web},
+//			{Dir: "server", Pre: []pitstop.Step{pitstop.BuildCommand("go", "build", "-o", "tmp/app", ".")}, Run: server},
+//		},
+//	}
+//	m.PollContext(ctx)
+//
+// Each poller scans, builds, and runs on its own, exactly as it would if it
+// were started alone, while the Manager starts and stops them together. The
+// Pollers shouldn't be started or stopped directly while the Manager is
+// running them.
+type Manager struct {
+	Pollers []*Poller
+
+	// mu guards started, which holds the pollers that Start started.
+	mu      sync.Mutex
+	started []*Poller
+}
+
+// ManagerEvent is an Event published by one of a Manager's Pollers.
+type ManagerEvent struct {
+	// Poller is the index in Pollers of the poller that published Event.
+	Poller int
+	Event
+}
+
+// Poll works like PollContext, but never returns unless one of the pollers
+// fails to start.
+func (m *Manager) Poll() error {
+	return m.PollContext(context.Background())
+}
+
+// PollContext starts every poller, then waits until ctx is done before
+// stopping all of them. If one of them fails to start the rest are stopped
+// and its error is returned, as with Start.
+func (m *Manager) PollContext(ctx context.Context) error {
+	if err := m.Start(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	m.Stop()
+	return nil
+}
+
+// Start starts every poller in order, each in the background as if its
+// Start was called. If any of them fail to start, the ones that already
+// started are stopped before an error saying which one failed is returned,
+// so none of their apps are left running. An error is also returned if the
+// Manager has already been started and hasn't been stopped.
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started != nil {
+		return errors.New("pitstop: manager already started")
+	}
+	var started []*Poller
+	for i, p := range m.Pollers {
+		if err := p.Start(); err != nil {
+			stopAll(started)
+			return fmt.Errorf("error starting poller %d: %w", i, err)
+		}
+		started = append(started, p)
+	}
+	// started is never nil once Start succeeds, even with no pollers, so it
+	// can't be started twice.
+	m.started = append([]*Poller{}, started...)
+	return nil
+}
+
+// Stop stops every poller at the same time, and waits until all of them have
+// finished and stopped their apps. Calling Stop on a Manager that isn't
+// running does nothing.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+	stopAll(started)
+}
+
+// stopAll stops every poller in pollers at the same time.
+func stopAll(pollers []*Poller) {
+	var wg sync.WaitGroup
+	for _, p := range pollers {
+		wg.Add(1)
+		go func(p *Poller) {
+			defer wg.Done()
+			p.Stop()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// Events works like Poller.Events, but the channel receives the events of
+// every poller, along with which poller published each one. Since a failed
+// build is published as a BuildFinished event with Err set, this is also a
+// single place to watch for errors from all of them. The channel is closed
+// once every poller has stopped, or when cancel is called.
+func (m *Manager) Events() (<-chan ManagerEvent, func()) {
+	out := make(chan ManagerEvent, eventBuffer)
+	var wg sync.WaitGroup
+	var cancels []func()
+	for i, p := range m.Pollers {
+		events, cancel := p.Events()
+		cancels = append(cancels, cancel)
+		wg.Add(1)
+		go func(i int, events <-chan Event) {
+			defer wg.Done()
+			for e := range events {
+				select {
+				case out <- ManagerEvent{Poller: i, Event: e}:
+				default:
+				}
+			}
+		}(i, events)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
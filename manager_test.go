@@ -0,0 +1,189 @@
+package pitstop_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestManager(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"web/app.js":     "",
+		"server/main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	stops := make(map[string]int)
+	run := func(name string) pitstop.RunFunc {
+		return func() (func(), error) {
+			return func() {
+				mu.Lock()
+				defer mu.Unlock()
+				stops[name]++
+			}, nil
+		}
+	}
+	m := pitstop.Manager{
+		Pollers: []*pitstop.Poller{
+			{Dir: filepath.Join(dir, "web"), ScanInterval: 10 * time.Millisecond, Run: run("web")},
+			{Dir: filepath.Join(dir, "server"), ScanInterval: 10 * time.Millisecond, Run: run("server")},
+		},
+	}
+	events, cancel := m.Events()
+	defer cancel()
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	if err := m.Start(); err == nil {
+		t.Errorf("second Start() err = nil; want an error")
+	}
+
+	built := make(map[int]bool)
+	timeout := time.After(5 * time.Second)
+	for len(built) < 2 {
+		select {
+		case e := <-events:
+			if e.Type == pitstop.BuildFinished {
+				built[e.Poller] = true
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for both pollers to build; built %v", built)
+		}
+	}
+
+	// A change to one project only rebuilds its own poller.
+	touch(t, filepath.Join(dir, "server"), "main.go")
+	for e := range events {
+		if e.Type == pitstop.ChangeDetected {
+			if e.Poller != 1 {
+				t.Errorf("ChangeDetected from poller %d; want 1", e.Poller)
+			}
+			break
+		}
+	}
+	// Stopping partway through the rebuild would cut it short.
+	for e := range events {
+		if e.Type == pitstop.BuildFinished && e.Poller == 1 {
+			if e.Err != nil {
+				t.Errorf("poller 1 rebuild err = %v; want nil", e.Err)
+			}
+			break
+		}
+	}
+
+	m.Stop()
+	for e := range events {
+		if e.Type == pitstop.BuildFinished && e.Err != nil {
+			t.Errorf("poller %d build err = %v; want nil", e.Poller, e.Err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if stops["web"] == 0 || stops["server"] == 0 {
+		t.Errorf("apps stopped %v; want both stopped", stops)
+	}
+	for i, p := range m.Pollers {
+		if p.Running() {
+			t.Errorf("poller %d is still running after Stop", i)
+		}
+	}
+}
+
+func TestManager_startFailure(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	ok := &pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			return func() {}, nil
+		}),
+	}
+	m := pitstop.Manager{
+		Pollers: []*pitstop.Poller{
+			ok,
+			{Dir: filepath.Join(dir, "missing"), Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil })},
+		},
+	}
+	err := m.Start()
+	if err == nil {
+		m.Stop()
+		t.Fatalf("Start() err = nil; want an error for the missing dir")
+	}
+	if !strings.Contains(err.Error(), "poller 1") {
+		t.Errorf("Start() err = %q; want it to say which poller failed", err)
+	}
+	if ok.Running() {
+		t.Errorf("first poller is still running after the second failed to start")
+	}
+	// The first poller was stopped rather than left to run, so it can be
+	// started again.
+	if err := ok.Start(); err != nil {
+		t.Errorf("restarting the first poller err = %v; want nil", err)
+	}
+	ok.Stop()
+}
+
+func TestManager_logOutput(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"web/app.js":     "",
+		"server/main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	// Each poller's steps log to its own LogOutput, even though both are
+	// running at once.
+	var webLog, serverLog syncBuffer
+	noop := pitstop.RunFunc(func() (func(), error) { return func() {}, nil })
+	build := func(name string) pitstop.Step {
+		return pitstop.Timed(name, pitstop.BuildFunc(func() error { return nil }))
+	}
+	m := pitstop.Manager{
+		Pollers: []*pitstop.Poller{
+			{Dir: filepath.Join(dir, "web"), ScanInterval: 10 * time.Millisecond, LogOutput: &webLog, Pre: []pitstop.Step{build("web build")}, Run: noop},
+			{Dir: filepath.Join(dir, "server"), ScanInterval: 10 * time.Millisecond, LogOutput: &serverLog, Pre: []pitstop.Step{build("server build")}, Run: noop},
+		},
+	}
+	events, cancel := m.Events()
+	defer cancel()
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	built := make(map[int]bool)
+	timeout := time.After(5 * time.Second)
+	for len(built) < 2 {
+		select {
+		case e := <-events:
+			if e.Type == pitstop.BuildFinished {
+				built[e.Poller] = true
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for both pollers to build; built %v", built)
+		}
+	}
+	m.Stop()
+
+	for _, tc := range []struct {
+		name      string
+		log       *syncBuffer
+		want, not string
+	}{
+		{"web", &webLog, `Step "web build" took `, "server build"},
+		{"server", &serverLog, `Step "server build" took `, "web build"},
+	} {
+		got := tc.log.String()
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("%s LogOutput = %q; want it to contain %q", tc.name, got, tc.want)
+		}
+		if strings.Contains(got, tc.not) {
+			t.Errorf("%s LogOutput = %q; want nothing from the other poller", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package pitstop
+
+import "time"
+
+// BuildMiddleware wraps a Step with extra behavior, such as logging, metrics,
+// or retries, and returns the wrapped Step. A middleware for reporting failed
+// steps might look like:
+//
+//	func report(next pitstop.Step) pitstop.Step {
+//		return pitstop.BuildFunc(func() error {
+//			err := next.Build()
+//			if err != nil {
+//				notify(err)
+//			}
+//			return err
+//		})
+//	}
+//
+// Steps called directly like this don't know which Poller they are running
+// for, so any messages printed by the steps from this package, such as those
+// from Timed, go to os.Stdout rather than the poller's LogOutput.
+type BuildMiddleware func(Step) Step
+
+// RunMiddleware wraps a RunStep with extra behavior and returns the wrapped
+// RunStep, the same way BuildMiddleware does for a Step.
+type RunMiddleware func(RunStep) RunStep
+
+// RetryMiddleware returns a BuildMiddleware that wraps each step with Retry.
+func RetryMiddleware(n int, delay time.Duration) BuildMiddleware {
+	return func(step Step) Step {
+		return Retry(n, delay, step)
+	}
+}
+
+// TimedMiddleware returns a BuildMiddleware that wraps each step with Timed.
+// Steps are named by their description, such as "go build -o app ." for one
+// created by BuildCommand, and steps without one are named "build step".
+func TimedMiddleware() BuildMiddleware {
+	return func(step Step) Step {
+		name := step.Describe()
+		if name == "" {
+			name = "build step"
+		}
+		return Timed(name, step)
+	}
+}
+
+// TimedRunMiddleware returns a RunMiddleware that wraps the app with TimedRun,
+// naming it the same way TimedMiddleware does.
+func TimedRunMiddleware() RunMiddleware {
+	return func(run RunStep) RunStep {
+		name := run.Describe()
+		if name == "" {
+			name = "app"
+		}
+		return TimedRun(name, run)
+	}
+}
+
+// DryRunMiddleware returns a BuildMiddleware that replaces each step with one
+// that only logs its description, the way DryRun does. Unlike DryRun, it can
+// be used for some steps and not others, such as with
+// Poller.UseBuildMiddleware before the steps that should really run are added.
+func DryRunMiddleware() BuildMiddleware {
+	return func(step Step) Step {
+		desc := step.Describe()
+		if desc == "" {
+			desc = "skipping build step"
+		}
+		return stepFunc(func(sc stepContext) error {
+			sc.log.infof("Dry run: %s", desc)
+			return nil
+		})
+	}
+}
+
+// DryRunRunMiddleware works like DryRunMiddleware, but for a RunStep. The
+// stop func it returns does nothing.
+func DryRunRunMiddleware() RunMiddleware {
+	return func(run RunStep) RunStep {
+		desc := run.Describe()
+		if desc == "" {
+			desc = "skipping run step"
+		}
+		return runFunc(func(sc stepContext) (func(), error) {
+			sc.log.infof("Dry run: %s", desc)
+			return func() {}, nil
+		})
+	}
+}
+
+// UseBuildMiddleware wraps every Step the poller currently has with mws,
+// including the steps in Pre, Post, Rules, and Handlers. The first of mws is
+// the outermost, so it is the first to run, and each call wraps the steps
+// again outside of the middleware from earlier calls. Steps added afterwards,
+// such as with SetPre, aren't wrapped. A wrapped step that doesn't describe
+// itself keeps the description and commands of the step it wraps, so
+// checking for commands on PATH and DryRun work the same as before, and
+// DryRun skips the middleware along with the step.
+// It is safe to call while polling, and takes effect with the next build.
+func (p *Poller) UseBuildMiddleware(mws ...BuildMiddleware) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Pre = wrapSteps(p.Pre, mws)
+	p.Post = wrapSteps(p.Post, mws)
+	if p.Rules != nil {
+		rules := make([]Rule, len(p.Rules))
+		for i, rule := range p.Rules {
+			rule.Pre = wrapSteps(rule.Pre, mws)
+			rules[i] = rule
+		}
+		p.Rules = rules
+	}
+	if p.Handlers != nil {
+		handlers := make([]Handler, len(p.Handlers))
+		for i, h := range p.Handlers {
+			if h.Build != nil {
+				h.Build = wrapBuild(h.Build, mws)
+			}
+			handlers[i] = h
+		}
+		p.Handlers = handlers
+	}
+}
+
+// UseRunMiddleware wraps Run with mws, in the same order as
+// UseBuildMiddleware. RunProcess isn't a RunStep, so it isn't wrapped. A Run
+// created by AutoPort still has its port logged and published.
+func (p *Poller) UseRunMiddleware(mws ...RunMiddleware) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	if p.Run != nil {
+		p.Run = wrapRun(p.Run, mws)
+	}
+}
+
+// wrapSteps returns a copy of steps with each step wrapped by mws, leaving
+// the caller's slice untouched.
+func wrapSteps(steps []Step, mws []BuildMiddleware) []Step {
+	if steps == nil {
+		return nil
+	}
+	ret := make([]Step, len(steps))
+	for i, step := range steps {
+		ret[i] = wrapBuild(step, mws)
+	}
+	return ret
+}
+
+// wrapBuild wraps step with mws, with the first of them outermost. The
+// wrapped step keeps the description and commands of step if it doesn't
+// have its own.
+func wrapBuild(step Step, mws []BuildMiddleware) Step {
+	wrapped := step
+	for i := len(mws) - 1; i >= 0; i-- {
+		wrapped = mws[i](wrapped)
+	}
+	return inherit(wrapped, step)
+}
+
+// wrapRun wraps run with mws, with the first of them outermost. Like
+// wrapBuild, the wrapped RunStep keeps what it doesn't have of run's
+// description, commands, and AutoPort.
+func wrapRun(run RunStep, mws []RunMiddleware) RunStep {
+	wrapped := run
+	for i := len(mws) - 1; i >= 0; i-- {
+		wrapped = mws[i](wrapped)
+	}
+	return inheritRun(wrapped, run)
+}
@@ -0,0 +1,209 @@
+package pitstop_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestPoller_UseBuildMiddleware(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": "package main\n"})
+	defer os.RemoveAll(dir)
+
+	var calls []string
+	step := func(name string) pitstop.BuildFunc {
+		return func() error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	mw := func(name string) pitstop.BuildMiddleware {
+		return func(next pitstop.Step) pitstop.Step {
+			return pitstop.BuildFunc(func() error {
+				calls = append(calls, name)
+				return next.Build()
+			})
+		}
+	}
+	runMW := func(name string) pitstop.RunMiddleware {
+		return func(next pitstop.RunStep) pitstop.RunStep {
+			return pitstop.RunFunc(func() (func(), error) {
+				calls = append(calls, name)
+				return next.Run()
+			})
+		}
+	}
+	pre := []pitstop.Step{step("pre")}
+	p := pitstop.Poller{
+		Dir:       dir,
+		Verbosity: pitstop.Silent,
+		Pre:       pre,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			calls = append(calls, "run")
+			return func() {}, nil
+		}),
+		Post:     []pitstop.Step{step("post")},
+		Rules:    []pitstop.Rule{{Match: []string{"*.css"}, Pre: []pitstop.Step{step("rule")}}},
+		Handlers: []pitstop.Handler{{Match: []string{"*.md"}, Build: step("handler")}, {Match: []string{"*.txt"}}},
+	}
+	p.UseBuildMiddleware(mw("inner"))
+	p.UseBuildMiddleware(mw("outer a"), mw("outer b"))
+	p.UseRunMiddleware(runMW("run mw"))
+
+	stop, err := p.Once(context.Background())
+	if err != nil {
+		t.Fatalf("Once() err = %v; want nil", err)
+	}
+	stop()
+	want := []string{
+		"outer a", "outer b", "inner", "pre",
+		"run mw", "run",
+		"outer a", "outer b", "inner", "post",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v; want %v", calls, want)
+	}
+
+	calls = nil
+	if err := p.Rules[0].Pre[0].Build(); err != nil {
+		t.Fatalf("rule step err = %v; want nil", err)
+	}
+	if err := p.Handlers[0].Build.Build(); err != nil {
+		t.Fatalf("handler step err = %v; want nil", err)
+	}
+	want = []string{"outer a", "outer b", "inner", "rule", "outer a", "outer b", "inner", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v; want %v", calls, want)
+	}
+	if p.Handlers[1].Build != nil {
+		t.Errorf("Handlers[1].Build was set by UseBuildMiddleware; want it left nil")
+	}
+
+	calls = nil
+	if err := pre[0].Build(); err != nil {
+		t.Fatalf("original pre step err = %v; want nil", err)
+	}
+	if want := []string{"pre"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calling the original Pre slice called %v; want %v unwrapped", calls, want)
+	}
+}
+
+func TestPoller_UseBuildMiddleware_commands(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": "package main\n"})
+	defer os.RemoveAll(dir)
+	noop := func(next pitstop.Step) pitstop.Step {
+		return pitstop.BuildFunc(func() error { return next.Build() })
+	}
+
+	// Wrapped steps are still checked for missing commands.
+	p := pitstop.Poller{
+		Dir:       dir,
+		Verbosity: pitstop.Silent,
+		Pre:       []pitstop.Step{pitstop.BuildCommand("pitstop-missing-command")},
+		Run:       pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	p.UseBuildMiddleware(noop)
+	_, err := p.Once(context.Background())
+	var notFound *pitstop.CommandNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Once() err = %v; want a *CommandNotFoundError", err)
+	}
+
+	// And DryRun still prints their commands.
+	p = pitstop.Poller{
+		Dir:    dir,
+		DryRun: true,
+		Pre:    []pitstop.Step{pitstop.BuildCommand("go", "build", ".")},
+		Run:    pitstop.RunCommand("./app"),
+	}
+	p.UseBuildMiddleware(noop)
+	p.UseRunMiddleware(pitstop.TimedRunMiddleware())
+	output := captureStdout(t)
+	stop, err := p.Once(context.Background())
+	got := output()
+	if err != nil {
+		t.Fatalf("Once() err = %v; want nil", err)
+	}
+	stop()
+	for _, want := range []string{"Dry run: go build .", "Dry run: ./app"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q; want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var runs int
+	app := pitstop.RunFunc(func() (func(), error) {
+		runs++
+		return func() {}, nil
+	})
+	var attempts int
+	flaky := pitstop.BuildFunc(func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+
+	output := captureStdout(t)
+	if err := pitstop.RetryMiddleware(2, 0)(flaky).Build(); err != nil {
+		t.Errorf("RetryMiddleware(2, 0)() err = %v; want nil", err)
+	}
+	if err := pitstop.TimedMiddleware()(pitstop.BuildCommand("go", "version")).Build(); err != nil {
+		t.Errorf("TimedMiddleware()() err = %v; want nil", err)
+	}
+	if err := pitstop.DryRunMiddleware()(pitstop.BuildCommand("pitstop-missing-command", "-v")).Build(); err != nil {
+		t.Errorf("DryRunMiddleware()() err = %v; want nil", err)
+	}
+	stop, err := pitstop.DryRunRunMiddleware()(app).Run()
+	if err != nil {
+		t.Fatalf("DryRunRunMiddleware()() err = %v; want nil", err)
+	}
+	stop()
+	got := output()
+	if attempts != 3 {
+		t.Errorf("flaky step was tried %d times; want 3", attempts)
+	}
+	if runs != 0 {
+		t.Errorf("app was started %d times by DryRunRunMiddleware; want 0", runs)
+	}
+	for _, want := range []string{
+		`Step "go version" took `,
+		"Dry run: pitstop-missing-command -v",
+		"Dry run: skipping run step",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q; want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestPoller_UseBuildMiddleware_whileStarting(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": "package main\n"})
+	defer os.RemoveAll(dir)
+	noop := pitstop.BuildFunc(func() error { return nil })
+	p := pitstop.Poller{
+		Dir:       dir,
+		Verbosity: pitstop.Silent,
+		Pre:       []pitstop.Step{noop},
+		Run:       pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.UseBuildMiddleware(pitstop.RetryMiddleware(1, 0))
+		p.UseRunMiddleware(pitstop.TimedRunMiddleware())
+	}()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	<-done
+	p.Stop()
+}
@@ -0,0 +1,97 @@
+package pitstop
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// timestampFormat is the layout of the time written before each line by
+// TimestampWriter and RunOptions.Timestamps.
+const timestampFormat = "15:04:05.000"
+
+// TimestampWriter returns an io.Writer that writes each line written to it to
+// w, starting with the time it was written, such as "15:04:05.000 listening
+// on :3000". It is useful for debugging timing issues between the build and
+// the app:
+//
+//	stdout := pitstop.TimestampWriter(os.Stdout)
+//	stderr := pitstop.TimestampWriter(os.Stderr)
+//	p.Pre = []pitstop.Step{pitstop.BuildCommandOut(stdout, stderr, "go", "build", "-o", "./tmp/app", ".")}
+//	p.Run = pitstop.RunCommandOut(stdout, stderr, "./tmp/app")
+//
+// Partial lines are held until they are completed by a newline. Each command
+// run by BuildCommandOut, RunCommandOut, and the like writes out any partial
+// line it left behind when it exits, so the writer can be shared between
+// them. See RunOptions.Timestamps for an option that does the same thing for
+// an app.
+func TimestampWriter(w io.Writer) io.Writer {
+	return &prefixWriter{w: w, timestamps: true}
+}
+
+// flusher is implemented by writers that hold onto partial lines, such as the
+// one returned by TimestampWriter.
+type flusher interface {
+	Flush() error
+}
+
+// flushOutput flushes each of ws that holds onto partial lines.
+func flushOutput(ws ...io.Writer) {
+	for _, w := range ws {
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// prefixWriter is an io.Writer that writes prefix before every line written
+// to it, after the time the line was written if timestamps is set. Partial
+// lines are buffered until they are completed by a newline or Flush is
+// called.
+type prefixWriter struct {
+	mu         sync.Mutex
+	w          io.Writer
+	prefix     string
+	timestamps bool
+	buf        []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := pw.linePrefix() + string(pw.buf[:i+1])
+		pw.buf = pw.buf[i+1:]
+		if _, err := io.WriteString(pw.w, line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes any buffered partial line, followed by a newline so that it
+// isn't joined with the next line written to the underlying writer.
+func (pw *prefixWriter) Flush() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	line := pw.linePrefix() + string(pw.buf) + "\n"
+	pw.buf = nil
+	_, err := io.WriteString(pw.w, line)
+	return err
+}
+
+// linePrefix returns what is written before the next line.
+func (pw *prefixWriter) linePrefix() string {
+	if !pw.timestamps {
+		return pw.prefix
+	}
+	return time.Now().Format(timestampFormat) + " " + pw.prefix
+}
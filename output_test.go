@@ -0,0 +1,39 @@
+package pitstop_test
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+// timestamped matches a line starting with a timestamp.
+var timestamped = regexp.MustCompile(`(?m)^\d\d:\d\d:\d\d\.\d{3} `)
+
+func TestTimestampWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := pitstop.TimestampWriter(&buf)
+	err := pitstop.BuildCommandOut(w, w, "sh", "-c", `printf 'one\ntw'; printf 'o\npartial'`).Build()
+	if err != nil {
+		t.Fatalf("BuildCommandOut() err = %v; want nil", err)
+	}
+	lines := bytes.SplitAfter(buf.Bytes(), []byte("\n"))
+	if last := lines[len(lines)-1]; len(last) != 0 {
+		t.Errorf("output ends with %q; want a newline", last)
+	}
+	lines = lines[:len(lines)-1]
+	want := []string{"one\n", "two\n", "partial\n"}
+	if len(lines) != len(want) {
+		t.Fatalf("output = %q; want %d lines", buf.String(), len(want))
+	}
+	for i, line := range lines {
+		if !timestamped.Match(line) {
+			t.Errorf("line %d = %q; want it to start with a timestamp", i, line)
+			continue
+		}
+		if got := string(timestamped.ReplaceAll(line, nil)); got != want[i] {
+			t.Errorf("line %d = %q; want %q after the timestamp", i, got, want[i])
+		}
+	}
+}
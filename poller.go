@@ -1,12 +1,19 @@
 package pitstop
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,149 +21,2055 @@ import (
 // changed after the provided `since` time.Time. If one is found, true is
 // returned. Otherwise false is returned.
 func DidChange(dir string, since time.Time) bool {
-	var changed bool
+	return DidChangeMaxDepth(dir, since, -1)
+}
 
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		if info.ModTime().After(since) {
-			changed = true
-		}
-		return nil
-	})
+// DidChangeMaxDepth works like DidChange, but it won't recurse more than
+// maxDepth levels of subdirectories deep. A maxDepth of 0 will only scan the
+// files directly inside dir, 1 will also scan its immediate subdirectories, and
+// so on. A negative maxDepth means there is no limit.
+func DidChangeMaxDepth(dir string, since time.Time, maxDepth int) bool {
+	if dir == "" {
+		// os.DirFS("") would be rooted at "/" rather than the current directory.
+		dir = "."
+	}
+	return didChangeFS(os.DirFS(dir), since, maxDepth)
+}
 
-	return changed
+// DidChangeFollow works like DidChange, but symlinks inside dir are followed
+// so changes to the files and directories they point to are noticed.
+func DidChangeFollow(dir string, since time.Time) bool {
+	w := Watcher{Dirs: []string{dir}, FollowSymlinks: true}
+	return w.DidChange(since)
+}
+
+// Step is a build step, such as one of a Poller's Pre or Post steps. Build
+// performs the step, and Describe returns a short description of what it does,
+// such as the command line it runs, for DryRun and TimedMiddleware to print.
+// Describe returns "" if the step can't describe itself.
+type Step interface {
+	Build() error
+	Describe() string
 }
 
 // BuildFunc is a function that performs a build step. This might be something
-// like copying files, running an exec.Cmd, or something else entirely.
+// like copying files, running an exec.Cmd, or something else entirely. It is a
+// Step without a description; see Describe for giving it one.
 type BuildFunc func() error
 
+// Build calls fn.
+func (fn BuildFunc) Build() error {
+	return fn()
+}
+
+// Describe returns "", since a BuildFunc can't describe itself.
+func (fn BuildFunc) Describe() string {
+	return ""
+}
+
 // BuildCommand works similar to exec.Command, but rather than returning an
-// exec.Cmd it returns a BuildFunc that can be reused.
-func BuildCommand(command string, args ...string) BuildFunc {
-	return func() error {
-		cmd := exec.Command(command, args...)
-		var sb strings.Builder
-		cmd.Stdout = io.MultiWriter(os.Stdout, &sb)
-		cmd.Stderr = io.MultiWriter(os.Stderr, &sb)
-		err := cmd.Run()
-		if err != nil {
-			return fmt.Errorf("error building: \"%s %s\": %w\n%v", command, strings.Join(args, " "), err, sb.String())
+// exec.Cmd it returns a Step that can be reused. The Step is described by its
+// command line, such as "go build -o app .".
+func BuildCommand(command string, args ...string) Step {
+	return BuildCommandOut(nil, nil, command, args...)
+}
+
+// BuildCommandOut works like BuildCommand, but the command's output is written
+// to the provided stdout and stderr writers rather than os.Stdout and
+// os.Stderr. A nil writer defaults to the corresponding os.Stdout or os.Stderr.
+func BuildCommandOut(stdout, stderr io.Writer, command string, args ...string) Step {
+	return describeBuild(BuildFunc(func() error {
+		return buildCommand(exec.Command(command, args...), stdout, stderr, command, args)
+	}), command, args)
+}
+
+// BuildCommandCapture works like BuildCommand, but rather than printing the
+// command's output it is captured in the returned buffer. The buffer is reset
+// each time the Step is run, so once it returns the buffer holds exactly what
+// the command printed during its last run. This is useful for showing the
+// output of the last build in a dedicated pane. The buffer must not be read
+// while the Step is running.
+func BuildCommandCapture(command string, args ...string) (Step, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return describeBuild(BuildFunc(func() error {
+		buf.Reset()
+		output := &lockedWriter{w: &buf}
+		return buildCommand(exec.Command(command, args...), output, output, command, args)
+	}), command, args), &buf
+}
+
+// BuildCommandTimeout works like BuildCommand, but if the command is still
+// running after timeout it will be killed, along with any processes it
+// started, and an error will be returned. The command is started in its own
+// process group, so it won't receive Ctrl-C from the terminal directly.
+func BuildCommandTimeout(timeout time.Duration, command string, args ...string) Step {
+	return describeBuild(BuildFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err := buildCommandContext(ctx, command, args)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			buildErr := err.(*BuildError)
+			buildErr.Err = fmt.Errorf("timed out after %v: %w", timeout, ctx.Err())
 		}
-		return nil
+		return err
+	}), command, args)
+}
+
+// BuildCommandContext works like BuildCommand, but if ctx is done before the
+// command finishes it will be killed, along with any processes it started.
+// The *BuildError returned for a command killed this way wraps ctx.Err(), so
+// errors.Is can tell an intentional cancellation apart from a failed build.
+// If ctx is already done the command isn't run. Like BuildCommandTimeout, the
+// command is started in its own process group. Using the same ctx as
+// PollContext kills an in-flight build when the poller shuts down:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer stop()
+//	p.Pre = []pitstop.Step{
+//		pitstop.BuildCommandContext(ctx, "go", "build", "-o", "./tmp/app", "."),
+//	}
+//	p.PollContext(ctx)
+func BuildCommandContext(ctx context.Context, command string, args ...string) Step {
+	return describeBuild(BuildFunc(func() error {
+		err := buildCommandContext(ctx, command, args)
+		if err != nil && ctx.Err() != nil {
+			buildErr := err.(*BuildError)
+			buildErr.Err = fmt.Errorf("canceled: %w", ctx.Err())
+		}
+		return err
+	}), command, args)
+}
+
+// buildCommandContext runs command with args using buildCommand, and kills it
+// if ctx is done before it finishes.
+func buildCommandContext(ctx context.Context, command string, args []string) error {
+	cmd := exec.CommandContext(ctx, command, args...)
+	// Kill everything the command started, not just the command itself, so a
+	// child that is still holding stdout or stderr open can't keep the build
+	// running once ctx is done.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcess(cmd, true)
 	}
+	cmd.WaitDelay = waitDelay
+	return buildCommand(cmd, nil, nil, command, args)
+}
+
+// buildCommand runs cmd, which should have been created from command and args,
+// and returns a *BuildError if it fails.
+func buildCommand(cmd *exec.Cmd, stdout, stderr io.Writer, command string, args []string) error {
+	stdout, stderr = defaultOutput(stdout, stderr)
+	// stdout and stderr are copied by separate goroutines, so writes to the
+	// shared builder need to be synchronized.
+	var sb strings.Builder
+	output := &lockedWriter{w: &sb}
+	cmd.Stdout = io.MultiWriter(stdout, output)
+	if stderr == stdout {
+		// Sharing a writer lets exec use a single pipe for both, which keeps
+		// the output in the order it was written.
+		cmd.Stderr = cmd.Stdout
+	} else {
+		cmd.Stderr = io.MultiWriter(stderr, output)
+	}
+	err := cmd.Run()
+	flushOutput(stdout, stderr)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			err = &CommandNotFoundError{Command: command, Err: err}
+		}
+		exitCode := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		return &BuildError{
+			Command:  command,
+			Args:     args,
+			ExitCode: exitCode,
+			Err:      err,
+			Output:   sb.String(),
+		}
+	}
+	return nil
+}
+
+// BuildError is the error returned by a Step created with BuildCommand
+// when its command fails.
+type BuildError struct {
+	Command string
+	Args    []string
+	// ExitCode is the exit code of the command, or -1 if the command never
+	// exited normally, such as when it couldn't be started.
+	ExitCode int
+	Err      error
+	// Output is everything the command wrote to stdout and stderr, in the
+	// order it was written. It is also included in Error.
+	Output string
+}
+
+func (e *BuildError) Error() string {
+	var notFound *CommandNotFoundError
+	if errors.As(e.Err, &notFound) {
+		// There's no output, and the command is already in the message.
+		return notFound.Error()
+	}
+	return fmt.Sprintf("error building: \"%s %s\": %v\n%v", e.Command, strings.Join(e.Args, " "), e.Err, e.Output)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// CommandNotFoundError is the error returned when a command used by
+// BuildCommand or RunCommand can't be found on PATH.
+type CommandNotFoundError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandNotFoundError) Error() string {
+	return fmt.Sprintf("pitstop: command %q not found on PATH", e.Command)
+}
+
+func (e *CommandNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// RunStep runs an app, such as a Poller's Run. Run starts the app
+// asynchronously and returns a function to stop it, and Describe works the
+// same as it does for a Step.
+type RunStep interface {
+	Run() (stop func(), err error)
+	Describe() string
 }
 
 // RunFunc is a function that runs an application asynchronously and returns a
-// function to stop the app.
+// function to stop the app. It is a RunStep without a description; see
+// DescribeRun for giving it one.
 type RunFunc func() (stop func(), err error)
 
+// Run calls fn.
+func (fn RunFunc) Run() (func(), error) {
+	return fn()
+}
+
+// Describe returns "", since a RunFunc can't describe itself.
+func (fn RunFunc) Describe() string {
+	return ""
+}
+
 // RunCommand works similar to exec.Command, but rather than returning an
-// exec.Cmd it returns a RunFunc that can be reused.
-func RunCommand(command string, args ...string) RunFunc {
-	return func() (func(), error) {
-		cmd := exec.Command(command, args...)
-		var sb strings.Builder
-		cmd.Stdout = io.MultiWriter(os.Stdout, &sb)
-		cmd.Stderr = io.MultiWriter(os.Stderr, &sb)
-		err := cmd.Start()
-		if err != nil {
-			return nil, fmt.Errorf("error running: \"%s %s\": %w\n%v", command, strings.Join(args, " "), err, sb.String())
-		}
-		return func() {
-			cmd.Process.Kill()
-			// I'm not 100% sure if this is right, but adding it b/c it doesn't seem
-			// to break anything and could help avoid process leaks.
-			cmd.Process.Release()
-		}, nil
-	}
+// exec.Cmd it returns a RunStep that can be reused. The stop func it returns
+// is safe to call more than once. Like BuildCommand, the RunStep is described
+// by its command line.
+func RunCommand(command string, args ...string) RunStep {
+	return RunCommandOut(nil, nil, command, args...)
+}
+
+// RunCommandOut works like RunCommand, but the app's output is written to the
+// provided stdout and stderr writers rather than os.Stdout and os.Stderr. A nil
+// writer defaults to the corresponding os.Stdout or os.Stderr.
+func RunCommandOut(stdout, stderr io.Writer, command string, args ...string) RunStep {
+	return RunCommandWith(RunOptions{Stdout: stdout, Stderr: stderr}, command, args...)
+}
+
+// RunOptions are used to customize how RunCommandWith starts and stops an app.
+type RunOptions struct {
+	// Stdout and Stderr are where the app's output is written. They default to
+	// os.Stdout and os.Stderr.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Stdin is where the app reads its input from, such as os.Stdin for an app
+	// with an interactive console. This defaults to nil, which means the app
+	// reads from the null device.
+	//
+	// Every app the poller starts shares Stdin, so it is best suited to running
+	// a single interactive process. When Stdin is an *os.File, such as
+	// os.Stdin, each app reads from it directly and any input typed while the
+	// app is restarting is left for the next one. Any other io.Reader is
+	// copied to the app by a goroutine, so input it has already read when the
+	// app exits is lost rather than passed on to the next one. On Unix, an app
+	// started with ProcessGroup is in the background as far as the terminal is
+	// concerned, and will be stopped by the terminal if it tries to read from
+	// it, so ProcessGroup shouldn't be combined with a terminal Stdin.
+	Stdin io.Reader
+
+	// ProcessGroup starts the app in its own process group, and the stop func
+	// will kill the entire group rather than only the app's process. This
+	// makes sure any processes the app starts, such as the binary started by
+	// "go run", are stopped along with it. Because the app is no longer in
+	// the terminal's process group it won't receive Ctrl-C directly, so the
+	// poller should be stopped when pitstop receives a signal. See PollContext
+	// for an example. On Windows the app and every process it started are
+	// always killed using taskkill, whether or not ProcessGroup is set.
+	ProcessGroup bool
+
+	// Env is a list of additional environment variables, in the form
+	// "KEY=value", that are added to the app's environment. The app inherits
+	// the rest of its environment from pitstop.
+	Env []string
+
+	// Prefix is written before every line of the app's output, such as
+	// "api | ", so the output of several apps run together using MultiRun can
+	// be told apart. A final line without a newline is written with one once
+	// the app exits.
+	Prefix string
+
+	// Timestamps will cause every line of the app's output to start with the
+	// time it was written, such as "15:04:05.000", before any Prefix. See
+	// TimestampWriter for doing the same with the output of a build.
+	Timestamps bool
+
+	// StopTimeout is how long the stop func waits for the app to exit after
+	// asking it to stop. On Unix the app is first sent StopSignal so it can
+	// shut down gracefully, and if it is still running after StopTimeout it is
+	// killed. Either way the stop func doesn't return until the app has
+	// exited, so any ports it was listening on are free for the next build.
+	// This defaults to 5 seconds.
+	StopTimeout time.Duration
+
+	// StopSignal is the signal sent to ask the app to stop before it is
+	// killed, such as os.Interrupt for an app that only handles Ctrl-C, or
+	// syscall.SIGQUIT to have a Go app print the stack trace of every
+	// goroutine as it exits. It defaults to SIGTERM. With ProcessGroup, every
+	// process in the group is sent the signal. Windows can't send signals to
+	// other processes, so StopSignal is ignored there and the app is always
+	// killed.
+	StopSignal os.Signal
+
+	// Listener is passed to the app as file descriptor 3, and the
+	// PITSTOP_LISTENER_FD environment variable is set to 3 so the app knows
+	// to serve on it rather than listening itself. In Go that looks like
+	// net.FileListener(os.NewFile(3, "")), which Listen takes care of. See
+	// ListenerRun for keeping a listener open while the app restarts.
+	// Listener must be a *net.TCPListener or *net.UnixListener, and isn't
+	// supported on Windows.
+	Listener net.Listener
+
+	// Limits constrains the memory and CPU the app, and every process it
+	// starts, can use. They are only enforced on Linux; see Limits for what
+	// that requires.
+	Limits Limits
+}
+
+// RunCommandWith works like RunCommand, but uses opts to customize how the
+// app is started and stopped.
+func RunCommandWith(opts RunOptions, command string, args ...string) RunStep {
+	return describeRun(runProcess(processCommand(context.Background(), opts, command, args, nil)), command, args)
+}
+
+// RunCommandContext works like RunCommand, but if ctx is done while the app is
+// running it will be killed rather than being asked to stop. If ctx is
+// already done the app isn't started and an error wrapping ctx.Err() is
+// returned.
+func RunCommandContext(ctx context.Context, command string, args ...string) RunStep {
+	return describeRun(runProcess(processCommand(ctx, RunOptions{}, command, args, nil)), command, args)
 }
 
-// Run will run all pre BuildFuncs, then the RunFunc, and then finally the post
-// BuildFuncs. Any errors encountered will be returned, and the build process
-// halted. If RunFunc has been called, stop will also be called so that it is
-// guaranteed to not be running anytime an error is returned.
-func Run(pre []BuildFunc, run RunFunc, post []BuildFunc) (func(), error) {
-	for _, fn := range pre {
-		err := fn()
+// runProcess returns a RunStep that starts the app using start and stops it
+// using the Process's Stop.
+func runProcess(start ProcessStep) runFunc {
+	return func(sc stepContext) (func(), error) {
+		proc, err := startProcess(sc, start)
 		if err != nil {
 			return nil, err
 		}
+		return proc.Stop, nil
 	}
-	stop, err := run()
+}
+
+// lockedWriter is an io.Writer that is safe to write to from multiple
+// goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// defaultOutput replaces nil writers with os.Stdout and os.Stderr.
+func defaultOutput(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	return stdout, stderr
+}
+
+// Run will run all pre Steps, then the RunStep, and then finally the post
+// Steps. Any errors encountered will be returned, and the build process
+// halted. If the RunStep has been run, stop will also be called so that it is
+// guaranteed to not be running anytime an error is returned. The stop func
+// that is returned is never nil, so it is always safe to call or defer, even
+// if an error is returned or run returned a nil stop func. In those cases it
+// does nothing.
+func Run(pre []Step, run RunStep, post []Step) (func(), error) {
+	stop, _, err := RunWithResult(pre, run, post)
+	return stop, err
+}
+
+// RunResult describes how a call to RunWithResult went.
+type RunResult struct {
+	// PreDuration, RunStartDuration, and PostDuration are how long the pre
+	// Steps, the RunStep, and the post Steps took. A phase that
+	// wasn't reached has a duration of 0.
+	PreDuration      time.Duration
+	RunStartDuration time.Duration
+	PostDuration     time.Duration
+
+	// FailedPhase is the phase that returned an error, which is one of "pre",
+	// "run", or "post". It is empty if every phase succeeded.
+	FailedPhase string
+}
+
+// RunWithResult works like Run, but also returns a RunResult recording how
+// long each phase took and which one failed, if any.
+func RunWithResult(pre []Step, run RunStep, post []Step) (func(), RunResult, error) {
+	return runWithResult(context.Background(), stepContext{}, pre, run, post)
+}
+
+// RunContext works like Run, but stops early once ctx is done. ctx is checked
+// before running each of the Steps and the RunStep, and once it is done
+// ctx.Err() is returned without running any more of them. If the app was
+// already started it is stopped first. Steps created by BuildCommandContext
+// with the same ctx are also killed if they are running when ctx is done, and
+// Retry stops waiting to try again.
+func RunContext(ctx context.Context, pre []Step, run RunStep, post []Step) (func(), error) {
+	stop, _, err := runWithResult(ctx, stepContext{ctx: ctx}, pre, run, post)
+	return stop, err
+}
+
+// runWithResult is RunWithResult, stopping early once ctx is done as described
+// by RunContext. The phase that was running or about to run when ctx was done
+// is recorded as the FailedPhase. Each step is run with sc.
+func runWithResult(ctx context.Context, sc stepContext, pre []Step, run RunStep, post []Step) (func(), RunResult, error) {
+	var result RunResult
+	noop := func() {}
+	start := time.Now()
+	err := runSteps(ctx, sc, pre)
+	result.PreDuration = time.Since(start)
 	if err != nil {
-		return nil, err
+		result.FailedPhase = "pre"
+		return noop, result, err
 	}
-	for _, fn := range post {
-		err := fn()
-		if err != nil {
-			stop()
-			return nil, err
+	if err := ctx.Err(); err != nil {
+		result.FailedPhase = "run"
+		return noop, result, err
+	}
+	start = time.Now()
+	stop, err := runStep(sc, run)
+	result.RunStartDuration = time.Since(start)
+	if err != nil {
+		result.FailedPhase = "run"
+		return noop, result, err
+	}
+	if stop == nil {
+		// A RunStep with nothing to stop, or one that forgot to return its
+		// stop func, shouldn't make every caller check.
+		stop = noop
+	}
+	start = time.Now()
+	err = runSteps(ctx, sc, post)
+	result.PostDuration = time.Since(start)
+	if err != nil {
+		stop()
+		result.FailedPhase = "post"
+		return noop, result, err
+	}
+	return stop, result, nil
+}
+
+// runSteps works like Chain, but returns ctx.Err() rather than calling the
+// next of steps once ctx is done. Each step is run with sc.
+func runSteps(ctx context.Context, sc stepContext, steps []Step) error {
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := buildStep(sc, step); err != nil {
+			return err
 		}
 	}
-	return stop, nil
+	return nil
 }
 
+// DefaultIgnores are the patterns a Poller ignores in addition to its Ignore
+// patterns unless NoDefaultIgnores is set. They cover directories that almost
+// never contain changes the app cares about, but can be large enough to slow
+// down every scan. It can be modified before starting a Poller to change the
+// defaults.
+var DefaultIgnores = []string{".git/", "node_modules/", ".idea/", ".vscode/"}
+
+// EditorTempIgnores are the patterns a Poller ignores in addition to its
+// Ignore patterns unless NoEditorTempIgnores is set. They cover the temporary
+// files editors create while saving, such as vim's swap files and the "4913"
+// file it writes to check that a directory is writable, so saving a file only
+// rebuilds because of the file itself. Like DefaultIgnores, it can be modified
+// before starting a Poller to change the defaults.
+var EditorTempIgnores = []string{"*.swp", "*.swo", "*.swx", "*~", ".#*", "4913", "*.tmp"}
+
 // Poller is used to poll a directory and its subdirectories for changes, and
 // then will kick off a rebuild of the app when changes are detected.
+//
+// A Poller's fields shouldn't be modified once it has started polling. The
+// setters, such as SetIgnore and SetPre, can be used to safely change parts
+// of its configuration while it is running.
 type Poller struct {
 	// ScanInterval is the duration of time the poller will wait before scanning for new file changes. This defaults to 500ms.
 	ScanInterval time.Duration
 
-	// Dir is the directory to scan for file changes. This defaults to "." if it isn't provided.
+	// MaxScanInterval enables adaptive scanning. Each scan that doesn't find
+	// any changes multiplies the time until the next scan by ScanBackoff, up
+	// to MaxScanInterval, and the interval drops back to ScanInterval as soon
+	// as a change is found. This keeps the poller responsive while files are
+	// being edited, but mostly idle when they aren't. This defaults to 0,
+	// which always scans every ScanInterval.
+	MaxScanInterval time.Duration
+
+	// ScanBackoff is the factor the scan interval grows by after each scan
+	// without changes when MaxScanInterval is set. This defaults to 2.
+	ScanBackoff float64
+
+	// MinRebuildInterval is the minimum amount of time between the start of
+	// one rebuild and the start of the next. Changes detected before it has
+	// elapsed aren't lost; they are coalesced into a single rebuild once the
+	// interval is up. This defaults to 0, which means there is no minimum.
+	// Rule.MinRebuildInterval can override it for the files a rule handles.
+	MinRebuildInterval time.Duration
+
+	// SettleDelay is how long files must go without changing before a change
+	// triggers a rebuild. Once a scan finds changes, the poller keeps waiting
+	// SettleDelay and scanning again until no more files have changed, then
+	// rebuilds once with every file that changed along the way. This keeps a
+	// burst of saves, such as a formatter rewriting many files or a branch
+	// checkout, from triggering a rebuild partway through. Saves that replace
+	// a file, such as an editor writing a temp file and renaming it over the
+	// original, are noticed like any other change. This defaults to 0, which
+	// rebuilds as soon as a change is found. Rule.SettleDelay can override it
+	// for the files a rule handles.
+	SettleDelay time.Duration
+
+	// StabilityWindow, if set, holds back each changed file until its size
+	// has stayed the same for StabilityWindow, so a large file that is still
+	// being written, such as a generated asset, doesn't trigger a build
+	// against half of it. The size of each file is checked on every scan, so
+	// the window is rounded up to a whole number of ScanIntervals. Other
+	// changes found in the meantime are built right away, and files that
+	// are removed while being held back are let through. Unlike SettleDelay
+	// it looks at each file on its own, so a steady stream of other changes
+	// doesn't hold up a build.
+	StabilityWindow time.Duration
+
+	// ForceRebuildInterval will cause the app to be rebuilt whenever this much
+	// time has passed since the last build started, even if no changes were
+	// found. It is a safety net for filesystems that don't reliably report
+	// mtime changes, such as some bind mounts into containers. Because it is
+	// measured from the last build, a rebuild caused by a change pushes the
+	// next forced rebuild back rather than being followed by another one right
+	// away. This defaults to 0, which never forces a rebuild.
+	ForceRebuildInterval time.Duration
+
+	// Dir is the directory to scan for file changes. This defaults to "." if it
+	// isn't provided and Dirs is empty.
 	Dir string
 
-	// Pre, Run, and Post represent the functions used to build and run our app.
-	// Pre functions are called first, then run, then finally the post functions.
-	Pre  []BuildFunc
-	Run  RunFunc
-	Post []BuildFunc
+	// Dirs is a list of additional directories to scan for file changes. If both
+	// Dir and Dirs are provided, Dir is treated as another entry in Dirs. A
+	// change in any of the directories will result in a single rebuild.
+	Dirs []string
+
+	// MaxDepth limits how many levels of subdirectories will be scanned for
+	// changes. This defaults to 0, which means there is no limit. See
+	// Watcher.MaxDepth for details.
+	MaxDepth int
+
+	// TopLevelOnly will cause the poller to only scan the files directly
+	// inside each directory, without any subdirectories. It overrides
+	// MaxDepth.
+	TopLevelOnly bool
+
+	// RespectGitignore will cause the poller to skip any paths matched by
+	// .gitignore files found while scanning for changes.
+	RespectGitignore bool
+
+	// WorkDir is a directory for the build's output, such as "tmp" or "bin".
+	// It is created before polling starts if it doesn't exist, it is never
+	// scanned for changes, and its absolute path is stored in the
+	// PITSTOP_WORKDIR environment variable so that commands run by the build
+	// can write to it, such as with:
+	//
+	//	pitstop.BuildCommand("sh", "-c", `go build -o "$PITSTOP_WORKDIR/app" .`)
+	//
+	// Since the environment is shared by the whole program, every Poller
+	// using a WorkDir sets the same variable.
+	WorkDir string
+
+	// CleanWorkDir will cause the poller to remove WorkDir and everything
+	// inside it once the app has been stopped and OnShutdown has returned.
+	// WorkDir is removed the same way as with Clean, so Poll returns an
+	// error before anything is built if WorkDir isn't inside the current
+	// directory.
+	CleanWorkDir bool
+
+	// ExcludeOutputs is a list of paths that are produced by the build, such as
+	// the binary written by "go build -o ./app", which should never be treated
+	// as a change. If a build writes into a watched directory and its output
+	// isn't excluded, the poller can see its own output as a change and rebuild
+	// forever.
+	ExcludeOutputs []string
+
+	// IgnoreDirs is a list of directories, relative to Dir and each of Dirs,
+	// that are never scanned. See Watcher.IgnoreDirs for how they are
+	// matched. They are simpler and faster than Ignore patterns for the
+	// common case of skipping whole directories, such as generated code or
+	// node_modules.
+	IgnoreDirs []string
+
+	// Ignore and Include are lists of .gitignore style patterns used to decide
+	// which files are scanned for changes. See Watcher.Ignore and
+	// Watcher.Include for details. DefaultIgnores and EditorTempIgnores are
+	// always ignored as well unless NoDefaultIgnores or NoEditorTempIgnores is
+	// set. Patterns from a .pitstopignore file in any of the watched
+	// directories are added to Ignore for that directory, so what is watched
+	// can be tuned without changing .gitignore. See LoadIgnoreFile for reading
+	// patterns from a file yourself.
+	Ignore  []string
+	Include []string
+
+	// Patterns is a list of globs, such as "**/*.go" and "config.yaml", that
+	// limit which files are treated as changes. See Watcher.Patterns for
+	// details.
+	Patterns []string
+
+	// NoDefaultIgnores will cause the poller to scan the directories listed in
+	// DefaultIgnores, such as .git, rather than skipping them.
+	NoDefaultIgnores bool
+
+	// NoEditorTempIgnores will cause the poller to treat editor temp files,
+	// such as those matching the patterns in EditorTempIgnores, as changes
+	// like any other file.
+	NoEditorTempIgnores bool
+
+	// HashCompare will cause the poller to only rebuild when the contents of a
+	// file change, not just its mtime. See Watcher.HashCompare for details.
+	HashCompare bool
+
+	// IgnoreTestFiles will cause the poller to skip rebuilding when every file
+	// that changed is a test file, since tests aren't part of the running app.
+	// If any other file changed the app is rebuilt as usual. Test files are
+	// those matching TestFilePatterns.
+	IgnoreTestFiles bool
+
+	// TestFilePatterns is a list of .gitignore style patterns used by
+	// IgnoreTestFiles to decide which files are test files. This defaults to
+	// "*_test.go".
+	TestFilePatterns []string
+
+	// SoftIgnore is a list of .gitignore style patterns for files that change
+	// often but shouldn't cause a rebuild on their own, such as a timestamp
+	// file the app writes. Unlike Ignore, the files are still scanned: if
+	// every file that changed is matched by SoftIgnore the rebuild is
+	// skipped, but if any other file changed too the app is rebuilt as usual
+	// and they are included in the changed files. Test files skipped by
+	// IgnoreTestFiles are treated the same way, so a change to only test
+	// files and soft ignored files is skipped as well.
+	SoftIgnore []string
+
+	// ShouldRebuild is an optional hook that decides whether the changed
+	// files, which have already been filtered by Ignore, Include, and
+	// IgnoreTestFiles, warrant a rebuild. If it returns false the rebuild is
+	// skipped, and those changes won't be reported to it again.
+	ShouldRebuild func(changed []string) bool
+
+	// FollowSymlinks will cause the poller to scan the directories and files
+	// that symlinks point to. See Watcher.FollowSymlinks for details.
+	FollowSymlinks bool
+
+	// MtimeGranularity is the resolution of the mtimes recorded by the
+	// filesystem being scanned. See Watcher.MtimeGranularity for details.
+	MtimeGranularity time.Duration
+
+	// Pre, Run, and Post represent the steps used to build and run our app.
+	// Pre steps are run first, then run, then finally the post steps.
+	Pre  []Step
+	Run  RunStep
+	Post []Step
+
+	// Rules route changes to specific build steps, so that changes which don't
+	// affect the running app, such as to CSS files, don't restart it. When
+	// files change, each one is handled by the first rule whose Match
+	// patterns it matches. The Pre of every rule handling at least one file
+	// are run in the order the rules are defined. If any of those rules has
+	// Restart set, or any file isn't handled by a rule, the app is then
+	// rebuilt and restarted using Pre, Run, and Post as usual. Otherwise
+	// the app keeps running. Rules aren't used for the initial build.
+	Rules []Rule
+
+	// Handlers run a build step whenever a file they match changes, such as
+	// regenerating a client when openapi.yaml changes. The Build of every
+	// handler matching at least one changed file is run in the order the
+	// handlers are defined, before the steps from Rules and the poller's
+	// Pre. Files matched by a handler with Instead set stop there, so if
+	// every changed file is, only the handlers run and the app keeps
+	// running. Other files are routed through Rules as usual. Like Rules,
+	// Handlers aren't used for the initial build.
+	Handlers []Handler
+
+	// RunProcess can be used in place of Run to start the app, and is used
+	// instead of Run if both are set. Because it returns a Process rather than
+	// only a stop func, PID can report the running app's process ID and
+	// Running can tell when the app has exited on its own.
+	RunProcess ProcessStep
+
+	// RestartOnExit will cause the poller to rebuild and restart the app if it
+	// exits on its own. It only works with RunProcess, since a RunStep doesn't
+	// report when the app exits.
+	RestartOnExit bool
+
+	// CrashLoopThreshold and CrashLoopWindow are used with RestartOnExit to
+	// catch an app that exits again every time it is restarted, such as when
+	// its config is broken or its port is in use. If the app exits
+	// CrashLoopThreshold times within CrashLoopWindow, the poller stops
+	// restarting it until a file changes. They default to 5 times in 10
+	// seconds.
+	CrashLoopThreshold int
+	CrashLoopWindow    time.Duration
+
 	// OnError is similar to Pre and Post, but is only called when Pre, Run, or
 	// Post encounter an error.
 	OnError func(error)
+
+	// RepeatedErrorWindow and RepeatedErrorLimit control what the poller
+	// prints for an error identical to the last one it printed, such as when
+	// a build keeps failing the same way or the app is stuck crashing.
+	// Rather than printing it again, the poller counts it and prints
+	// something like "(same error, suppressed 12 times)" once
+	// RepeatedErrorWindow has passed since the error was last printed, or
+	// once RepeatedErrorLimit have been suppressed if it is positive. A
+	// different error is always printed right away. RepeatedErrorWindow
+	// defaults to 30 seconds, and a negative value prints every error.
+	// OnError is still called for every error.
+	RepeatedErrorWindow time.Duration
+	RepeatedErrorLimit  int
+
+	// OnWalkError is called with the path and error of each file or
+	// directory that can't be scanned, such as a subdirectory without read
+	// permission. Those paths are skipped and the rest are still scanned. If
+	// it is nil, the error for each path is printed the first time it
+	// happens. See Watcher.OnWalkError.
+	OnWalkError func(path string, err error)
+
+	// OnBuildStart and OnBuildEnd are optional callbacks invoked right before
+	// the Pre functions are called and right after the Post functions finish
+	// respectively. OnBuildEnd is passed the error that halted the build, or nil
+	// if the build was successful.
+	OnBuildStart func()
+	OnBuildEnd   func(error)
+
+	// OnShutdown is an optional callback invoked once when Poll, PollContext,
+	// or a poller launched with Start exits, such as to remove temp files or
+	// stop containers the app depends on. Unlike Post, it isn't called
+	// between rebuilds. It is called after the running app has been stopped
+	// and any Triggers have returned, and before the Events channels are
+	// closed. It isn't called if Poll returns an error for the poller's
+	// configuration, since nothing was started.
+	OnShutdown func()
+
+	// Triggers are additional sources of rebuilds, such as a TickerTrigger.
+	// Each time one of them fires, the app is rebuilt and restarted just like
+	// when Trigger is called.
+	Triggers []Trigger
+
+	// DisableScan will cause the poller not to scan for file changes. This
+	// can be used with Triggers to rebuild using only those.
+	DisableScan bool
+
+	// PauseDuringBuild will cause the poller to ignore files the build
+	// rewrites without changing them, such as generated code or compiler temp
+	// files, so they don't trigger another build. By default changes are
+	// compared against the time the last build started, so anything written
+	// during a build causes another one. With PauseDuringBuild the poller
+	// hashes the files it watches before each build, and a file changed while
+	// the build was running only causes another build if its contents are
+	// different than they were before it started. A file you save during a
+	// long build still causes exactly one more build once it finishes. A file
+	// the build writes with new contents, such as generated code whose source
+	// changed, also causes one more build, and one it writes with different
+	// contents every time, such as a timestamp, should be ignored with Ignore
+	// instead. Files are only rehashed when their mtime changes, but hashing
+	// them is still more expensive than the default.
+	PauseDuringBuild bool
+
+	// KeepLastGoodOnFailure will cause the poller to keep the running app
+	// running until its replacement has been built and started. By default
+	// the app is stopped before Pre runs, so a broken build leaves nothing
+	// running. With KeepLastGoodOnFailure, Pre, Run, and Post are all run
+	// while the previous app is still running, and it is only stopped once
+	// they all succeed. If any of them fail, the new app is stopped if it
+	// was started and the previous one keeps running. Post can be used to
+	// check that the new app is healthy, such as with WaitForHTTP, before
+	// the previous one is stopped.
+	//
+	// Because both apps run at the same time for a moment, they must not
+	// conflict with each other, such as by listening on the same port. On
+	// Windows, a binary can't be replaced while it is running, so apps built
+	// with GoWith should use GoBuildOptions.AtomicOutput. Steps from Rules
+	// that don't restart the app aren't affected.
+	KeepLastGoodOnFailure bool
+
+	// RestartDelay is how long to wait after the running app is stopped
+	// before starting the next one, for apps whose port isn't free the
+	// moment they exit, which causes "address already in use" errors. Time
+	// spent running Pre counts towards the delay, so it only adds as much as
+	// is left once the build is done. Stopping the poller during the delay
+	// cancels the build right away. It isn't used with KeepLastGoodOnFailure,
+	// since the new app is started before the previous one is stopped. This
+	// defaults to 0, which starts the next app right away.
+	RestartDelay time.Duration
+
+	// NoBuildOnStart will cause the poller to wait for a file to change before
+	// the first build. By default the app is always built and run once when
+	// the poller starts, even if no files are found.
+	NoBuildOnStart bool
+
+	// StartupQuietPeriod will cause the poller to wait for files to stop
+	// changing before the first build, so launching pitstop partway through
+	// an edit doesn't build a half written file. The first build waits
+	// until no changes have been made for StartupQuietPeriod, however long
+	// that takes. It has no effect with NoBuildOnStart, since the first
+	// build already waits for a change; see SettleDelay for waiting on
+	// changes before every build.
+	StartupQuietPeriod time.Duration
+
+	// ClearScreen will cause the poller to clear the terminal before each
+	// build, so only the output from the latest build and run is visible.
+	// Nothing is written if stdout isn't a terminal, such as when it is
+	// redirected to a log file.
+	ClearScreen bool
+
+	// Verbosity controls how much the poller prints. It defaults to Normal.
+	// Silent only prints errors, while Verbose also prints every scan, each
+	// of the files that changed, and how long each build step took.
+	Verbosity Verbosity
+
+	// NoLifecycleLogs hides the routine messages printed as the app is built,
+	// run, and stopped, such as "Building & Running app...", without hiding
+	// errors the way Silent does.
+	NoLifecycleLogs bool
+
+	// LogOutput is where the poller prints its messages, which are meant to
+	// be read by people. It defaults to os.Stdout, and ioutil.Discard hides
+	// them entirely, even errors. It doesn't affect the output of Pre, Run,
+	// and Post, but messages the steps from this package print themselves,
+	// such as those from Timed, are printed here too.
+	LogOutput io.Writer
+
+	// JSONOutput, if set, is written a line of JSON for every Event the
+	// poller publishes and every error it prints, for CI systems and other
+	// tools to consume. It is separate from LogOutput, so either or both
+	// can be used. Each line is an object with the following fields:
+	//
+	//	type           change_detected, build_started, build_finished,
+	//	               app_stopped, or error
+	//	time           when it happened, in RFC 3339 format and UTC
+	//	changed_files  the files that changed, for change_detected
+	//	error          the error, for error and failed build_finished lines
+	//	duration_ms    how long the build took, for build_finished
+	//	port           the AutoPort port, for build_finished
+	//
+	// Fields that don't apply are left out. For example:
+	//
+	//	{"type":"build_finished","time":"2024-05-01T12:00:01.5Z","duration_ms":1500}
+	//
+	// Writes are never concurrent, and write errors are ignored.
+	JSONOutput io.Writer
+
+	// DryRun will cause the poller to print each Pre, Run, and Post step it
+	// would have run when a change is detected rather than running it.
+	// Steps created by BuildCommand, RunCommand, and the other command
+	// constructors print the command line they would have run, such as
+	// "Dry run: go build -o app .".
+	DryRun bool
+
+	// Clock is used to tell time and wait between scans. This defaults to the
+	// real time, but tests can provide a fake Clock to control exactly when
+	// scans and rebuilds happen. File mtimes are still compared against
+	// Clock.Now, so a fake Clock should start near the real time.
+	Clock Clock
+
+	// configMu guards the fields that can be changed while polling using
+	// SetIgnore, SetPre, and the other setters.
+	configMu sync.Mutex
+
+	// mu guards cancel and done, which are used by Start and Stop.
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// eventsMu guards subscribers, and polling, which reports whether the
+	// poll loop is running.
+	eventsMu    sync.Mutex
+	subscribers []chan Event
+	polling     bool
+
+	statsMu       sync.Mutex
+	stats         Stats
+	totalDuration time.Duration
+
+	triggerOnce sync.Once
+	trigger     chan struct{}
+
+	restartOnce sync.Once
+	restart     chan struct{}
+
+	// appMu guards running and proc, which describe the app the poll loop
+	// most recently started.
+	appMu   sync.Mutex
+	running bool
+	proc    *Process
+}
+
+// Stats describes the builds a Poller has run.
+type Stats struct {
+	// Builds is the number of builds that have run, including failed ones.
+	Builds int
+	// Failures is the number of builds that returned an error.
+	Failures int
+	// LastDuration and AvgDuration are how long the most recent build took,
+	// and how long builds took on average.
+	LastDuration time.Duration
+	AvgDuration  time.Duration
+	// LastError is the error returned by the most recent build, or nil if it
+	// was successful.
+	LastError error
+}
+
+// Stats returns statistics about the builds the poller has run so far. It is
+// safe to call while the poller is running.
+func (p *Poller) Stats() Stats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	return p.stats
+}
+
+// recordBuild adds a build that took d and returned err to the stats.
+func (p *Poller) recordBuild(d time.Duration, err error) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.stats.Builds++
+	if err != nil {
+		p.stats.Failures++
+	}
+	p.totalDuration += d
+	p.stats.LastDuration = d
+	p.stats.AvgDuration = p.totalDuration / time.Duration(p.stats.Builds)
+	p.stats.LastError = err
+}
+
+// Trigger forces the poller to rebuild and restart the app as soon as
+// possible, even if no files have changed. This is useful when something the
+// poller can't see has changed, such as an environment variable or an
+// external data source. It is safe to call from any goroutine, and calls made
+// while a rebuild is already pending are combined into a single rebuild.
+func (p *Poller) Trigger() {
+	select {
+	case p.triggers() <- struct{}{}:
+	default:
+		// A rebuild is already pending.
+	}
+}
+
+// Restart stops the running app and starts it again using only Run or
+// RunProcess, without running Pre or Post. It is a faster alternative to
+// Trigger for when only something the app reads as it starts has changed,
+// such as an environment variable set with os.Setenv, a config file, or a
+// service it connects to. Anything produced by Pre, such as the binary built
+// by "go build", isn't updated, so Trigger is needed instead whenever the
+// code or another input of Pre changes. If there is no app running, such as
+// when the last build failed or with NoBuildOnStart, a full rebuild is run
+// instead since there is no built app to restart.
+//
+// Like Trigger, Restart is safe to call from any goroutine, and calls made
+// while a restart is already pending are combined. Restarts are handled by
+// the poll loop between builds, so a call made during a build waits for it
+// to finish and then restarts the app it started.
+func (p *Poller) Restart() {
+	select {
+	case p.restarts() <- struct{}{}:
+	default:
+		// A restart is already pending.
+	}
+}
+
+// TriggerOn calls Trigger every time pitstop receives one of sigs. On Unix
+// this can be used to force a rebuild with "kill -HUP <pid>":
+//
+//	stop := p.TriggerOn(syscall.SIGHUP)
+//	defer stop()
+//
+// The returned func stops relaying the signals.
+func (p *Poller) TriggerOn(sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				p.Trigger()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// relay calls Trigger every time t fires, until ctx is done or t returns an
+// error.
+func (p *Poller) relay(ctx context.Context, t Trigger, log logger) {
+	for {
+		err := t.Wait(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.errorf("Error waiting for trigger: %v", err)
+			return
+		}
+		p.Trigger()
+	}
+}
+
+// triggers returns the channel used by Trigger, creating it if needed.
+func (p *Poller) triggers() chan struct{} {
+	p.triggerOnce.Do(func() {
+		p.trigger = make(chan struct{}, 1)
+	})
+	return p.trigger
+}
+
+// restarts returns the channel used by Restart, creating it if needed.
+func (p *Poller) restarts() chan struct{} {
+	p.restartOnce.Do(func() {
+		p.restart = make(chan struct{}, 1)
+	})
+	return p.restart
+}
+
+// Running reports whether the poller currently has an app running. An app
+// started by RunProcess is no longer considered running once it exits, but
+// an app started by Run is considered running until the poller stops it.
+func (p *Poller) Running() bool {
+	p.appMu.Lock()
+	defer p.appMu.Unlock()
+	if p.proc != nil {
+		return p.running && p.proc.Running()
+	}
+	return p.running
+}
+
+// PID returns the process ID of the running app. false is returned if no app
+// is running, or if the app wasn't started by RunProcess since a RunStep
+// doesn't expose its process.
+func (p *Poller) PID() (int, bool) {
+	p.appMu.Lock()
+	defer p.appMu.Unlock()
+	if !p.running || p.proc == nil || !p.proc.Running() {
+		return 0, false
+	}
+	return p.proc.PID(), true
+}
+
+// setApp records the app the poll loop most recently started or stopped.
+func (p *Poller) setApp(running bool, proc *Process) {
+	p.appMu.Lock()
+	defer p.appMu.Unlock()
+	p.running, p.proc = running, proc
 }
 
 // Poll is a long running process that continuously scans for changes and
-// then runs the build and run functions when changes are detected.
-func (p *Poller) Poll() {
+// then runs the build and run functions when changes are detected. Before it
+// starts, the poller's configuration is checked and an error is returned
+// right away if Run and RunProcess are both nil, if any of the directories it
+// would scan don't exist, if WorkDir can't be created, or if a command used
+// by BuildCommand, RunCommand, or the like isn't on PATH. Commands given as a
+// path, such as "./tmp/app", aren't checked because they might not have been
+// built yet, and nothing is checked for steps that aren't created from a
+// command. If a directory can't be scanned later on, such as when it has been
+// deleted, an error is printed and the poller keeps scanning in case it comes
+// back.
+func (p *Poller) Poll() error {
+	return p.PollContext(context.Background())
+}
+
+// PollContext works like Poll, but returns once ctx is done. Before returning
+// the running app is stopped. This can be used to stop the app when pitstop
+// is interrupted:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer stop()
+//	p.PollContext(ctx)
+//
+// If ctx is done during a build, the rest of the build's steps are skipped.
+// Steps created by BuildCommandContext with ctx are killed rather than being
+// left to finish.
+func (p *Poller) PollContext(ctx context.Context) error {
+	if err := p.prepare(); err != nil {
+		return err
+	}
+	p.startPolling()
+	p.poll(ctx)
+	return nil
+}
+
+// Start runs Poll in a background goroutine. An error is returned if the
+// poller has already been started and hasn't been stopped, or if Poll would
+// have returned one for the poller's configuration.
+func (p *Poller) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != nil {
+		return errors.New("pitstop: poller already started")
+	}
+	if err := p.prepare(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.cancel, p.done = cancel, done
+	// This is done before poll starts so WaitForBuild works as soon as Start
+	// returns.
+	p.startPolling()
+	go func() {
+		defer close(done)
+		p.poll(ctx)
+	}()
+	return nil
+}
+
+// Stop signals a poller launched with Start to exit, then waits until it has
+// finished and stopped the running app. If a build is in progress, Stop will
+// wait for the current step to complete and the rest are skipped. Calling
+// Stop on a poller that isn't running does nothing.
+func (p *Poller) Stop() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Once runs the poller's Pre, Run, and Post a single time without scanning for
+// changes, and returns once the app is running or one of the steps fails. It
+// is useful for one-shot builds and for testing apps built with pitstop. If ctx
+// is already done nothing is run and ctx.Err() is returned, and if it is done
+// partway through the remaining steps are skipped, as with RunContext. As with
+// Run, the app is stopped before an error from a Post step is returned. The
+// stop func is never nil and is safe to call more than once, so it can always
+// be deferred.
+func (p *Poller) Once(ctx context.Context) (func(), error) {
+	noop := func() {}
+	if err := ctx.Err(); err != nil {
+		return noop, err
+	}
+	if err := p.validateSteps(); err != nil {
+		return noop, err
+	}
+	if err := p.createWorkDir(); err != nil {
+		return noop, err
+	}
+	log := logger{verbosity: p.Verbosity, noLifecycle: p.NoLifecycleLogs, out: p.LogOutput}
+	var proc *Process
+	cfg := p.config(&proc)
+	runStop, _, err := runWithResult(ctx, stepContext{log: log, ctx: ctx, clock: p.Clock}, cfg.pre, cfg.run, cfg.post)
+	if err != nil {
+		return noop, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(runStop)
+	}, nil
+}
+
+// poll is the loop behind Poll. It returns once ctx is done, stopping the
+// running app before it does.
+func (p *Poller) poll(ctx context.Context) {
+	defer p.closeEvents()
 	scanInt := p.ScanInterval
 	if scanInt == 0 {
 		scanInt = 500 * time.Millisecond
 	}
-	dir := p.Dir
-	if dir == "" {
-		dir = "."
+	watcher := p.watcher()
+	clock := p.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	log := logger{verbosity: p.Verbosity, noLifecycle: p.NoLifecycleLogs, out: p.LogOutput}
+	if p.JSONOutput != nil {
+		log.onError = func(msg string) { p.logJSONError(clock.Now(), msg) }
+	}
+	if p.RepeatedErrorWindow >= 0 {
+		window := p.RepeatedErrorWindow
+		if window == 0 {
+			window = 30 * time.Second
+		}
+		log.repeats = &errorRepeats{now: clock.Now, window: window, limit: p.RepeatedErrorLimit}
+	}
+	sc := stepContext{log: log, ctx: ctx, clock: clock}
+	defer log.flushRepeats()
+	if watcher.OnWalkError == nil {
+		// Only print each path once rather than every scan.
+		skipped := make(map[string]bool)
+		watcher.OnWalkError = func(path string, err error) {
+			if !skipped[path] {
+				skipped[path] = true
+				log.errorf("Skipping %s: %v", path, err)
+			}
+		}
 	}
 	onError := p.OnError
 	if onError == nil {
 		onError = func(error) {}
 	}
+	onBuildStart := p.OnBuildStart
+	if onBuildStart == nil {
+		onBuildStart = func() {}
+	}
+	onBuildEnd := p.OnBuildEnd
+	if onBuildEnd == nil {
+		onBuildEnd = func(error) {}
+	}
+	if p.CleanWorkDir && p.WorkDir != "" {
+		defer func() {
+			if err := Clean(p.WorkDir)(); err != nil {
+				log.errorf("Error cleaning work dir: %v", err)
+			}
+		}()
+	}
+	if p.OnShutdown != nil {
+		// This is deferred before stopApp so that it runs after the app has
+		// been stopped, even if the loop panics.
+		defer p.OnShutdown()
+	}
+
+	// proc is the Process started by the most recent call to run, if any.
+	var proc *Process
+	cfg := p.config(&proc)
+	watcher.Ignore, watcher.Include = cfg.ignore, cfg.include
+
+	// quiet holds the rules for files that don't cause a rebuild on their own.
+	quiet := parseIgnorePatterns(p.SoftIgnore)
+	if p.IgnoreTestFiles {
+		patterns := p.TestFilePatterns
+		if len(patterns) == 0 {
+			patterns = []string{"*_test.go"}
+		}
+		quiet = append(quiet, parseIgnorePatterns(patterns)...)
+	}
 
 	var stop func()
-	var err error
-	var lastBuild time.Time
+	// stoppedAt is when stop was last called.
+	var stoppedAt time.Time
+	// since is the time files are compared against. It is usually the time the
+	// last build started, so changes made while it was running still trigger
+	// another build, but it also moves forward past changes that are skipped.
+	var since, lastBuildStart time.Time
+	// With PauseDuringBuild, hashes holds the contents of the watched files
+	// before the last build, and pending holds the files changed during it
+	// that the next scan should still treat as changes.
+	var hashes map[string]fileHash
+	var pending []string
+	// scanErr is the last error printed while scanning for changes.
+	var scanErr string
+	stopApp := func() {
+		if stop == nil {
+			return
+		}
+		log.lifecyclef("Stopping running app...")
+		stop()
+		stop = nil
+		stoppedAt = clock.Now()
+		p.setApp(false, nil)
+		p.publish(Event{Type: AppStopped, Time: clock.Now()})
+	}
+	defer stopApp()
+	// delayed wraps run so that it doesn't start until RestartDelay has
+	// passed since the last app was stopped.
+	delayed := func(run RunStep) RunStep {
+		if p.RestartDelay <= 0 || stoppedAt.IsZero() {
+			return run
+		}
+		return runFunc(func(sc stepContext) (func(), error) {
+			if wait := p.RestartDelay - clock.Now().Sub(stoppedAt); wait > 0 {
+				log.debugf("Waiting %v before starting the app", wait)
+				if !sleep(ctx, clock, wait) {
+					return nil, ctx.Err()
+				}
+			}
+			return runStep(sc, run)
+		})
+	}
+	// build stops the app and rebuilds it, or only runs the steps from
+	// Handlers and Rules if that is all the changes need. changed is the list
+	// of files that triggered the build, which is empty for the initial
+	// build.
+	build := func(changed []string) {
+		lastBuildStart = clock.Now()
+		pending = nil
+		handlerPre, rest := routeHandlers(cfg.handlers, watcher.dirs(), changed)
+		rulePre, restart := routeChanges(cfg.rules, watcher.dirs(), rest)
+		if len(changed) > 0 && len(rest) == 0 {
+			// Handlers took care of every change.
+			rulePre, restart = nil, false
+		}
+		rulePre = append(handlerPre, rulePre...)
+		keep := restart && p.KeepLastGoodOnFailure && stop != nil
+		if restart && !keep {
+			stopApp()
+		}
+		if p.ClearScreen {
+			clearScreen()
+		}
+		if len(changed) > 0 {
+			log.lifecyclef("%s", changeSummary(watcher.dirs(), changed))
+		} else {
+			log.lifecyclef("Building & Running app...")
+		}
+		if p.PauseDuringBuild && !p.DisableScan {
+			hashes = watcher.hashAll(hashes)
+		}
+		started := clock.Now()
+		p.publish(Event{Type: BuildStarted, Time: started})
+		onBuildStart()
+		var err error
+		var result RunResult
+		var port int
+		switch {
+		case keep:
+			prev, prevProc := stop, proc
+			proc = nil
+			var next func()
+			next, result, err = runWithResult(ctx, sc, append(rulePre, cfg.pre...), cfg.run, cfg.post)
+			if err != nil {
+				proc = prevProc
+				log.lifecyclef("Keeping the previous app running...")
+				break
+			}
+			stop = prev
+			stopApp()
+			stop = next
+		case restart:
+			proc = nil
+			stop, result, err = runWithResult(ctx, sc, append(rulePre, cfg.pre...), delayed(cfg.run), cfg.post)
+			if err != nil {
+				// Nothing is running, even though stop is safe to call.
+				stop = nil
+			}
+		default:
+			preStart := time.Now()
+			err = runSteps(ctx, sc, rulePre)
+			result.PreDuration = time.Since(preStart)
+			if err != nil {
+				result.FailedPhase = "pre"
+			}
+		}
+		if restart && err == nil {
+			p.setApp(true, proc)
+			if cfg.autoPort != nil {
+				port = cfg.autoPort.Port()
+				log.lifecyclef("Running app on port %d...", port)
+			}
+		}
+		onBuildEnd(err)
+		finished := clock.Now()
+		since = started
+		if p.PauseDuringBuild && !p.DisableScan {
+			// Of the files changed while the build ran, only the ones it
+			// didn't write back as they were count as changes, so an edit
+			// saved during the build still causes another one.
+			since = finished
+			during, _ := watcher.Scan(started)
+			for _, path := range during {
+				hash, err := hashFile(path)
+				if before, ok := hashes[path]; err != nil || !ok || before.hash != hash {
+					pending = append(pending, path)
+				}
+			}
+		}
+		p.recordBuild(finished.Sub(started), err)
+		p.publish(Event{
+			Type:     BuildFinished,
+			Time:     finished,
+			Err:      err,
+			Result:   result,
+			Duration: finished.Sub(started),
+			Port:     port,
+		})
+		// A build cut short because the poller is stopping isn't a failure.
+		if err != nil && ctx.Err() == nil {
+			log.errorf("Error running: %v", err)
+			onError(err)
+		}
+	}
 
+	// restartApp stops the app and starts it again without running Pre or
+	// Post.
+	restartApp := func() {
+		if stop == nil {
+			log.lifecyclef("No app running to restart, rebuilding instead...")
+			build(nil)
+			return
+		}
+		stopApp()
+		log.lifecyclef("Restarting app...")
+		proc = nil
+		var err error
+		stop, _, err = runWithResult(ctx, sc, nil, delayed(cfg.run), nil)
+		if err != nil {
+			stop = nil
+			if ctx.Err() == nil {
+				log.errorf("Error restarting: %v", err)
+				onError(err)
+			}
+			return
+		}
+		p.setApp(true, proc)
+		if cfg.autoPort != nil {
+			log.lifecyclef("Running app on port %d...", cfg.autoPort.Port())
+		}
+	}
+
+	// pollStart stands in for the last build when forcing rebuilds if there
+	// hasn't been one yet.
+	pollStart := clock.Now()
+	if p.NoBuildOnStart {
+		since = clock.Now()
+	} else {
+		for p.StartupQuietPeriod > 0 {
+			quietStart := clock.Now()
+			if !sleep(ctx, clock, p.StartupQuietPeriod) {
+				return
+			}
+			changed, err := watcher.Scan(quietStart)
+			if err != nil || len(changed) == 0 {
+				break
+			}
+			log.debugf("Found %d changes before the first build, waiting for them to stop", len(changed))
+		}
+		build(nil)
+	}
+	backoff := p.ScanBackoff
+	if backoff <= 1 {
+		backoff = 2
+	}
+	// interval is how long to wait before the next scan.
+	interval := scanInt
+	// growing holds the changed files whose size hasn't been stable for
+	// StabilityWindow yet.
+	var growing stabilityTracker
+	crashThreshold := p.CrashLoopThreshold
+	if crashThreshold <= 0 {
+		crashThreshold = 5
+	}
+	crashWindow := p.CrashLoopWindow
+	if crashWindow <= 0 {
+		crashWindow = 10 * time.Second
+	}
+	// exits holds when the app recently exited on its own, and handled is the
+	// last Process whose exit was dealt with. Restarts are paused after a
+	// crash loop until a file changes.
+	var exits []time.Time
+	var handled *Process
+	var paused bool
+
+	triggers := p.triggers()
+	restarts := p.restarts()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	triggerCtx, cancelTriggers := context.WithCancel(ctx)
+	defer cancelTriggers()
+	for _, t := range p.Triggers {
+		wg.Add(1)
+		go func(t Trigger) {
+			defer wg.Done()
+			p.relay(triggerCtx, t, log)
+		}(t)
+	}
+	// force fires once ForceRebuildInterval has passed since the build it was
+	// set up after, forcedAfter. It is only set up again after each build,
+	// rather than on every scan.
+	var force <-chan time.Time
+	stopForce := func() {}
+	defer func() { stopForce() }()
+	var forcedAfter time.Time
 	for {
-		if !DidChange(p.Dir, lastBuild) {
-			time.Sleep(scanInt)
+		var scan <-chan time.Time
+		if !p.DisableScan {
+			scan = clock.After(interval)
+		}
+		var exited <-chan struct{}
+		if p.RestartOnExit && !paused && proc != nil && proc != handled {
+			exited = proc.Exited()
+		}
+		if p.ForceRebuildInterval > 0 && (force == nil || !forcedAfter.Equal(lastBuildStart)) {
+			stopForce()
+			last := lastBuildStart
+			if last.IsZero() {
+				last = pollStart
+			}
+			force, stopForce = newTimer(clock, p.ForceRebuildInterval-clock.Now().Sub(last))
+			forcedAfter = lastBuildStart
+		}
+		var triggered bool
+		select {
+		case <-scan:
+		case <-triggers:
+			triggered = true
+		case <-restarts:
+			cfg = p.config(&proc)
+			paused = false
+			restartApp()
+			continue
+		case <-force:
+			force = nil
+			log.lifecyclef("No changes in %v, rebuilding anyway...", p.ForceRebuildInterval)
+			interval = scanInt
+			paused = false
+			build(nil)
+			continue
+		case <-exited:
+			handled = proc
+			now := clock.Now()
+			exits = append(exits, now)
+			for len(exits) > 0 && now.Sub(exits[0]) > crashWindow {
+				exits = exits[1:]
+			}
+			if len(exits) >= crashThreshold {
+				log.errorf("App exited %d times in %v; pausing restarts until the next file change.", len(exits), crashWindow)
+				exits, paused = nil, true
+				continue
+			}
+			log.lifecyclef("App exited (%v), restarting...", exitReason(proc.Err()))
+			build(nil)
+			continue
+		case <-ctx.Done():
+			return
+		}
+		cfg = p.config(&proc)
+		watcher.Ignore, watcher.Include = cfg.ignore, cfg.include
+		scanned := clock.Now()
+		var changed []string
+		var err error
+		if !p.DisableScan {
+			changed, err = watcher.Scan(since)
+		}
+		if len(pending) > 0 {
+			changed = mergePaths(changed, pending)
+			pending = nil
+		}
+		switch {
+		case err != nil && err.Error() != scanErr:
+			// Only print each error once rather than every scan.
+			log.errorf("Error scanning for changes: %v", err)
+			scanErr = err.Error()
+		case err == nil && scanErr != "":
+			log.infof("Scanning for changes again...")
+			scanErr = ""
+		}
+		log.debugf("Scanned for changes, found %d", len(changed))
+		for _, path := range changed {
+			log.debugf("Changed: %s", path)
+		}
+		if p.StabilityWindow > 0 {
+			changed = growing.check(clock.Now(), p.StabilityWindow, changed)
+			if n := len(growing.sizes); n > 0 {
+				log.debugf("Waiting for %d files to stop growing", n)
+			}
+		}
+		if triggered {
+			log.lifecyclef("Rebuild triggered")
+			interval = scanInt
+			paused = false
+			build(nil)
+			continue
+		}
+		if len(changed) == 0 {
+			if p.MaxScanInterval > scanInt && len(growing.sizes) == 0 {
+				interval = time.Duration(float64(interval) * backoff)
+				if interval > p.MaxScanInterval {
+					interval = p.MaxScanInterval
+				}
+			}
 			continue
 		}
-		if stop != nil {
-			fmt.Println("Stopping running app...")
-			stop()
+		interval = scanInt
+		for {
+			delay := settleDelay(cfg.rules, watcher.dirs(), changed, p.SettleDelay)
+			if delay <= 0 {
+				break
+			}
+			if !sleep(ctx, clock, delay) {
+				return
+			}
+			rescanned := clock.Now()
+			more, err := watcher.Scan(scanned)
+			if err != nil || len(more) == 0 {
+				break
+			}
+			log.debugf("Found %d more changes while waiting for files to settle", len(more))
+			scanned = rescanned
+			changed = mergePaths(changed, more)
 		}
-		fmt.Println("Building & Running app...")
-		stop, err = Run(p.Pre, p.Run, p.Post)
+		if len(quiet) > 0 && allMatch(quiet, watcher.dirs(), changed) {
+			log.debugf("Only test or soft ignored files changed, skipping the rebuild")
+			since = scanned
+			continue
+		}
+		if p.ShouldRebuild != nil && !p.ShouldRebuild(changed) {
+			log.debugf("ShouldRebuild skipped the rebuild")
+			since = scanned
+			continue
+		}
+		p.publish(Event{Type: ChangeDetected, Time: clock.Now(), ChangedFiles: changed})
+		for {
+			minInterval := minRebuildInterval(cfg.rules, watcher.dirs(), changed, p.MinRebuildInterval)
+			wait := minInterval - clock.Now().Sub(lastBuildStart)
+			if wait <= 0 {
+				break
+			}
+			if !sleep(ctx, clock, wait) {
+				return
+			}
+			// Changes made while we waited are handled by the same rebuild,
+			// which may need a longer wait or to restart the app when it
+			// otherwise wouldn't have.
+			rescanned := clock.Now()
+			more, err := watcher.Scan(scanned)
+			if err != nil || len(more) == 0 {
+				break
+			}
+			log.debugf("Found %d more changes while waiting for MinRebuildInterval", len(more))
+			scanned = rescanned
+			changed = mergePaths(changed, more)
+			p.publish(Event{Type: ChangeDetected, Time: clock.Now(), ChangedFiles: more})
+		}
+		paused = false
+		build(changed)
+	}
+}
+
+// mergePaths returns the sorted paths found in either paths or more, with
+// each one only included once, the same as Watcher.Scan.
+func mergePaths(paths, more []string) []string {
+	merged := append(append([]string(nil), paths...), more...)
+	sort.Strings(merged)
+	unique := merged[:0]
+	for i, path := range merged {
+		if i == 0 || path != merged[i-1] {
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+// stabilityTracker keeps track of the size of changed files across scans, to
+// hold each one back until its size stops changing.
+type stabilityTracker struct {
+	sizes map[string]fileSize
+}
+
+// fileSize is the size a file had when it was first seen at that size.
+type fileSize struct {
+	size int64
+	seen time.Time
+}
+
+// check returns the files from changed, or from earlier calls, whose size
+// hasn't changed for window as of now. The rest are kept for the next call.
+func (st *stabilityTracker) check(now time.Time, window time.Duration, changed []string) []string {
+	if st.sizes == nil {
+		st.sizes = make(map[string]fileSize)
+	}
+	held := make([]string, 0, len(st.sizes))
+	for path := range st.sizes {
+		held = append(held, path)
+	}
+	var stable []string
+	for _, path := range mergePaths(changed, held) {
+		info, err := os.Stat(path)
 		if err != nil {
-			fmt.Printf("Error running: %v\n", err)
-			onError(err)
+			// The file was removed, and won't get any bigger.
+			delete(st.sizes, path)
+			stable = append(stable, path)
+			continue
+		}
+		prev, ok := st.sizes[path]
+		if !ok || prev.size != info.Size() {
+			st.sizes[path] = fileSize{size: info.Size(), seen: now}
+			continue
+		}
+		if now.Sub(prev.seen) >= window {
+			delete(st.sizes, path)
+			stable = append(stable, path)
+		}
+	}
+	return stable
+}
+
+// exitReason describes the error an app exited with.
+func exitReason(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// allMatch reports whether every path in changed is matched by rules. Paths
+// are matched relative to the directory in dirs they were found in.
+func allMatch(rules []ignoreRule, dirs, changed []string) bool {
+	for _, path := range changed {
+		if !matchFile(rules, relToDirs(dirs, path)) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxSummaryFiles is the most changed files changeSummary will list by name.
+const maxSummaryFiles = 3
+
+// changeSummary describes the changed files that triggered a rebuild, such as
+// "2 files changed: main.go, handler.go - rebuilding". Each path is shown
+// relative to the directory in dirs it was found in, and only the first few
+// are listed by name.
+func changeSummary(dirs, changed []string) string {
+	var names []string
+	for i, path := range changed {
+		if i == maxSummaryFiles {
+			names = append(names, fmt.Sprintf("and %d more", len(changed)-i))
+			break
+		}
+		names = append(names, relToDirs(dirs, path))
+	}
+	noun := "files"
+	if len(changed) == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%d %s changed: %s - rebuilding", len(changed), noun, strings.Join(names, ", "))
+}
+
+// relToDirs returns path relative to the first directory in dirs that
+// contains it, or path unchanged if none do.
+func relToDirs(dirs []string, path string) string {
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+// pollConfig is a snapshot of the parts of a Poller's configuration that can
+// be changed while it is polling.
+type pollConfig struct {
+	ignore, include []string
+	pre, post       []Step
+	run             RunStep
+	rules           []buildRule
+	handlers        []buildHandler
+	// autoPort is the AutoPort that created run, if any.
+	autoPort *AutoPort
+}
+
+// config takes a snapshot of the poller's configuration, with any steps
+// replaced according to DryRun and wrapped to log their timing if Verbosity
+// is Verbose. If RunProcess is set, run stores each
+// Process it starts in proc.
+func (p *Poller) config(proc **Process) pollConfig {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	var ignore []string
+	if !p.NoDefaultIgnores {
+		ignore = append(ignore, DefaultIgnores...)
+	}
+	if !p.NoEditorTempIgnores {
+		ignore = append(ignore, EditorTempIgnores...)
+	}
+	ignore = append(ignore, p.Ignore...)
+	cfg := pollConfig{
+		ignore:   ignore,
+		include:  p.Include,
+		pre:      p.Pre,
+		run:      p.Run,
+		post:     p.Post,
+		rules:    parseRules(p.Rules),
+		handlers: parseHandlers(p.Handlers),
+	}
+	if p.RunProcess == nil && !p.DryRun {
+		cfg.autoPort = stepAutoPort(p.Run)
+	}
+	if p.RunProcess != nil {
+		start := p.RunProcess
+		cfg.run = runFunc(func(sc stepContext) (func(), error) {
+			var err error
+			*proc, err = startProcess(sc, start)
+			if err != nil {
+				return nil, err
+			}
+			return (*proc).Stop, nil
+		})
+	}
+	log := logger{verbosity: p.Verbosity, out: p.LogOutput}
+	if p.DryRun {
+		cfg.pre = dryRunSteps(log, "pre", cfg.pre)
+		cfg.run = dryRunRun(log, p.Run, p.RunProcess)
+		cfg.post = dryRunSteps(log, "post", cfg.post)
+		for i := range cfg.rules {
+			cfg.rules[i].Pre = dryRunSteps(log, fmt.Sprintf("rules[%d] pre", i), cfg.rules[i].Pre)
+		}
+		for i, h := range cfg.handlers {
+			if h.Build != nil {
+				cfg.handlers[i].Build = dryRunSteps(log, fmt.Sprintf("handlers[%d] build", i), []Step{h.Build})[0]
+			}
+		}
+	}
+	if p.Verbosity >= Verbose {
+		cfg.pre = log.timedSteps("pre", cfg.pre)
+		cfg.run = log.timedRun(cfg.run)
+		cfg.post = log.timedSteps("post", cfg.post)
+		for i := range cfg.rules {
+			cfg.rules[i].Pre = log.timedSteps(fmt.Sprintf("rules[%d] pre", i), cfg.rules[i].Pre)
+		}
+		for i, h := range cfg.handlers {
+			if h.Build != nil {
+				cfg.handlers[i].Build = log.timedSteps(fmt.Sprintf("handlers[%d] build", i), []Step{h.Build})[0]
+			}
+		}
+	}
+	return cfg
+}
+
+// SetIgnore safely replaces Ignore while the poller is polling. The new
+// patterns are used starting with the next scan.
+func (p *Poller) SetIgnore(patterns []string) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Ignore = patterns
+}
+
+// SetInclude safely replaces Include while the poller is polling. The new
+// patterns are used starting with the next scan.
+func (p *Poller) SetInclude(patterns []string) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Include = patterns
+}
+
+// SetPre safely replaces Pre while the poller is polling. The new steps are
+// used starting with the next build.
+func (p *Poller) SetPre(steps []Step) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Pre = steps
+}
+
+// SetRun safely replaces Run while the poller is polling. The new RunStep is
+// used starting with the next build, unless RunProcess is set.
+func (p *Poller) SetRun(run RunStep) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Run = run
+}
+
+// SetPost safely replaces Post while the poller is polling. The new steps are
+// used starting with the next build.
+func (p *Poller) SetPost(steps []Step) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Post = steps
+}
+
+// SetRules safely replaces Rules while the poller is polling. The new rules
+// are used starting with the next build.
+func (p *Poller) SetRules(rules []Rule) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.Rules = rules
+}
+
+// prepare is run before polling starts. It checks the poller's configuration
+// and creates WorkDir.
+func (p *Poller) prepare() error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	return p.createWorkDir()
+}
+
+// workDirEnv is the environment variable set to the absolute path of
+// WorkDir.
+const workDirEnv = "PITSTOP_WORKDIR"
+
+// createWorkDir creates WorkDir if it is set and stores its path in
+// workDirEnv. If CleanWorkDir is set, WorkDir is also checked to make sure
+// that it is safe to remove later.
+func (p *Poller) createWorkDir() error {
+	if p.WorkDir == "" {
+		return nil
+	}
+	checkClean := func() error {
+		if !p.CleanWorkDir {
+			return nil
+		}
+		if _, err := cleanTarget(".", p.WorkDir); err != nil {
+			return fmt.Errorf("error checking work dir %q: %w", p.WorkDir, err)
+		}
+		return nil
+	}
+	// The check is repeated once WorkDir exists, since symlinks in its
+	// parents can only be resolved then.
+	if err := checkClean(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.WorkDir, 0755); err != nil {
+		return fmt.Errorf("error creating work dir: %w", err)
+	}
+	if err := checkClean(); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(p.WorkDir)
+	if err != nil {
+		return fmt.Errorf("error creating work dir: %w", err)
+	}
+	return os.Setenv(workDirEnv, abs)
+}
+
+// validate checks the poller's configuration as described by Poll.
+func (p *Poller) validate() error {
+	if err := p.validateSteps(); err != nil {
+		return err
+	}
+	return checkDirs(p.watcher().dirs())
+}
+
+// validateSteps checks that the poller has something to run, and that the
+// commands its steps were created from can be found. Nothing is run with
+// DryRun, so missing commands aren't an error then.
+func (p *Poller) validateSteps() error {
+	// UseBuildMiddleware and the Set methods can replace the steps while
+	// the poller is starting.
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	if p.Run == nil && p.RunProcess == nil {
+		return errors.New("pitstop: Run or RunProcess is required")
+	}
+	if p.DryRun {
+		return nil
+	}
+	var commands []string
+	commands = append(commands, stepCommands(p.Run)...)
+	commands = append(commands, stepCommands(p.RunProcess)...)
+	for _, steps := range [][]Step{p.Pre, p.Post} {
+		for _, step := range steps {
+			commands = append(commands, stepCommands(step)...)
+		}
+	}
+	for _, rule := range p.Rules {
+		for _, step := range rule.Pre {
+			commands = append(commands, stepCommands(step)...)
 		}
-		lastBuild = time.Now()
-		time.Sleep(scanInt)
+	}
+	for _, h := range p.Handlers {
+		commands = append(commands, stepCommands(h.Build)...)
+	}
+	for _, name := range commands {
+		// Paths might not have been built yet, and references to variables
+		// aren't expanded until the step runs.
+		if strings.ContainsAny(name, `/\$`) {
+			continue
+		}
+		if _, err := exec.LookPath(name); err != nil {
+			return &CommandNotFoundError{Command: name, Err: err}
+		}
+	}
+	return nil
+}
+
+// checkDirs returns an error if any of dirs doesn't exist or isn't a
+// directory.
+func checkDirs(dirs []string) error {
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("error watching %q: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("error watching %q: not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// excludes returns the paths the watcher should never scan.
+func (p *Poller) excludes() []string {
+	if p.WorkDir == "" {
+		return p.ExcludeOutputs
+	}
+	return append(append([]string(nil), p.ExcludeOutputs...), p.WorkDir)
+}
+
+// sleep uses clock to pause for d, returning early if ctx is done. It reports
+// whether the full duration elapsed.
+func sleep(ctx context.Context, clock Clock, d time.Duration) bool {
+	select {
+	case <-clock.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// watcher returns a Watcher configured to scan every directory the poller
+// should. Dir is treated as another entry in Dirs, and if neither were provided
+// the Watcher will default to scanning ".". Ignore and Include can change
+// while polling, so they are left for poll to set from each config snapshot.
+func (p *Poller) watcher() *Watcher {
+	var dirs []string
+	dirs = append(dirs, p.Dirs...)
+	if p.Dir != "" {
+		dirs = append(dirs, p.Dir)
+	}
+	return &Watcher{
+		Dirs:             dirs,
+		MaxDepth:         p.MaxDepth,
+		TopLevelOnly:     p.TopLevelOnly,
+		RespectGitignore: p.RespectGitignore,
+		Exclude:          p.excludes(),
+		IgnoreDirs:       p.IgnoreDirs,
+		IgnoreFile:       ".pitstopignore",
+		Patterns:         p.Patterns,
+		HashCompare:      p.HashCompare,
+		FollowSymlinks:   p.FollowSymlinks,
+		MtimeGranularity: p.MtimeGranularity,
+		OnWalkError:      p.OnWalkError,
 	}
 }
@@ -1,16 +1,57 @@
 package pitstop_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/joncalhoun/pitstop"
 )
 
+// sinceNow returns the current time and then sleeps briefly. Many filesystems
+// stamp mtimes using a coarse clock that can lag slightly behind time.Now(), so
+// without the sleep a file written right after this is called could appear to
+// be older than the returned time.
+func sinceNow() time.Time {
+	now := time.Now()
+	time.Sleep(20 * time.Millisecond)
+	return now
+}
+
+// captureStdout redirects os.Stdout to a pipe until the returned func is
+// called, which restores it and returns everything that was written.
+func captureStdout(t *testing.T) func() string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("setup: creating pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		out.ReadFrom(r)
+		close(copied)
+	}()
+	return func() string {
+		os.Stdout = stdout
+		w.Close()
+		<-copied
+		return out.String()
+	}
+}
+
 func TestDidChange(t *testing.T) {
 	removeAllFn := func(dir string) func() {
 		return func() {
@@ -29,7 +70,7 @@ func TestDidChange(t *testing.T) {
 				if err != nil {
 					t.Fatalf("setup: creating temp dir: %v", err)
 				}
-				time := time.Now()
+				time := sinceNow()
 				return dir, time, removeAllFn(dir)
 			},
 			false,
@@ -44,7 +85,7 @@ func TestDidChange(t *testing.T) {
 				if err != nil {
 					t.Fatalf("setup: creating subdir: %v", err)
 				}
-				time := time.Now()
+				time := sinceNow()
 				return dir, time, removeAllFn(dir)
 			},
 			false,
@@ -55,7 +96,7 @@ func TestDidChange(t *testing.T) {
 				if err != nil {
 					t.Fatalf("setup: creating temp dir: %v", err)
 				}
-				time := time.Now()
+				time := sinceNow()
 				_, err = ioutil.TempFile(dir, "")
 				if err != nil {
 					t.Fatalf("setup: creating new file: %v", err)
@@ -75,7 +116,7 @@ func TestDidChange(t *testing.T) {
 				if err != nil {
 					t.Fatalf("setup: creating subdir: %v", err)
 				}
-				time := time.Now()
+				time := sinceNow()
 				_, err = ioutil.TempFile(subdir, "")
 				if err != nil {
 					t.Fatalf("setup: creating new file: %v", err)
@@ -99,7 +140,7 @@ func TestDidChange(t *testing.T) {
 				if err != nil {
 					t.Fatalf("setup: creating new file: %v", err)
 				}
-				time := time.Now()
+				time := sinceNow()
 				fmt.Fprintln(f, "this is a change")
 				return dir, time, removeAllFn(dir)
 			},
@@ -117,40 +158,71 @@ func TestDidChange(t *testing.T) {
 	}
 }
 
+func TestDidChangeMaxDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	subdir := filepath.Join(dir, "a", "b")
+	err = os.MkdirAll(subdir, 0700)
+	if err != nil {
+		t.Fatalf("setup: creating subdir: %v", err)
+	}
+	since := sinceNow()
+	_, err = ioutil.TempFile(subdir, "")
+	if err != nil {
+		t.Fatalf("setup: creating new file: %v", err)
+	}
+
+	for maxDepth, want := range map[int]bool{
+		-1: true,
+		0:  false,
+		1:  false,
+		2:  true,
+		3:  true,
+	} {
+		got := pitstop.DidChangeMaxDepth(dir, since, maxDepth)
+		if got != want {
+			t.Errorf("DidChangeMaxDepth(maxDepth=%d) = %v; want %v", maxDepth, got, want)
+		}
+	}
+}
+
 func TestRun(t *testing.T) {
-	buildCommand := func(cmd string, args ...string) func(*testing.T) []pitstop.BuildFunc {
-		return func(*testing.T) []pitstop.BuildFunc {
-			return []pitstop.BuildFunc{pitstop.BuildCommand(cmd, args...)}
+	buildCommand := func(cmd string, args ...string) func(*testing.T) []pitstop.Step {
+		return func(*testing.T) []pitstop.Step {
+			return []pitstop.Step{pitstop.BuildCommand(cmd, args...)}
 		}
 	}
-	runCommand := func(cmd string, args ...string) func(t *testing.T) pitstop.RunFunc {
-		return func(*testing.T) pitstop.RunFunc {
+	runCommand := func(cmd string, args ...string) func(t *testing.T) pitstop.RunStep {
+		return func(*testing.T) pitstop.RunStep {
 			return pitstop.RunCommand(cmd, args...)
 		}
 	}
-	errorOnBuild := func(msg string) func(*testing.T) []pitstop.BuildFunc {
-		return func(t *testing.T) []pitstop.BuildFunc {
-			return []pitstop.BuildFunc{
-				func() error {
+	errorOnBuild := func(msg string) func(*testing.T) []pitstop.Step {
+		return func(t *testing.T) []pitstop.Step {
+			return []pitstop.Step{
+				pitstop.BuildFunc(func() error {
 					t.Error(msg)
 					return nil
-				},
+				}),
 			}
 		}
 	}
-	errorOnRun := func(msg string) func(*testing.T) pitstop.RunFunc {
-		return func(t *testing.T) pitstop.RunFunc {
-			return func() (func(), error) {
+	errorOnRun := func(msg string) func(*testing.T) pitstop.RunStep {
+		return func(t *testing.T) pitstop.RunStep {
+			return pitstop.RunFunc(func() (func(), error) {
 				t.Error(msg)
 				return func() {}, nil
-			}
+			})
 		}
 	}
 
 	type testCase struct {
-		pre  func(*testing.T) []pitstop.BuildFunc
-		run  func(*testing.T) pitstop.RunFunc
-		post func(*testing.T) []pitstop.BuildFunc
+		pre  func(*testing.T) []pitstop.Step
+		run  func(*testing.T) pitstop.RunStep
+		post func(*testing.T) []pitstop.Step
 		err  bool
 	}
 	for name, tc := range map[string]testCase{
@@ -179,14 +251,14 @@ func TestRun(t *testing.T) {
 			err:  true,
 		},
 		"chain error": {
-			pre: func(t *testing.T) []pitstop.BuildFunc {
-				return []pitstop.BuildFunc{
+			pre: func(t *testing.T) []pitstop.Step {
+				return []pitstop.Step{
 					pitstop.BuildCommand("echo", "hi"),
 					pitstop.BuildCommand("exit", "1"),
-					func() error {
+					pitstop.BuildFunc(func() error {
 						t.Errorf("additional pre commands shouldn't be run after an error")
 						return nil
-					},
+					}),
 				}
 			},
 			err: true,
@@ -201,8 +273,8 @@ func TestRun(t *testing.T) {
 		},
 	} {
 		t.Run(name, func(t *testing.T) {
-			var pre, post []pitstop.BuildFunc
-			var run pitstop.RunFunc
+			var pre, post []pitstop.Step
+			var run pitstop.RunStep
 			if tc.pre != nil {
 				pre = tc.pre(t)
 			}
@@ -226,3 +298,2790 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestRun_nilStop(t *testing.T) {
+	run := pitstop.RunFunc(func() (func(), error) { return nil, nil })
+	fail := pitstop.BuildFunc(func() error { return errors.New("post failed") })
+	for name, post := range map[string][]pitstop.Step{
+		"success":       nil,
+		"error in post": {fail},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stop, err := pitstop.Run(nil, run, post)
+			if (err != nil) != (post != nil) {
+				t.Errorf("Run() err = %v; want an error only if post fails", err)
+			}
+			if stop == nil {
+				t.Fatalf("Run() stop = nil; want a func that is safe to call")
+			}
+			stop()
+		})
+	}
+	stop, err := pitstop.Run([]pitstop.Step{fail}, run, nil)
+	if err == nil {
+		t.Errorf("Run() with a failing pre err = nil; want an error")
+	}
+	stop()
+}
+
+func TestRunWithResult(t *testing.T) {
+	ok := pitstop.BuildFunc(func() error { return nil })
+	fail := pitstop.BuildFunc(func() error { return errors.New("failed") })
+	for name, tc := range map[string]struct {
+		pre, post []pitstop.Step
+		runErr    error
+		wantPhase string
+		wantStops int
+	}{
+		"success":    {pre: []pitstop.Step{ok}, post: []pitstop.Step{ok}},
+		"pre fails":  {pre: []pitstop.Step{ok, fail}, post: []pitstop.Step{ok}, wantPhase: "pre"},
+		"run fails":  {pre: []pitstop.Step{ok}, runErr: errors.New("failed"), wantPhase: "run"},
+		"post fails": {pre: []pitstop.Step{ok}, post: []pitstop.Step{ok, fail}, wantPhase: "post", wantStops: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var stops int
+			run := pitstop.RunFunc(func() (func(), error) {
+				if tc.runErr != nil {
+					return nil, tc.runErr
+				}
+				return func() { stops++ }, nil
+			})
+			_, result, err := pitstop.RunWithResult(tc.pre, run, tc.post)
+			if (err != nil) != (tc.wantPhase != "") {
+				t.Errorf("RunWithResult() err = %v; want an error only if a phase fails", err)
+			}
+			if result.FailedPhase != tc.wantPhase {
+				t.Errorf("FailedPhase = %q; want %q", result.FailedPhase, tc.wantPhase)
+			}
+			if stops != tc.wantStops {
+				t.Errorf("stop called %d times; want %d", stops, tc.wantStops)
+			}
+		})
+	}
+
+	t.Run("durations", func(t *testing.T) {
+		sleep := func(d time.Duration) pitstop.BuildFunc {
+			return func() error {
+				time.Sleep(d)
+				return nil
+			}
+		}
+		run := pitstop.RunFunc(func() (func(), error) {
+			time.Sleep(20 * time.Millisecond)
+			return func() {}, nil
+		})
+		_, result, err := pitstop.RunWithResult(
+			[]pitstop.Step{sleep(10 * time.Millisecond)},
+			run,
+			[]pitstop.Step{sleep(30 * time.Millisecond)},
+		)
+		if err != nil {
+			t.Fatalf("RunWithResult() err = %v; want nil", err)
+		}
+		for name, tc := range map[string]struct{ got, min time.Duration }{
+			"PreDuration":      {result.PreDuration, 10 * time.Millisecond},
+			"RunStartDuration": {result.RunStartDuration, 20 * time.Millisecond},
+			"PostDuration":     {result.PostDuration, 30 * time.Millisecond},
+		} {
+			if tc.got < tc.min {
+				t.Errorf("%s = %v; want at least %v", name, tc.got, tc.min)
+			}
+		}
+	})
+}
+
+func TestRunCommand_stopTwice(t *testing.T) {
+	output := captureStdout(t)
+	stop, err := pitstop.RunCommand("sleep", "10").Run()
+	if err != nil {
+		output()
+		t.Fatalf("RunCommand() err = %v; want nil", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		stop()
+		stop()
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stop() didn't return when called twice")
+	}
+	if out := output(); strings.Contains(out, "Error") {
+		t.Errorf("output = %q; want no errors from stopping twice", out)
+	}
+}
+
+func TestBuildCommand_error(t *testing.T) {
+	err := pitstop.BuildCommand("sh", "-c", "exit 3").Build()
+	var buildErr *pitstop.BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("BuildCommand() err = %v; want a *BuildError", err)
+	}
+	if buildErr.ExitCode != 3 {
+		t.Errorf("ExitCode = %d; want 3", buildErr.ExitCode)
+	}
+	if buildErr.Command != "sh" {
+		t.Errorf("Command = %q; want %q", buildErr.Command, "sh")
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("BuildCommand() err = %v; want it to wrap an *exec.ExitError", err)
+	}
+}
+
+func TestCommandNotFound(t *testing.T) {
+	const command = "pitstop-no-such-command"
+	want := `pitstop: command "pitstop-no-such-command" not found on PATH`
+	check := func(t *testing.T, err error) {
+		t.Helper()
+		var notFound *pitstop.CommandNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Fatalf("err = %v; want a *CommandNotFoundError", err)
+		}
+		if notFound.Command != command {
+			t.Errorf("Command = %q; want %q", notFound.Command, command)
+		}
+		if !errors.Is(err, exec.ErrNotFound) {
+			t.Errorf("err = %v; want it to wrap exec.ErrNotFound", err)
+		}
+		if err.Error() != want {
+			t.Errorf("err = %q; want %q", err.Error(), want)
+		}
+	}
+
+	t.Run("BuildCommand", func(t *testing.T) {
+		err := pitstop.BuildCommand(command, "arg").Build()
+		check(t, err)
+		var buildErr *pitstop.BuildError
+		if !errors.As(err, &buildErr) || buildErr.ExitCode != -1 {
+			t.Errorf("err = %v; want a *BuildError with an ExitCode of -1", err)
+		}
+	})
+	t.Run("RunCommand", func(t *testing.T) {
+		_, err := pitstop.RunCommand(command, "arg").Run()
+		check(t, err)
+	})
+}
+
+func TestPoller_validate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	run := pitstop.RunFunc(func() (func(), error) { return func() {}, nil })
+
+	for name, tc := range map[string]struct {
+		p      *pitstop.Poller
+		errMsg string
+	}{
+		"nil run": {
+			p:      &pitstop.Poller{Dir: dir},
+			errMsg: "pitstop: Run or RunProcess is required",
+		},
+		"missing dir": {
+			p:      &pitstop.Poller{Dir: filepath.Join(dir, "missing"), Run: run},
+			errMsg: "error watching",
+		},
+		"missing pre command": {
+			p: &pitstop.Poller{
+				Dir: dir,
+				Pre: []pitstop.Step{pitstop.BuildCommand("pitstop-no-such-command")},
+				Run: run,
+			},
+			errMsg: `pitstop: command "pitstop-no-such-command" not found on PATH`,
+		},
+		"missing chained command": {
+			p: &pitstop.Poller{
+				Dir:  dir,
+				Run:  run,
+				Post: []pitstop.Step{pitstop.Chain(pitstop.BuildCommand("go", "version"), pitstop.Retry(1, 0, pitstop.BuildCommand("pitstop-no-such-command")))},
+			},
+			errMsg: `pitstop: command "pitstop-no-such-command" not found on PATH`,
+		},
+		"missing run command": {
+			p:      &pitstop.Poller{Dir: dir, Run: pitstop.RunCommand("pitstop-no-such-command")},
+			errMsg: `pitstop: command "pitstop-no-such-command" not found on PATH`,
+		},
+		"missing rule command": {
+			p: &pitstop.Poller{
+				Dir: dir,
+				Run: run,
+				Rules: []pitstop.Rule{{
+					Match: []string{"*.css"},
+					Pre:   []pitstop.Step{pitstop.BuildCommand("pitstop-no-such-command")},
+				}},
+			},
+			errMsg: `pitstop: command "pitstop-no-such-command" not found on PATH`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := tc.p
+			err := p.Poll()
+			if err == nil {
+				t.Fatalf("Poll() err = nil; want an error")
+			}
+			if !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("Poll() err = %v; want it to contain %q", err, tc.errMsg)
+			}
+			if err := p.Start(); err == nil {
+				p.Stop()
+				t.Errorf("Start() err = nil; want an error")
+			}
+		})
+	}
+
+	// Commands given as a path may not have been built yet, and nothing is
+	// run with DryRun.
+	for _, p := range []*pitstop.Poller{
+		{Dir: dir, Run: pitstop.RunCommand(filepath.Join(dir, "tmp", "app"))},
+		{Dir: dir, Run: pitstop.RunCommand("pitstop-no-such-command"), DryRun: true, Verbosity: pitstop.Silent},
+	} {
+		output := captureStdout(t)
+		if err := p.Start(); err != nil {
+			output()
+			t.Fatalf("Start() err = %v; want nil", err)
+		}
+		p.Stop()
+		output()
+	}
+
+	p := pitstop.Poller{Dir: dir}
+	if _, err := p.Once(context.Background()); err == nil {
+		t.Errorf("Once() err = nil with a nil Run; want an error")
+	}
+}
+
+func TestBuildCommandCapture(t *testing.T) {
+	fn, buf := pitstop.BuildCommandCapture("sh", "-c", "echo out; echo err >&2; exit 1")
+	err := fn.Build()
+	var buildErr *pitstop.BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("BuildCommandCapture() err = %v; want a *BuildError", err)
+	}
+	if want := "out\nerr\n"; buf.String() != want || buildErr.Output != want {
+		t.Errorf("captured %q with Output %q; want both to be %q", buf.String(), buildErr.Output, want)
+	}
+
+	// Each run replaces the output of the last.
+	fn, buf = pitstop.BuildCommandCapture("echo", "hi")
+	for i := 0; i < 2; i++ {
+		if err := fn.Build(); err != nil {
+			t.Fatalf("BuildCommandCapture() err = %v; want nil", err)
+		}
+	}
+	if got := buf.String(); got != "hi\n" {
+		t.Errorf("captured %q; want %q", got, "hi\n")
+	}
+}
+
+func TestBuildCommandTimeout(t *testing.T) {
+	err := pitstop.BuildCommandTimeout(time.Second, "echo", "hi").Build()
+	if err != nil {
+		t.Errorf("BuildCommandTimeout() err = %v; want nil", err)
+	}
+
+	start := time.Now()
+	err = pitstop.BuildCommandTimeout(100*time.Millisecond, "sleep", "10").Build()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("BuildCommandTimeout() err = %v; want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("BuildCommandTimeout() took %v; want the command to be killed", elapsed)
+	}
+	// The grandchild holds stdout open after sh is killed, so it has to be
+	// killed as well for the build to stop.
+	start = time.Now()
+	err = pitstop.BuildCommandTimeout(100*time.Millisecond, "sh", "-c", "sleep 5; echo done").Build()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("BuildCommandTimeout() err = %v; want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("BuildCommandTimeout() took %v with a grandchild process; want it killed at the deadline", elapsed)
+	}
+}
+
+func TestBuildCommandContext(t *testing.T) {
+	err := pitstop.BuildCommandContext(context.Background(), "echo", "hi").Build()
+	if err != nil {
+		t.Errorf("BuildCommandContext() err = %v; want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err = pitstop.BuildCommandContext(ctx, "sh", "-c", "sleep 5; echo done").Build()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("BuildCommandContext() err = %v; want a canceled error", err)
+	}
+	var buildErr *pitstop.BuildError
+	if !errors.As(err, &buildErr) {
+		t.Errorf("BuildCommandContext() err = %v; want a *BuildError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("BuildCommandContext() took %v; want the command killed once ctx was canceled", elapsed)
+	}
+
+	// A failed build isn't mistaken for a canceled one.
+	err = pitstop.BuildCommandContext(context.Background(), "sh", "-c", "exit 1").Build()
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("BuildCommandContext() err = %v; want a build error", err)
+	}
+}
+
+func TestRunCommandContext(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+	started := filepath.Join(dir, "started")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// The app ignores SIGTERM, so only being killed will stop it.
+	stop, err := pitstop.RunCommandContext(ctx, "sh", "-c", "trap '' TERM; touch \"$0\"; exec sleep 10", started).Run()
+	if err != nil {
+		t.Fatalf("RunCommandContext() err = %v; want nil", err)
+	}
+	if err := pitstop.WaitForFile(started, 5*time.Second)(); err != nil {
+		t.Fatalf("app didn't start: %v", err)
+	}
+	cancel()
+	begin := time.Now()
+	stop()
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("stop() took %v; want the app killed once ctx was canceled", elapsed)
+	}
+
+	if _, err := pitstop.RunCommandContext(ctx, "sleep", "10").Run(); !errors.Is(err, context.Canceled) {
+		t.Errorf("RunCommandContext() with a canceled ctx err = %v; want a canceled error", err)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls []string
+	step := func(name string) pitstop.BuildFunc {
+		return func() error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	var stopped bool
+	run := pitstop.RunFunc(func() (func(), error) {
+		calls = append(calls, "run")
+		return func() { stopped = true }, nil
+	})
+	post := []pitstop.Step{
+		pitstop.BuildFunc(func() error {
+			calls = append(calls, "post 1")
+			cancel()
+			return nil
+		}),
+		step("post 2"),
+	}
+	_, err := pitstop.RunContext(ctx, []pitstop.Step{step("pre")}, run, post)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext() err = %v; want %v", err, context.Canceled)
+	}
+	if want := []string{"pre", "run", "post 1"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v; want %v", calls, want)
+	}
+	if !stopped {
+		t.Errorf("app wasn't stopped after ctx was canceled")
+	}
+
+	calls = nil
+	if _, err := pitstop.RunContext(ctx, []pitstop.Step{step("pre")}, run, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext() with a canceled ctx err = %v; want %v", err, context.Canceled)
+	}
+	if len(calls) > 0 {
+		t.Errorf("calls = %v; want nothing called", calls)
+	}
+}
+
+func TestPoller_cancelDuringBuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran, errored bool
+	p := pitstop.Poller{
+		Dir: dir,
+		Pre: []pitstop.Step{
+			pitstop.BuildFunc(func() error {
+				cancel()
+				return nil
+			}),
+			pitstop.BuildFunc(func() error {
+				ran = true
+				return nil
+			}),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			ran = true
+			return func() {}, nil
+		}),
+		OnError: func(error) { errored = true },
+	}
+	output := captureStdout(t)
+	p.PollContext(ctx)
+	if ran {
+		t.Errorf("steps after ctx was canceled were run")
+	}
+	if errored {
+		t.Errorf("OnError was called for a canceled build")
+	}
+	if out := output(); strings.Contains(out, "Error running") {
+		t.Errorf("output = %q; want no error for a canceled build", out)
+	}
+}
+
+func TestPoller_StartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	_, err = ioutil.TempFile(dir, "")
+	if err != nil {
+		t.Fatalf("setup: creating new file: %v", err)
+	}
+
+	started := make(chan struct{}, 1)
+	stopped := make(chan struct{}, 1)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			started <- struct{}{}
+			return func() {
+				stopped <- struct{}{}
+			}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	if err := p.Start(); err == nil {
+		t.Errorf("Start() err = nil on a running poller; want an error")
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("app was never started")
+	}
+	p.Stop()
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("Stop() returned before the app was stopped")
+	}
+	// Stopping twice should be harmless.
+	p.Stop()
+}
+
+func TestPoller_Stop_duringRetry(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	// Stopping the poller shouldn't have to wait out Retry's delay.
+	attempted := make(chan struct{}, 1)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Pre: []pitstop.Step{
+			pitstop.Retry(3, time.Hour, pitstop.BuildFunc(func() error {
+				select {
+				case attempted <- struct{}{}:
+				default:
+				}
+				return errors.New("not ready")
+			})),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	select {
+	case <-attempted:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("pre step was never run")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Stop() didn't return while Retry was waiting to try again")
+	}
+}
+
+func TestBuildCommandOut(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	err := pitstop.BuildCommandOut(&stdout, &stderr, "sh", "-c", "echo out; echo err >&2").Build()
+	if err != nil {
+		t.Fatalf("BuildCommandOut() err = %v; want nil", err)
+	}
+	if got := stdout.String(); got != "out\n" {
+		t.Errorf("stdout = %q; want %q", got, "out\n")
+	}
+	if got := stderr.String(); got != "err\n" {
+		t.Errorf("stderr = %q; want %q", got, "err\n")
+	}
+}
+
+func TestRunCommandOut(t *testing.T) {
+	var stdout syncBuffer
+	stop, err := pitstop.RunCommandOut(&stdout, nil, "echo", "running").Run()
+	if err != nil {
+		t.Fatalf("RunCommandOut() err = %v; want nil", err)
+	}
+	defer stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for stdout.String() != "running\n" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := stdout.String(); got != "running\n" {
+		t.Errorf("stdout = %q; want %q", got, "running\n")
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe to write to from a running
+// process while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPoller_Once(t *testing.T) {
+	var steps []string
+	step := func(name string, err error) pitstop.BuildFunc {
+		return func() error {
+			steps = append(steps, name)
+			return err
+		}
+	}
+	newPoller := func(postErr error) *pitstop.Poller {
+		return &pitstop.Poller{
+			Pre: []pitstop.Step{step("pre", nil)},
+			Run: pitstop.RunFunc(func() (func(), error) {
+				steps = append(steps, "run")
+				return func() { steps = append(steps, "stop") }, nil
+			}),
+			Post: []pitstop.Step{step("post", postErr)},
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		steps = nil
+		stop, err := newPoller(nil).Once(context.Background())
+		if err != nil {
+			t.Fatalf("Once() err = %v; want nil", err)
+		}
+		if want := []string{"pre", "run", "post"}; !reflect.DeepEqual(steps, want) {
+			t.Errorf("steps = %v; want %v", steps, want)
+		}
+		stop()
+		stop()
+		if want := []string{"pre", "run", "post", "stop"}; !reflect.DeepEqual(steps, want) {
+			t.Errorf("steps after stop = %v; want %v", steps, want)
+		}
+	})
+	t.Run("post fails", func(t *testing.T) {
+		steps = nil
+		stop, err := newPoller(errors.New("failed")).Once(context.Background())
+		if err == nil {
+			t.Fatalf("Once() err = nil; want an error")
+		}
+		stop()
+		if want := []string{"pre", "run", "post", "stop"}; !reflect.DeepEqual(steps, want) {
+			t.Errorf("steps = %v; want %v", steps, want)
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		steps = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stop, err := newPoller(nil).Once(ctx)
+		if err != context.Canceled {
+			t.Errorf("Once() err = %v; want %v", err, context.Canceled)
+		}
+		stop()
+		if len(steps) != 0 {
+			t.Errorf("steps = %v; want none", steps)
+		}
+	})
+}
+
+func TestPoller_NoBuildOnStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, noBuildOnStart := range map[string]bool{
+		"default": false,
+		"enabled": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			started := make(chan struct{}, 10)
+			p := pitstop.Poller{
+				Dir:            dir,
+				ScanInterval:   10 * time.Millisecond,
+				NoBuildOnStart: noBuildOnStart,
+				Run: pitstop.RunFunc(func() (func(), error) {
+					started <- struct{}{}
+					return func() {}, nil
+				}),
+			}
+			if err := p.Start(); err != nil {
+				t.Fatalf("Start() err = %v; want nil", err)
+			}
+			defer p.Stop()
+
+			select {
+			case <-started:
+				if noBuildOnStart {
+					t.Fatalf("app was started before any changes")
+				}
+				return
+			case <-time.After(200 * time.Millisecond):
+				if !noBuildOnStart {
+					t.Fatalf("app wasn't started on an empty directory")
+				}
+			}
+			_, err = ioutil.TempFile(dir, "")
+			if err != nil {
+				t.Fatalf("creating new file: %v", err)
+			}
+			select {
+			case <-started:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("app wasn't started after a change")
+			}
+		})
+	}
+}
+
+func TestPoller_defaultDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("setup: getting working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("setup: changing working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	started := make(chan struct{}, 10)
+	p := pitstop.Poller{
+		ScanInterval:   10 * time.Millisecond,
+		NoBuildOnStart: true,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			started <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	err = ioutil.WriteFile("main.go", []byte("package main"), 0600)
+	if err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("changes in \".\" weren't detected with an empty Dir")
+	}
+}
+
+// fakeClock is a pitstop.Clock that only moves forward when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	// blocked receives the duration passed to After every time it is called.
+	blocked chan time.Duration
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, blocked: make(chan time.Duration, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	c.blocked <- d
+	return ch
+}
+
+// Advance moves the clock forward by d, waking up any waiters that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var waiters []fakeWaiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// waitForBlock waits until something calls After, and returns the duration
+// it is waiting for.
+func (c *fakeClock) waitForBlock(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.blocked:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the poller to wait on the clock")
+	}
+	return 0
+}
+
+func TestPoller_StartupQuietPeriod(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:                dir,
+		ScanInterval:       time.Hour,
+		StartupQuietPeriod: time.Second,
+		Clock:              clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+
+	// A file saved during the quiet period restarts it.
+	if got := clock.waitForBlock(t); got != time.Second {
+		t.Fatalf("waiting %v before the first build; want %v", got, time.Second)
+	}
+	touchAt(t, dir, "main.go", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	if got := clock.waitForBlock(t); got != time.Second {
+		t.Fatalf("waiting %v after a change; want another %v", got, time.Second)
+	}
+	select {
+	case <-builds:
+		t.Fatalf("built before the quiet period ended")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't build once files stopped changing")
+	}
+	// The change made during the quiet period is part of the first build, so
+	// it shouldn't trigger another one.
+	clock.waitForBlock(t)
+	clock.Advance(time.Hour)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Errorf("rebuilt for a change made before the first build")
+	default:
+	}
+}
+
+func TestPoller_StabilityWindow(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"asset.bin": ""})
+	defer os.RemoveAll(dir)
+	asset := filepath.Join(dir, "asset.bin")
+
+	builds := make(chan []string, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:             dir,
+		ScanInterval:    time.Second,
+		StabilityWindow: 2 * time.Second,
+		Clock:           clock,
+		ShouldRebuild: func(changed []string) bool {
+			builds <- changed
+			return true
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+
+	write := func(data string) {
+		t.Helper()
+		f, err := os.OpenFile(asset, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatalf("setup: opening asset: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatalf("setup: writing asset: %v", err)
+		}
+		touchAt(t, dir, "asset.bin", start.Add(500*time.Millisecond))
+	}
+	// scan runs the next scan and waits for it to finish.
+	scan := func() {
+		t.Helper()
+		clock.Advance(time.Second)
+		clock.waitForBlock(t)
+	}
+
+	// The file grows over two scans, so it is held back until its size has
+	// been the same for the whole window.
+	clock.waitForBlock(t)
+	write("part one")
+	scan()
+	write("part two")
+	scan()
+	scan()
+	select {
+	case changed := <-builds:
+		t.Fatalf("rebuilt for %v while the file was still growing", changed)
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case changed := <-builds:
+		if want := []string{asset}; !reflect.DeepEqual(changed, want) {
+			t.Errorf("changed = %v; want %v", changed, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild once the file stopped growing")
+	}
+}
+
+func TestPoller_RestartDelay(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	runs := make(chan struct{}, 10)
+	clock := newFakeClock(time.Now())
+	// preTime is how long the clock is moved forward by Pre.
+	var preTime time.Duration
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Hour,
+		RestartDelay: 2 * time.Second,
+		Clock:        clock,
+		Pre: []pitstop.Step{
+			pitstop.BuildFunc(func() error {
+				clock.Advance(preTime)
+				return nil
+			}),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			runs <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	output := captureStdout(t)
+	defer output()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-runs
+	if got := clock.waitForBlock(t); got != time.Hour {
+		t.Fatalf("waiting %v after the first build, which shouldn't be delayed; want the %v scan interval", got, time.Hour)
+	}
+
+	// Time spent in Pre counts towards the delay.
+	preTime = 1500 * time.Millisecond
+	p.Trigger()
+	if got := clock.waitForBlock(t); got != 500*time.Millisecond {
+		t.Fatalf("waiting %v before restarting; want %v", got, 500*time.Millisecond)
+	}
+	select {
+	case <-runs:
+		t.Fatalf("app started before RestartDelay passed")
+	default:
+	}
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-runs:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("app didn't start once RestartDelay passed")
+	}
+	clock.waitForBlock(t)
+
+	// Stopping the poller during the delay doesn't wait for it.
+	preTime = 0
+	p.Trigger()
+	if got := clock.waitForBlock(t); got != 2*time.Second {
+		t.Fatalf("waiting %v before restarting; want %v", got, 2*time.Second)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Stop() didn't return during RestartDelay")
+	}
+	select {
+	case <-runs:
+		t.Errorf("app started after the poller was stopped")
+	default:
+	}
+}
+
+func TestPoller_MinRebuildInterval(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":    "",
+		"handler.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:                dir,
+		ScanInterval:       time.Second,
+		MinRebuildInterval: 10 * time.Second,
+		Clock:              clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	touchAt(t, dir, "main.go", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	// The change is noticed, but the poller has to wait out the rest of the
+	// cooldown before rebuilding.
+	clock.waitForBlock(t)
+	touchAt(t, dir, "handler.go", start.Add(2*time.Second))
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt before MinRebuildInterval elapsed")
+	default:
+	}
+
+	clock.Advance(9 * time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after MinRebuildInterval elapsed")
+	}
+	// Both changes should have been handled by the single rebuild.
+	clock.waitForBlock(t)
+	clock.Advance(10 * time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Errorf("rebuilt again; want changes during the cooldown to be coalesced")
+	default:
+	}
+}
+
+func TestPoller_ForceRebuildInterval(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:                  dir,
+		ScanInterval:         2 * time.Second,
+		ForceRebuildInterval: 3 * time.Second,
+		Clock:                clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	output := captureStdout(t)
+	defer output()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	// wait returns how long the poller is waiting to force a rebuild, which
+	// it does after setting up the next scan.
+	wait := func() time.Duration {
+		t.Helper()
+		clock.waitForBlock(t)
+		return clock.waitForBlock(t)
+	}
+	if got := wait(); got != 3*time.Second {
+		t.Errorf("waiting %v to force a rebuild; want 3s", got)
+	}
+
+	// A rebuild caused by a change restarts the wait.
+	touchAt(t, dir, "main.go", start.Add(500*time.Millisecond))
+	clock.Advance(2 * time.Second)
+	<-builds
+	if got := wait(); got != 3*time.Second {
+		t.Errorf("waiting %v to force a rebuild after a change; want 3s", got)
+	}
+	// A scan without changes keeps waiting on the same timer rather than
+	// starting another one.
+	clock.Advance(2 * time.Second)
+	if got := clock.waitForBlock(t); got != 2*time.Second {
+		t.Errorf("waiting %v after a scan without changes; want only the 2s scan interval", got)
+	}
+	select {
+	case got := <-clock.blocked:
+		t.Errorf("waiting %v after a scan without changes; want no new wait to force a rebuild", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt before ForceRebuildInterval elapsed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after ForceRebuildInterval without changes")
+	}
+}
+
+func TestPoller_SettleDelay(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":    "",
+		"handler.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:            dir,
+		ScanInterval:   time.Second,
+		SettleDelay:    200 * time.Millisecond,
+		NoBuildOnStart: true,
+		Clock:          clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	clock.waitForBlock(t)
+
+	touchAt(t, dir, "main.go", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	if got := clock.waitForBlock(t); got != p.SettleDelay {
+		t.Fatalf("waited %v after the change; want SettleDelay (%v)", got, p.SettleDelay)
+	}
+	// A second change before the files settle restarts the wait.
+	touchAt(t, dir, "handler.go", start.Add(1100*time.Millisecond))
+	clock.Advance(p.SettleDelay)
+	if got := clock.waitForBlock(t); got != p.SettleDelay {
+		t.Fatalf("waited %v after the second change; want SettleDelay (%v)", got, p.SettleDelay)
+	}
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt before the files settled")
+	default:
+	}
+
+	clock.Advance(p.SettleDelay)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild once the files settled")
+	}
+	e := <-events
+	want := []string{filepath.Join(dir, "handler.go"), filepath.Join(dir, "main.go")}
+	if e.Type != pitstop.ChangeDetected || !reflect.DeepEqual(e.ChangedFiles, want) {
+		t.Errorf("event = %+v; want a %v event with %v", e, pitstop.ChangeDetected, want)
+	}
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Errorf("rebuilt again; want one rebuild for both changes")
+	default:
+	}
+}
+
+func TestPoller_SettleDelay_atomicRename(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:            dir,
+		ScanInterval:   time.Second,
+		SettleDelay:    200 * time.Millisecond,
+		NoBuildOnStart: true,
+		Clock:          clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	clock.waitForBlock(t)
+
+	// Editors often save by writing a temp file and renaming it over the
+	// original, which replaces the file rather than writing to it. Each save
+	// should still cause exactly one rebuild that reports the original file.
+	target := filepath.Join(dir, "main.go")
+	for i := 1; i <= 2; i++ {
+		tmp := target + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("package main // %d", i)), 0644); err != nil {
+			t.Fatalf("setup: writing temp file: %v", err)
+		}
+		touchAt(t, dir, "main.go.tmp", clock.Now().Add(500*time.Millisecond))
+		if err := os.Rename(tmp, target); err != nil {
+			t.Fatalf("setup: renaming temp file: %v", err)
+		}
+		clock.Advance(time.Second)
+		if got := clock.waitForBlock(t); got != p.SettleDelay {
+			t.Fatalf("save %d: waited %v after the change; want SettleDelay (%v)", i, got, p.SettleDelay)
+		}
+		clock.Advance(p.SettleDelay)
+		select {
+		case <-builds:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("save %d: didn't rebuild once the files settled", i)
+		}
+		e := <-events
+		want := []string{target}
+		if e.Type != pitstop.ChangeDetected || !reflect.DeepEqual(e.ChangedFiles, want) {
+			t.Errorf("save %d: event = %+v; want a %v event with %v", i, e, pitstop.ChangeDetected, want)
+		}
+		for e.Type != pitstop.BuildFinished {
+			e = <-events
+		}
+		clock.waitForBlock(t)
+		select {
+		case <-builds:
+			t.Errorf("save %d: rebuilt again; want one rebuild per save", i)
+		default:
+		}
+	}
+}
+
+func TestPoller_RunningPID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, tc := range map[string]struct {
+		command []string
+		wantPID bool
+	}{
+		"keeps running": {[]string{"sleep", "10"}, true},
+		"exits":         {[]string{"true"}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := pitstop.Poller{
+				Dir:          dir,
+				ScanInterval: 10 * time.Millisecond,
+				RunProcess:   pitstop.ProcessCommand(pitstop.RunOptions{}, tc.command[0], tc.command[1:]...),
+			}
+			if p.Running() {
+				t.Errorf("Running() = true before Start; want false")
+			}
+			events, cancel := p.Events()
+			defer cancel()
+			if err := p.Start(); err != nil {
+				t.Fatalf("Start() err = %v; want nil", err)
+			}
+			defer p.Stop()
+			for e := range events {
+				if e.Type == pitstop.BuildFinished {
+					break
+				}
+			}
+			if !tc.wantPID {
+				// Give the app a moment to exit on its own.
+				deadline := time.Now().Add(2 * time.Second)
+				for p.Running() && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			if got := p.Running(); got != tc.wantPID {
+				t.Errorf("Running() = %v; want %v", got, tc.wantPID)
+			}
+			pid, ok := p.PID()
+			if ok != tc.wantPID || (ok && pid <= 0) {
+				t.Errorf("PID() = %d, %v; want a PID: %v", pid, ok, tc.wantPID)
+			}
+			p.Stop()
+			if p.Running() {
+				t.Errorf("Running() = true after Stop; want false")
+			}
+			if _, ok := p.PID(); ok {
+				t.Errorf("PID() ok = true after Stop; want false")
+			}
+		})
+	}
+}
+
+func TestPoller_ClearScreen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// captureStdout redirects stdout to a pipe, which isn't a terminal.
+	output := captureStdout(t)
+	started := make(chan struct{}, 1)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		ClearScreen:  true,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			started <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("app was never started")
+	}
+	p.Stop()
+	out := output()
+	if !strings.Contains(out, "Building & Running app...") {
+		t.Errorf("output = %q; want it to contain the build message", out)
+	}
+	if strings.Contains(out, "\033[") {
+		t.Errorf("output = %q; want no escape codes when stdout isn't a terminal", out)
+	}
+}
+
+func TestPoller_changeSummary(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":            "",
+		"b.go":            "",
+		"c.go":            "",
+		"templates/d.txt": "",
+		"templates/e.txt": "",
+	})
+	defer os.RemoveAll(dir)
+
+	output := captureStdout(t)
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	<-builds
+	clock.waitForBlock(t)
+	for _, name := range []string{"a.go", "templates/d.txt"} {
+		touchAt(t, dir, name, start.Add(500*time.Millisecond))
+	}
+	clock.Advance(time.Second)
+	<-builds
+	clock.waitForBlock(t)
+	for _, name := range []string{"a.go", "b.go", "c.go", "templates/d.txt", "templates/e.txt"} {
+		touchAt(t, dir, name, start.Add(1500*time.Millisecond))
+	}
+	clock.Advance(time.Second)
+	<-builds
+	p.Stop()
+
+	out := output()
+	for _, want := range []string{
+		"2 files changed: a.go, templates/d.txt - rebuilding\n",
+		"5 files changed: a.go, b.go, c.go, and 2 more - rebuilding\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output = %q; want it to contain %q", out, want)
+		}
+	}
+}
+
+func TestPoller_IgnoreTestFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":      "",
+		"main_test.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:             dir,
+		ScanInterval:    time.Second,
+		IgnoreTestFiles: true,
+		Clock:           clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	touchAt(t, dir, "main_test.go", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt after only a test file changed")
+	default:
+	}
+
+	touchAt(t, dir, "main.go", start.Add(1500*time.Millisecond))
+	clock.Advance(time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after a non-test file changed")
+	}
+}
+
+func TestPoller_SoftIgnore(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":   "",
+		".last_run": "",
+	})
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		SoftIgnore:   []string{".last_run"},
+		Clock:        clock,
+		Run:          pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	clock.waitForBlock(t)
+
+	touchAt(t, dir, ".last_run", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	if got := p.Stats().Builds; got != 1 {
+		t.Fatalf("Stats().Builds = %d after only a soft ignored file changed; want 1", got)
+	}
+
+	touchAt(t, dir, ".last_run", start.Add(1500*time.Millisecond))
+	touchAt(t, dir, "main.go", start.Add(1500*time.Millisecond))
+	clock.Advance(time.Second)
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type != pitstop.ChangeDetected {
+				continue
+			}
+			want := []string{filepath.Join(dir, ".last_run"), filepath.Join(dir, "main.go")}
+			if !reflect.DeepEqual(e.ChangedFiles, want) {
+				t.Errorf("ChangedFiles = %v; want %v", e.ChangedFiles, want)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("didn't rebuild after another file changed along with a soft ignored one")
+		}
+	}
+}
+
+func TestPoller_missingDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	for name, path := range map[string]string{
+		"missing":  filepath.Join(dir, "missing"),
+		"file":     filepath.Join(dir, "main.go"),
+		"in dirs":  "",
+		"existing": dir,
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := pitstop.Poller{
+				Dir: path,
+				Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+			}
+			if name == "in dirs" {
+				p.Dirs = []string{dir, filepath.Join(dir, "missing")}
+			}
+			err := p.Start()
+			defer p.Stop()
+			if got, want := err != nil, name != "existing"; got != want {
+				t.Errorf("Start() err = %v; want an error: %v", err, want)
+			}
+		})
+	}
+}
+
+func TestPoller_dirDeleted(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	output := captureStdout(t)
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	<-builds
+	clock.waitForBlock(t)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("removing dir: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		clock.waitForBlock(t)
+	}
+	p.Stop()
+
+	out := output()
+	if got := strings.Count(out, "Error scanning for changes"); got != 1 {
+		t.Errorf("output = %q; want the scan error printed once, got %d", out, got)
+	}
+}
+
+func TestPoller_setters(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan string, 100)
+	build := func(name string) []pitstop.Step {
+		return []pitstop.Step{pitstop.BuildFunc(func() error {
+			builds <- name
+			return nil
+		})}
+	}
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Millisecond,
+		Pre:          build("old"),
+		Run:          pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	if got := <-builds; got != "old" {
+		t.Fatalf("first build used %q pre; want old", got)
+	}
+
+	// Reconfigure the poller while it is scanning. Running this test with
+	// -race will catch any unsynchronized access.
+	for i := 0; i < 50; i++ {
+		p.SetIgnore([]string{fmt.Sprintf("tmp%d/", i)})
+		p.SetInclude(nil)
+		time.Sleep(time.Millisecond)
+	}
+	p.SetPre(build("new"))
+	p.SetIgnore([]string{"*.go"})
+	touch(t, dir, "main.go")
+	select {
+	case got := <-builds:
+		t.Fatalf("rebuilt using %q pre after the changed file was ignored", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.SetIgnore(nil)
+	select {
+	case got := <-builds:
+		if got != "new" {
+			t.Errorf("rebuild used %q pre; want new", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after the ignore patterns were removed")
+	}
+}
+
+func TestPoller_MaxScanInterval(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:             dir,
+		ScanInterval:    time.Second,
+		MaxScanInterval: 4 * time.Second,
+		Clock:           clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+
+	var elapsed time.Duration
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		got := clock.waitForBlock(t)
+		if got != want {
+			t.Fatalf("idle scan interval = %v; want %v", got, want)
+		}
+		elapsed += got
+		if i == 3 {
+			touchAt(t, dir, "main.go", start.Add(elapsed-time.Second))
+		}
+		clock.Advance(got)
+	}
+	<-builds
+	if got := clock.waitForBlock(t); got != time.Second {
+		t.Errorf("scan interval after a change = %v; want %v", got, time.Second)
+	}
+}
+
+func TestPoller_Verbosity(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	for name, tc := range map[pitstop.Verbosity]struct {
+		want, notWant []string
+	}{
+		pitstop.Silent: {
+			want:    []string{"Error running: failed"},
+			notWant: []string{"Building & Running app...", "Scanned for changes"},
+		},
+		pitstop.Normal: {
+			want:    []string{"Building & Running app...", "Error running: failed"},
+			notWant: []string{"Scanned for changes", "Finished pre step"},
+		},
+		pitstop.Verbose: {
+			want: []string{
+				"Building & Running app...",
+				"Finished pre step 1 of 1 in ",
+				"Scanned for changes, found 0",
+			},
+		},
+	} {
+		t.Run(fmt.Sprint(name), func(t *testing.T) {
+			output := captureStdout(t)
+			clock := newFakeClock(time.Now())
+			p := pitstop.Poller{
+				Dir:          dir,
+				ScanInterval: time.Second,
+				Verbosity:    name,
+				Clock:        clock,
+				Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+					return errors.New("failed")
+				})},
+				Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+			}
+			if err := p.Start(); err != nil {
+				t.Fatalf("Start() err = %v; want nil", err)
+			}
+			clock.waitForBlock(t)
+			clock.Advance(time.Second)
+			clock.waitForBlock(t)
+			p.Stop()
+			out := output()
+			for _, want := range tc.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output = %q; want it to contain %q", out, want)
+				}
+			}
+			for _, notWant := range tc.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("output = %q; want it not to contain %q", out, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestPoller_NoLifecycleLogs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	output := captureStdout(t)
+	clock := newFakeClock(time.Now())
+	var builds int
+	p := pitstop.Poller{
+		Dir:             dir,
+		ScanInterval:    time.Second,
+		NoLifecycleLogs: true,
+		Clock:           clock,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			builds++
+			if builds > 1 {
+				return errors.New("failed")
+			}
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	if err := p.Start(); err != nil {
+		output()
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	clock.waitForBlock(t)
+	p.Trigger()
+	clock.waitForBlock(t)
+	p.Stop()
+	out := output()
+	if want := "Error running: failed"; !strings.Contains(out, want) {
+		t.Errorf("output = %q; want it to contain %q", out, want)
+	}
+	for _, notWant := range []string{"Building & Running app...", "Stopping running app...", "Rebuild triggered"} {
+		if strings.Contains(out, notWant) {
+			t.Errorf("output = %q; want it not to contain %q", out, notWant)
+		}
+	}
+}
+
+func TestPoller_Silent(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	// Without xdg-open and friends on PATH, OpenBrowser logs a warning.
+	t.Setenv("PATH", tmp)
+
+	for i, tc := range []struct {
+		verbosity pitstop.Verbosity
+		dryRun    bool
+		want      []string
+	}{
+		{pitstop.Normal, false, []string{"App didn't stop after", "Warning: unable to open browser"}},
+		{pitstop.Normal, true, []string{"Dry run: skipping pre step 1 of 1", "Dry run: /bin/sh -c"}},
+		{pitstop.Silent, false, nil},
+		{pitstop.Silent, true, nil},
+	} {
+		trapped := filepath.Join(tmp, fmt.Sprintf("trapped%d", i))
+		output := captureStdout(t)
+		clock := newFakeClock(time.Now())
+		p := pitstop.Poller{
+			Dir:          dir,
+			ScanInterval: time.Second,
+			Verbosity:    tc.verbosity,
+			DryRun:       tc.dryRun,
+			Clock:        clock,
+			Pre:          []pitstop.Step{pitstop.DryRunBuild("migrations")},
+			// The app ignores SIGTERM, so stopping it has to kill it.
+			Run: pitstop.RunCommandWith(pitstop.RunOptions{
+				ProcessGroup: true,
+				StopTimeout:  50 * time.Millisecond,
+			}, "/bin/sh", "-c", "trap '' TERM; : > \"$0\"; while :; do :; done", trapped),
+			Post: []pitstop.Step{
+				pitstop.WaitForFile(trapped, 5*time.Second),
+				pitstop.OpenBrowser("http://localhost:3000"),
+			},
+		}
+		if err := p.Start(); err != nil {
+			output()
+			t.Fatalf("Start() err = %v; want nil", err)
+		}
+		clock.waitForBlock(t)
+		p.Stop()
+		out := output()
+		if len(tc.want) == 0 && out != "" {
+			t.Errorf("%v output = %q; want nothing", tc.verbosity, out)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%v output (DryRun = %t) = %q; want it to contain %q", tc.verbosity, tc.dryRun, out, want)
+			}
+		}
+	}
+}
+
+func TestPoller_ShouldRebuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":   "",
+		"README.md": "",
+	})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	var asked [][]string
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		ShouldRebuild: func(changed []string) bool {
+			asked = append(asked, changed)
+			return filepath.Ext(changed[0]) == ".go"
+		},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	touchAt(t, dir, "README.md", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	// The skipped change shouldn't be reported again on the next scan.
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt after ShouldRebuild returned false")
+	default:
+	}
+
+	touchAt(t, dir, "main.go", start.Add(2500*time.Millisecond))
+	clock.Advance(time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after ShouldRebuild returned true")
+	}
+	want := [][]string{
+		{filepath.Join(dir, "README.md")},
+		{filepath.Join(dir, "main.go")},
+	}
+	if !reflect.DeepEqual(asked, want) {
+		t.Errorf("ShouldRebuild called with %v; want %v", asked, want)
+	}
+}
+
+func TestPoller_Trigger(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	release := make(chan struct{})
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Hour,
+		Clock:        clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			<-release
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	release <- struct{}{}
+	clock.waitForBlock(t)
+
+	p.Trigger()
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after Trigger")
+	}
+	// Triggers made while a rebuild is pending or in progress should be
+	// combined into a single rebuild.
+	for i := 0; i < 3; i++ {
+		p.Trigger()
+	}
+	release <- struct{}{}
+	<-builds
+	release <- struct{}{}
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Errorf("rebuilt more than once after several rapid triggers")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPoller_Restart(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	var fail bool
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Hour,
+		Clock:        clock,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			record("pre")
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			record("run")
+			return func() { record("stop") }, nil
+		}),
+		Post: []pitstop.Step{pitstop.BuildFunc(func() error { record("post"); return nil })},
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	clock.waitForBlock(t)
+	p.Restart()
+	clock.waitForBlock(t)
+	// Without a running app, a restart rebuilds instead.
+	fail = true
+	p.Trigger()
+	clock.waitForBlock(t)
+	fail = false
+	p.Restart()
+	clock.waitForBlock(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"pre", "run", "post",
+		"stop", "run",
+		"stop", "pre",
+		"pre", "run", "post",
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v; want %v", steps, want)
+	}
+}
+
+func TestPoller_KeepLastGoodOnFailure(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	var builds, runs int
+	clock := newFakeClock(time.Now())
+	p := &pitstop.Poller{
+		Dir:                   dir,
+		ScanInterval:          time.Hour,
+		Clock:                 clock,
+		KeepLastGoodOnFailure: true,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			builds++
+			record("pre")
+			if builds == 2 {
+				return errors.New("build failed")
+			}
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			runs++
+			name := fmt.Sprintf("app %d", runs)
+			record("start " + name)
+			return func() { record("stop " + name) }, nil
+		}),
+		Post: []pitstop.Step{pitstop.BuildFunc(func() error {
+			record("post")
+			if builds == 3 {
+				return errors.New("unhealthy")
+			}
+			return nil
+		})},
+	}
+	output := captureStdout(t)
+	if err := p.Start(); err != nil {
+		output()
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	clock.waitForBlock(t)
+	for i := 0; i < 3; i++ {
+		p.Trigger()
+		clock.waitForBlock(t)
+		if !p.Running() {
+			t.Errorf("build %d: Running() = false; want the app running", builds)
+		}
+	}
+	p.Stop()
+	got := output()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"pre", "start app 1", "post",
+		// A failed Pre leaves app 1 running.
+		"pre",
+		// So does a failed Post, once the new app is stopped.
+		"pre", "start app 2", "post", "stop app 2",
+		// app 1 is only stopped once its replacement is running.
+		"pre", "start app 3", "post", "stop app 1",
+		"stop app 3",
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v; want %v", steps, want)
+	}
+	if !strings.Contains(got, "Keeping the previous app running...") {
+		t.Errorf("output = %q; want it to say the previous app was kept", got)
+	}
+}
+
+func TestPoller_crashLoop(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	output := captureStdout(t)
+	starts := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	crash := pitstop.ProcessCommand(pitstop.RunOptions{}, "sh", "-c", "exit 1")
+	p := pitstop.Poller{
+		Dir:                dir,
+		ScanInterval:       time.Second,
+		Clock:              clock,
+		RestartOnExit:      true,
+		CrashLoopThreshold: 3,
+		RunProcess: pitstop.ProcessFunc(func() (*pitstop.Process, error) {
+			starts <- struct{}{}
+			return crash.Start()
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-starts:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("app was only started %d times; want it restarted after exiting", i)
+		}
+	}
+	select {
+	case <-starts:
+		t.Fatalf("app was restarted after a crash loop was detected")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// A file change should resume restarts.
+	clock.waitForBlock(t)
+	touchAt(t, dir, "main.go", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	select {
+	case <-starts:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("app wasn't started after a file changed")
+	}
+	p.Stop()
+
+	out := output()
+	if want := "App exited 3 times in 10s; pausing restarts until the next file change."; !strings.Contains(out, want) {
+		t.Errorf("output = %q; want it to contain %q", out, want)
+	}
+}
+
+func TestPoller_Stats(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := newFakeClock(start)
+	events := make(chan error, 10)
+	durations := []time.Duration{time.Second, 3 * time.Second, 2 * time.Second}
+	var builds int
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Hour,
+		Clock:        clock,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			clock.Advance(durations[builds])
+			builds++
+			if builds == 2 {
+				return errors.New("failed")
+			}
+			return nil
+		})},
+		Run:        pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+		OnBuildEnd: func(err error) { events <- err },
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-events
+	for i := 0; i < 2; i++ {
+		clock.waitForBlock(t)
+		p.Trigger()
+		<-events
+	}
+	clock.waitForBlock(t)
+
+	got := p.Stats()
+	want := pitstop.Stats{
+		Builds:       3,
+		Failures:     1,
+		LastDuration: 2 * time.Second,
+		AvgDuration:  2 * time.Second,
+	}
+	if got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+}
+
+func TestPoller_DefaultIgnores(t *testing.T) {
+	for name, noDefaults := range map[string]bool{
+		"default":          false,
+		"NoDefaultIgnores": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"main.go":        "",
+				".git/index":     "",
+				"sub/.git/index": "",
+			})
+			defer os.RemoveAll(dir)
+
+			builds := make(chan struct{}, 10)
+			start := time.Now()
+			clock := newFakeClock(start)
+			p := pitstop.Poller{
+				Dir:              dir,
+				ScanInterval:     time.Second,
+				NoDefaultIgnores: noDefaults,
+				Clock:            clock,
+				Run: pitstop.RunFunc(func() (func(), error) {
+					builds <- struct{}{}
+					return func() {}, nil
+				}),
+			}
+			if err := p.Start(); err != nil {
+				t.Fatalf("Start() err = %v; want nil", err)
+			}
+			defer p.Stop()
+			<-builds
+			clock.waitForBlock(t)
+
+			touchAt(t, dir, ".git/index", start.Add(500*time.Millisecond))
+			touchAt(t, dir, "sub/.git/index", start.Add(500*time.Millisecond))
+			clock.Advance(time.Second)
+			clock.waitForBlock(t)
+			select {
+			case <-builds:
+				if !noDefaults {
+					t.Errorf("rebuilt after a change inside .git")
+				}
+			default:
+				if noDefaults {
+					t.Errorf("didn't rebuild after a change inside .git with NoDefaultIgnores")
+				}
+			}
+		})
+	}
+}
+
+func TestPoller_EditorTempIgnores(t *testing.T) {
+	for name, noIgnores := range map[string]bool{
+		"default":             false,
+		"NoEditorTempIgnores": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"main.go": "package main\n"})
+			defer os.RemoveAll(dir)
+			write := func(name string, at time.Time) {
+				t.Helper()
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0600); err != nil {
+					t.Fatalf("setup: writing %s: %v", name, err)
+				}
+				touchAt(t, dir, name, at)
+			}
+
+			builds := make(chan struct{}, 10)
+			start := time.Now()
+			clock := newFakeClock(start)
+			p := pitstop.Poller{
+				Dir:                 dir,
+				ScanInterval:        time.Second,
+				NoBuildOnStart:      true,
+				NoEditorTempIgnores: noIgnores,
+				Clock:               clock,
+				Run: pitstop.RunFunc(func() (func(), error) {
+					builds <- struct{}{}
+					return func() {}, nil
+				}),
+			}
+			events, cancel := p.Events()
+			defer cancel()
+			if err := p.Start(); err != nil {
+				t.Fatalf("Start() err = %v; want nil", err)
+			}
+			defer p.Stop()
+			clock.waitForBlock(t)
+
+			// Opening main.go in vim creates a swap file, and saving it first
+			// checks the directory is writable with a file named 4913.
+			write(".main.go.swp", start.Add(500*time.Millisecond))
+			write("4913", start.Add(500*time.Millisecond))
+			clock.Advance(time.Second)
+			clock.waitForBlock(t)
+			select {
+			case <-builds:
+				if !noIgnores {
+					t.Fatalf("rebuilt after vim created its temp files")
+				}
+				return
+			default:
+				if noIgnores {
+					t.Fatalf("didn't rebuild after vim created its temp files with NoEditorTempIgnores")
+				}
+			}
+
+			// Vim then saves by renaming main.go to a backup, writing the new
+			// file in its place, and removing the backup.
+			if err := os.Remove(filepath.Join(dir, "4913")); err != nil {
+				t.Fatalf("setup: removing 4913: %v", err)
+			}
+			if err := os.Rename(filepath.Join(dir, "main.go"), filepath.Join(dir, "main.go~")); err != nil {
+				t.Fatalf("setup: renaming main.go: %v", err)
+			}
+			touchAt(t, dir, "main.go~", start.Add(1500*time.Millisecond))
+			write("main.go", start.Add(1500*time.Millisecond))
+			touchAt(t, dir, ".main.go.swp", start.Add(1500*time.Millisecond))
+			clock.Advance(time.Second)
+			select {
+			case <-builds:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("didn't rebuild after main.go was saved")
+			}
+			if err := os.Remove(filepath.Join(dir, "main.go~")); err != nil {
+				t.Fatalf("setup: removing main.go~: %v", err)
+			}
+			e := <-events
+			want := []string{filepath.Join(dir, "main.go")}
+			if e.Type != pitstop.ChangeDetected || !reflect.DeepEqual(e.ChangedFiles, want) {
+				t.Errorf("event = %+v; want a %v event with %v", e, pitstop.ChangeDetected, want)
+			}
+		})
+	}
+}
+
+func TestPoller_changeDuringBuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := newFakeClock(start)
+	builds := make(chan struct{}, 10)
+	var count int
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			count++
+			if count == 1 {
+				// The file is saved halfway through the first build.
+				touchAt(t, dir, "main.go", clock.Now().Add(500*time.Millisecond))
+				clock.Advance(time.Second)
+			}
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+	default:
+		t.Fatalf("didn't rebuild after a change made during the build")
+	}
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Errorf("rebuilt again without any new changes")
+	default:
+	}
+}
+
+func TestPoller_PauseDuringBuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":      "",
+		"generated.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := newFakeClock(start)
+	builds := make(chan struct{}, 10)
+	p := pitstop.Poller{
+		Dir:              dir,
+		ScanInterval:     time.Second,
+		PauseDuringBuild: true,
+		Clock:            clock,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			// Simulate a generator that rewrites a file partway through a
+			// build that takes a second.
+			touchAt(t, dir, "generated.go", clock.Now().Add(500*time.Millisecond))
+			clock.Advance(time.Second)
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt after a change made by the build")
+	default:
+	}
+
+	// An edit made once the build finished still triggers a rebuild.
+	touchAt(t, dir, "main.go", clock.Now().Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+	default:
+		t.Errorf("didn't rebuild after a change made after the build")
+	}
+}
+
+func TestPoller_PauseDuringBuild_editDuringBuild(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":      "package main",
+		"generated.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := newFakeClock(start)
+	builds := make(chan struct{}, 10)
+	var n int
+	p := pitstop.Poller{
+		Dir:              dir,
+		ScanInterval:     time.Second,
+		PauseDuringBuild: true,
+		Clock:            clock,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			n++
+			// Every build rewrites generated.go, and main.go is saved while
+			// the first one is running.
+			touchAt(t, dir, "generated.go", clock.Now().Add(500*time.Millisecond))
+			if n == 1 {
+				path := filepath.Join(dir, "main.go")
+				if err := ioutil.WriteFile(path, []byte("package main\n\nfunc main() {}"), 0644); err != nil {
+					t.Fatalf("writing main.go: %v", err)
+				}
+				touchAt(t, dir, "main.go", clock.Now().Add(500*time.Millisecond))
+			}
+			clock.Advance(time.Second)
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	// The edit made during the first build causes exactly one more.
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+	default:
+		t.Fatalf("didn't rebuild after a change made during the build")
+	}
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	select {
+	case <-builds:
+		t.Errorf("rebuilt again after only the build's own changes")
+	default:
+	}
+	if n != 2 {
+		t.Errorf("built %d times; want 2", n)
+	}
+}
+
+func TestPoller_Dirs(t *testing.T) {
+	api := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(api)
+	web := writeFiles(t, map[string]string{"index.html": ""})
+	defer os.RemoveAll(web)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          api,
+		Dirs:         []string{web},
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	touchAt(t, api, "main.go", start.Add(500*time.Millisecond))
+	touchAt(t, web, "index.html", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	if got := len(builds); got != 1 {
+		t.Errorf("rebuilt %d times after changes in both dirs; want 1", got)
+	}
+
+	touchAt(t, web, "index.html", start.Add(2500*time.Millisecond))
+	clock.Advance(time.Second)
+	clock.waitForBlock(t)
+	if got := len(builds); got != 2 {
+		t.Errorf("rebuilt %d times in total; want 2 after a change in only Dirs", got)
+	}
+}
+
+func TestPoller_OnBuildStartEnd(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	ended := make(chan struct{}, 10)
+	var builds int
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		OnBuildStart: func() { record("start") },
+		OnBuildEnd: func(err error) {
+			record(fmt.Sprintf("end(%v)", err))
+			ended <- struct{}{}
+		},
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			record("pre")
+			builds++
+			if builds == 2 {
+				return errors.New("failed")
+			}
+			return nil
+		})},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			record("run")
+			return func() {}, nil
+		}),
+		Post: []pitstop.Step{pitstop.BuildFunc(func() error {
+			record("post")
+			return nil
+		})},
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-ended
+	clock.waitForBlock(t)
+	p.Trigger()
+	<-ended
+	clock.waitForBlock(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"start", "pre", "run", "post", "end(<nil>)",
+		"start", "pre", "end(failed)",
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v; want %v", steps, want)
+	}
+}
+
+func TestPoller_OnShutdown(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			record("run")
+			return func() { record("stop") }, nil
+		}),
+		Post:       []pitstop.Step{pitstop.BuildFunc(func() error { record("post"); return nil })},
+		OnShutdown: func() { record("shutdown") },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.PollContext(ctx)
+	}()
+	clock.waitForBlock(t)
+	p.Trigger()
+	clock.waitForBlock(t)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"run", "post", "stop", "run", "post", "stop", "shutdown"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v; want %v", steps, want)
+	}
+}
+
+func TestPoller_RepeatedErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	errMsg := "same"
+	var onErrors int
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:                 dir,
+		ScanInterval:        time.Hour,
+		Clock:               clock,
+		NoLifecycleLogs:     true,
+		RepeatedErrorWindow: time.Minute,
+		RepeatedErrorLimit:  3,
+		Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			return errors.New(errMsg)
+		})},
+		Run:     pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+		OnError: func(error) { onErrors++ },
+	}
+	build := func(msg string) {
+		mu.Lock()
+		errMsg = msg
+		mu.Unlock()
+		p.Trigger()
+		clock.waitForBlock(t)
+	}
+	output := captureStdout(t)
+	if err := p.Start(); err != nil {
+		output()
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	clock.waitForBlock(t)
+	for i := 0; i < 4; i++ {
+		build("same")
+	}
+	build("different")
+	build("different")
+	clock.Advance(time.Minute)
+	build("different")
+	build("different")
+	p.Stop()
+	got := strings.Split(strings.TrimSpace(output()), "\n")
+
+	want := []string{
+		"Error running: same",
+		// Reached RepeatedErrorLimit.
+		"(same error, suppressed 3 times)",
+		// A different error prints right away.
+		"(same error, suppressed 1 time)",
+		"Error running: different",
+		// RepeatedErrorWindow passed.
+		"(same error, suppressed 2 times)",
+		// Printed when the poller stops.
+		"(same error, suppressed 1 time)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+	if onErrors != 9 {
+		t.Errorf("OnError called %d times; want 9", onErrors)
+	}
+}
+
+func TestPoller_WorkDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("setup: getting working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("setup: changing working dir: %v", err)
+	}
+	defer os.Chdir(wd)
+	defer os.Unsetenv("PITSTOP_WORKDIR")
+
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		ScanInterval: time.Hour,
+		Clock:        clock,
+		WorkDir:      "tmp/build",
+		CleanWorkDir: true,
+		Pre: []pitstop.Step{
+			pitstop.BuildCommand("sh", "-c", `echo built > "$PITSTOP_WORKDIR/app"`),
+		},
+		Run: pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	clock.waitForBlock(t)
+	if _, err := os.Stat(filepath.Join(dir, "tmp", "build", "app")); err != nil {
+		t.Errorf("build didn't write to the work dir: %v", err)
+	}
+	// The build's output isn't a change.
+	clock.Advance(time.Hour)
+	clock.waitForBlock(t)
+	if got := p.Stats().Builds; got != 1 {
+		t.Errorf("Stats().Builds = %d; want 1", got)
+	}
+	p.Stop()
+	if _, err := os.Stat(filepath.Join(dir, "tmp", "build")); !os.IsNotExist(err) {
+		t.Errorf("work dir still exists after Stop; want it removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tmp")); err != nil {
+		t.Errorf("parent of the work dir was removed: %v", err)
+	}
+
+	outside := pitstop.Poller{
+		WorkDir:      filepath.Join("..", filepath.Base(dir)+"-outside"),
+		CleanWorkDir: true,
+		Run:          pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+	}
+	if err := outside.Start(); err == nil {
+		outside.Stop()
+		t.Errorf("Start() with a work dir outside the current directory err = nil; want an error")
+	}
+	if _, err := os.Stat(outside.WorkDir); !os.IsNotExist(err) {
+		os.RemoveAll(outside.WorkDir)
+		t.Errorf("work dir outside the current directory was created")
+	}
+}
+
+func TestPoller_nilBuildHooks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Pre:          []pitstop.Step{pitstop.BuildFunc(func() error { return errors.New("failed") })},
+		Run:          pitstop.RunFunc(func() (func(), error) { return func() {}, nil }),
+		// OnBuildStart, OnBuildEnd, and OnError are all nil.
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	clock.waitForBlock(t)
+	if got := p.Stats(); got.Builds != 1 || got.Failures != 1 {
+		t.Errorf("Stats() = %+v; want 1 failed build", got)
+	}
+}
+
+func TestPoller_DryRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	marker := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	var called bool
+	output := captureStdout(t)
+	clock := newFakeClock(time.Now())
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		DryRun:       true,
+		Pre: []pitstop.Step{
+			pitstop.BuildCommand("touch", marker("pre")),
+			pitstop.BuildFunc(func() error {
+				called = true
+				return nil
+			}),
+		},
+		Run: pitstop.RunCommand("touch", marker("run")),
+		// Wrapped steps are described by the steps they wrap.
+		Post: []pitstop.Step{pitstop.Once(pitstop.Retry(2, 0, pitstop.BuildCommand("touch", marker("post"))))},
+	}
+	if err := p.Start(); err != nil {
+		output()
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	clock.waitForBlock(t)
+	p.Stop()
+	out := output()
+
+	if called {
+		t.Errorf("a pre step was called during a dry run")
+	}
+	for _, name := range []string{"pre", "run", "post"} {
+		if _, err := os.Stat(marker(name)); err == nil {
+			t.Errorf("the %s command ran during a dry run", name)
+		}
+	}
+	for _, want := range []string{
+		"Dry run: touch " + marker("pre") + "\n",
+		"Dry run: skipping pre step 2 of 2\n",
+		"Dry run: touch " + marker("run") + "\n",
+		"Dry run: touch " + marker("post") + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output = %q; want it to contain %q", out, want)
+		}
+	}
+}
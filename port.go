@@ -0,0 +1,93 @@
+package pitstop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// AutoPort picks a new free TCP port every time the app is started and passes
+// it to the app through an environment variable. Because each instance gets a
+// fresh port, a new instance never has to wait for the previous one to release
+// its port before it can start listening. The zero value is ready to use.
+type AutoPort struct {
+	// Env is the name of the environment variable the port is passed in. This
+	// defaults to "PORT".
+	Env string
+
+	mu   sync.Mutex
+	port int
+}
+
+// Port returns the port most recently given to the app, or 0 if the app has
+// never been started.
+func (ap *AutoPort) Port() int {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	return ap.port
+}
+
+// RunCommand works like RunCommand, but before starting the app it picks a
+// free port and sets the Env environment variable to it.
+func (ap *AutoPort) RunCommand(command string, args ...string) RunStep {
+	return ap.RunCommandWith(RunOptions{}, command, args...)
+}
+
+// RunCommandWith works like RunCommandWith, but before starting the app it
+// picks a free port and adds the Env environment variable to opts.Env. When
+// the returned RunStep is used as a Poller's Run, the poller logs the port
+// each time the app starts and sets it on BuildFinished events.
+func (ap *AutoPort) RunCommandWith(opts RunOptions, command string, args ...string) RunStep {
+	start := processCommand(context.Background(), opts, command, args, ap.env)
+	return describedRun{
+		RunStep:  runProcess(start),
+		desc:     commandLine(command, args),
+		commands: []string{command},
+		autoPort: ap,
+	}
+}
+
+// env picks a free port for the app and returns the Env environment variable
+// set to it. The port is kept for Port.
+func (ap *AutoPort) env() ([]string, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("error finding a free port: %w", err)
+	}
+	ap.mu.Lock()
+	ap.port = port
+	ap.mu.Unlock()
+	name := ap.Env
+	if name == "" {
+		name = "PORT"
+	}
+	return []string{fmt.Sprintf("%s=%d", name, port)}, nil
+}
+
+// WaitForHTTP works like WaitForHTTP, but requests path on localhost using the
+// port most recently given to the app.
+func (ap *AutoPort) WaitForHTTP(path string, timeout time.Duration) BuildFunc {
+	return func() error {
+		return WaitForHTTP(fmt.Sprintf("http://localhost:%d%s", ap.Port(), path), timeout)()
+	}
+}
+
+// WaitForPort works like WaitForPort, using the port most recently given to
+// the app.
+func (ap *AutoPort) WaitForPort(timeout time.Duration) BuildFunc {
+	return func() error {
+		return WaitForPort(fmt.Sprintf("localhost:%d", ap.Port()), timeout)()
+	}
+}
+
+// freePort asks the OS for a TCP port that isn't currently in use.
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,105 @@
+package pitstop_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestAutoPort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "port")
+
+	var ap pitstop.AutoPort
+	ap.Env = "APP_PORT"
+	if ap.Port() != 0 {
+		t.Errorf("Port() = %d before running; want 0", ap.Port())
+	}
+	run := ap.RunCommand("sh", "-c", `echo "$APP_PORT" > "$0"`, out)
+	stop, err := run.Run()
+	if err != nil {
+		t.Fatalf("RunCommand() err = %v; want nil", err)
+	}
+	defer stop()
+	if ap.Port() == 0 {
+		t.Fatalf("Port() = 0 after running; want a port")
+	}
+
+	var got string
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		b, _ := ioutil.ReadFile(out)
+		if got = strings.TrimSpace(string(b)); got != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := strconv.Itoa(ap.Port()); got != want {
+		t.Errorf("app saw APP_PORT=%q; want %q", got, want)
+	}
+}
+
+func TestAutoPort_poller(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	var ap pitstop.AutoPort
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: 10 * time.Millisecond,
+		Run:          ap.RunCommand("sleep", "10"),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	output := captureStdout(t)
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	var finished pitstop.Event
+	timeout := time.After(5 * time.Second)
+	for finished.Type != pitstop.BuildFinished {
+		select {
+		case finished = <-events:
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %v event", pitstop.BuildFinished)
+		}
+	}
+	p.Stop()
+
+	if finished.Err != nil {
+		t.Fatalf("event.Err = %v; want nil", finished.Err)
+	}
+	if finished.Port == 0 || finished.Port != ap.Port() {
+		t.Errorf("event.Port = %d; want %d", finished.Port, ap.Port())
+	}
+	want := fmt.Sprintf("Running app on port %d...", ap.Port())
+	if got := output(); !strings.Contains(got, want) {
+		t.Errorf("output = %q; want it to contain %q", got, want)
+	}
+}
+
+func TestAutoPort_silent(t *testing.T) {
+	var ap pitstop.AutoPort
+	output := captureStdout(t)
+	stop, err := ap.RunCommand("sleep", "10").Run()
+	if err != nil {
+		t.Fatalf("RunCommand() err = %v; want nil", err)
+	}
+	stop()
+	if got := output(); strings.Contains(got, "Running app on port") {
+		t.Errorf("output = %q; want the port only logged by a Poller", got)
+	}
+}
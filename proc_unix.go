@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package pitstop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup configures cmd to start in a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcess kills the process started by cmd. If group is true, every
+// process in its process group is killed as well.
+func killProcess(cmd *exec.Cmd, group bool) error {
+	if !group {
+		return cmd.Process.Kill()
+	}
+	// A negative pid signals the entire process group.
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// terminateProcess asks the process started by cmd to exit by sending it sig,
+// or SIGTERM if sig is nil. If group is true, every process in its process
+// group is signaled.
+func terminateProcess(cmd *exec.Cmd, group bool, sig os.Signal) error {
+	if sig == nil {
+		sig = syscall.SIGTERM
+	}
+	if !group {
+		return cmd.Process.Signal(sig)
+	}
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported stop signal: %v", sig)
+	}
+	err := syscall.Kill(-cmd.Process.Pid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
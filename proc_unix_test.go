@@ -0,0 +1,259 @@
+//go:build !windows
+// +build !windows
+
+package pitstop_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestRunCommandWith_processGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ticks := filepath.Join(dir, "ticks")
+
+	// The loop runs in a child of the shell, much like the binary started by
+	// "go run", so it only stops if the whole process group is killed.
+	run := pitstop.RunCommandWith(pitstop.RunOptions{ProcessGroup: true},
+		"sh", "-c", `(while true; do echo tick >> "$0"; sleep 0.01; done) & wait`, ticks)
+	stop, err := run.Run()
+	if err != nil {
+		t.Fatalf("RunCommandWith() err = %v; want nil", err)
+	}
+	size := func() int64 {
+		info, err := os.Stat(ticks)
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for size() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size() == 0 {
+		t.Fatalf("app never started writing ticks")
+	}
+
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	before := size()
+	time.Sleep(200 * time.Millisecond)
+	if after := size(); after != before {
+		t.Errorf("ticks grew from %d to %d bytes after stop; want the child process to be killed", before, after)
+	}
+}
+
+func TestProcess_Stop(t *testing.T) {
+	for name, tc := range map[string]struct {
+		script string
+		min    time.Duration
+	}{
+		// sleep won't see the signal until it finishes, so the trap is only
+		// run after a short delay, like an app shutting down gracefully.
+		"graceful":        {`trap 'exit 0' TERM; sleep 0.2 & wait`, 0},
+		"ignores sigterm": {`trap '' TERM; sleep 10 & wait; sleep 10`, 300 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := pitstop.RunOptions{ProcessGroup: true, StopTimeout: 300 * time.Millisecond}
+			proc, err := pitstop.ProcessCommand(opts, "sh", "-c", tc.script).Start()
+			if err != nil {
+				t.Fatalf("ProcessCommand() err = %v; want nil", err)
+			}
+			// Give the shell a moment to install its trap.
+			time.Sleep(50 * time.Millisecond)
+			start := time.Now()
+			proc.Stop()
+			if elapsed := time.Since(start); elapsed < tc.min {
+				t.Errorf("Stop() returned after %v; want at least %v", elapsed, tc.min)
+			}
+			if proc.Running() {
+				t.Errorf("Running() = true after Stop returned; want false")
+			}
+		})
+	}
+}
+
+func TestProcess_StopSignal(t *testing.T) {
+	for name, group := range map[string]bool{"process": false, "process group": true} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatalf("setup: creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			got, ready := filepath.Join(dir, "got"), filepath.Join(dir, "ready")
+
+			opts := pitstop.RunOptions{ProcessGroup: group, StopSignal: os.Interrupt, StopTimeout: 5 * time.Second}
+			// The app ignores SIGTERM, so it only stops quickly if it is sent
+			// SIGINT.
+			script := `trap 'echo INT > "$0"; exit 0' INT; trap '' TERM; touch "$1"; sleep 10 & wait`
+			proc, err := pitstop.ProcessCommand(opts, "sh", "-c", script, got, ready).Start()
+			if err != nil {
+				t.Fatalf("ProcessCommand() err = %v; want nil", err)
+			}
+			if err := pitstop.WaitForFile(ready, 2*time.Second)(); err != nil {
+				proc.Stop()
+				t.Fatalf("app didn't start: %v", err)
+			}
+			start := time.Now()
+			proc.Stop()
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Stop() took %v; want the app to stop when sent SIGINT", elapsed)
+			}
+			if b, _ := ioutil.ReadFile(got); string(b) != "INT\n" {
+				t.Errorf("app got %q; want INT", b)
+			}
+		})
+	}
+}
+
+func TestPoller_TriggerOn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Hour,
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	stop := p.TriggerOn(syscall.SIGHUP)
+	defer stop()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("sending SIGHUP: %v", err)
+	}
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after SIGHUP")
+	}
+}
+
+func TestListenerRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod": "module example.com/app\n",
+		"main.go": `package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+func main() {
+	if os.Getenv("PITSTOP_LISTENER_FD") != "3" {
+		os.Exit(1)
+	}
+	ln, err := net.FileListener(os.NewFile(3, ""))
+	if err != nil {
+		os.Exit(1)
+	}
+	http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getpid())
+	}))
+}
+`,
+	})
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "app")
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("setup: building app: %v\n%s", err, out)
+	}
+
+	var addr net.Addr
+	run := pitstop.ListenerRun("127.0.0.1:0", func(ln net.Listener) pitstop.RunStep {
+		if addr != nil && ln.Addr().String() != addr.String() {
+			t.Errorf("listener addr = %v; want the same listener as before, on %v", ln.Addr(), addr)
+		}
+		addr = ln.Addr()
+		return pitstop.RunCommandWith(pitstop.RunOptions{Listener: ln}, bin)
+	})
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	var url string
+	get := func() (string, error) {
+		res, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return string(b), err
+	}
+
+	stop, err := run.Run()
+	if err != nil {
+		t.Fatalf("run() err = %v; want nil", err)
+	}
+	url = "http://" + addr.String()
+	first, err := get()
+	if err != nil {
+		stop()
+		t.Fatalf("request to the first app failed: %v", err)
+	}
+	// The next app can start while the first is still serving.
+	next, err := run.Run()
+	stop()
+	if err != nil {
+		t.Fatalf("second run() err = %v; want nil", err)
+	}
+	second, err := get()
+	next()
+	if err != nil {
+		t.Fatalf("request to the second app failed: %v", err)
+	}
+	if second == first {
+		t.Errorf("request was served by pid %s after it was stopped", first)
+	}
+
+	// A request made while no app is running waits for the next one.
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		body, err := get()
+		done <- result{body, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stop, err = run.Run()
+	if err != nil {
+		t.Fatalf("third run() err = %v; want nil", err)
+	}
+	defer stop()
+	if res := <-done; res.err != nil || res.body == "" || res.body == second {
+		t.Errorf("request made between apps = %q, %v; want it served by the next app", res.body, res.err)
+	}
+}
@@ -0,0 +1,53 @@
+package pitstop
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup configures cmd to start in a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// killProcess kills the process started by cmd along with every process it
+// started. Windows doesn't kill a process's children when it is killed, so
+// without this the binary started by "go run" would keep running and holding
+// onto its port. group is ignored since the whole tree is always killed.
+func killProcess(cmd *exec.Cmd, group bool) error {
+	return taskkill(cmd, "/F")
+}
+
+// terminateProcess asks the process started by cmd to exit. Windows doesn't
+// have an equivalent to SIGTERM that console apps respect, and can't send
+// other signals to a process, so sig is ignored and the process tree is
+// killed instead.
+func terminateProcess(cmd *exec.Cmd, group bool, sig os.Signal) error {
+	return killProcess(cmd, group)
+}
+
+// taskkill runs taskkill on the process tree started by cmd. If taskkill
+// isn't available the process is killed directly, though its children may
+// survive.
+func taskkill(cmd *exec.Cmd, flags ...string) error {
+	args := append([]string{"/T"}, flags...)
+	args = append(args, "/PID", strconv.Itoa(cmd.Process.Pid))
+	err := exec.Command("taskkill", args...).Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 128:
+		// The process couldn't be found, so it has already exited.
+		return os.ErrProcessDone
+	case errors.As(err, &exitErr):
+		return err
+	}
+	return cmd.Process.Kill()
+}
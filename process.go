@@ -0,0 +1,265 @@
+package pitstop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Process is a running app started by a ProcessStep. Unlike the stop func
+// returned by a RunStep, it can report the app's PID and whether it is still
+// running.
+type Process struct {
+	cmd     *exec.Cmd
+	group   bool
+	timeout time.Duration
+	// stopSignal is sent to ask the app to stop, or SIGTERM if it is nil.
+	stopSignal os.Signal
+	// log is the logger of the Poller that started the app.
+	log  logger
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// defaultStopTimeout is used when RunOptions.StopTimeout isn't set.
+const defaultStopTimeout = 5 * time.Second
+
+// waitDelay bounds how long waiting for an app blocks on its output after it
+// exits, which can otherwise take forever if a process it started is still
+// holding onto its stdout or stderr.
+const waitDelay = time.Second
+
+// ProcessStep starts an app asynchronously and returns the running Process.
+// It is an alternative to RunStep for callers that need to know about the
+// process it started, such as a Poller's RunProcess. Describe works the same
+// as it does for a Step.
+type ProcessStep interface {
+	Start() (*Process, error)
+	Describe() string
+}
+
+// ProcessFunc is a function that starts an app asynchronously and returns the
+// running Process. It is a ProcessStep without a description.
+type ProcessFunc func() (*Process, error)
+
+// Start calls fn.
+func (fn ProcessFunc) Start() (*Process, error) {
+	return fn()
+}
+
+// Describe returns "", since a ProcessFunc can't describe itself.
+func (fn ProcessFunc) Describe() string {
+	return ""
+}
+
+// ProcessCommand works like RunCommandWith, but returns a ProcessStep.
+func ProcessCommand(opts RunOptions, command string, args ...string) ProcessStep {
+	return describeProcess(processCommand(context.Background(), opts, command, args, nil), command, args)
+}
+
+// processCommand returns a ProcessStep that starts command with args, killing
+// it if ctx is done while it is running. If extraEnv isn't nil, it is called
+// each time the app is started for environment variables to add to opts.Env.
+func processCommand(ctx context.Context, opts RunOptions, command string, args []string, extraEnv func() ([]string, error)) processFunc {
+	stdout, stderr := defaultOutput(opts.Stdout, opts.Stderr)
+	return func(sc stepContext) (*Process, error) {
+		cmd := exec.CommandContext(ctx, command, args...)
+		cmd.Cancel = func() error {
+			return killProcess(cmd, opts.ProcessGroup)
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if opts.Prefix != "" || opts.Timestamps {
+			outw := &prefixWriter{w: stdout, prefix: opts.Prefix, timestamps: opts.Timestamps}
+			errw := outw
+			if stderr != stdout {
+				errw = &prefixWriter{w: stderr, prefix: opts.Prefix, timestamps: opts.Timestamps}
+			}
+			cmd.Stdout, cmd.Stderr = outw, errw
+		}
+		cmd.Stdin = opts.Stdin
+		env := opts.Env
+		if extraEnv != nil {
+			extra, err := extraEnv()
+			if err != nil {
+				return nil, err
+			}
+			env = append(append([]string(nil), env...), extra...)
+		}
+		if opts.Listener != nil {
+			f, err := listenerFile(opts.Listener)
+			if err != nil {
+				return nil, err
+			}
+			// The app has its own copy once it starts, so ours can be closed
+			// either way.
+			defer f.Close()
+			cmd.ExtraFiles = []*os.File{f}
+			env = append(append([]string(nil), env...), listenerFDEnv+"=3")
+		}
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		if opts.ProcessGroup {
+			setProcessGroup(cmd)
+		}
+		cmd.WaitDelay = waitDelay
+		var cg *cgroup
+		if opts.Limits.enabled() {
+			var err error
+			cg, err = newCgroup(opts.Limits)
+			if err != nil {
+				return nil, err
+			}
+			cg.apply(cmd)
+		}
+		err := cmd.Start()
+		if cg != nil {
+			cg.started()
+			if err != nil {
+				cg.remove(sc.log)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, exec.ErrNotFound) {
+				return nil, &CommandNotFoundError{Command: command, Err: err}
+			}
+			return nil, fmt.Errorf("error running: \"%s %s\": %w", command, strings.Join(args, " "), err)
+		}
+		timeout := opts.StopTimeout
+		if timeout <= 0 {
+			timeout = defaultStopTimeout
+		}
+		p := &Process{
+			cmd:        cmd,
+			group:      opts.ProcessGroup,
+			timeout:    timeout,
+			stopSignal: opts.StopSignal,
+			log:        sc.log,
+			done:       make(chan struct{}),
+		}
+		go func() {
+			p.err = cmd.Wait()
+			if cg != nil {
+				cg.remove(sc.log)
+			}
+			// Write out any partial line the app left behind, starting with
+			// our own prefixing and then any writer passed in opts.
+			flushOutput(cmd.Stdout, cmd.Stderr, stdout, stderr)
+			close(p.done)
+		}()
+		return p, nil
+	}
+}
+
+// PID returns the process ID of the app.
+func (p *Process) PID() int {
+	return p.cmd.Process.Pid
+}
+
+// Exited returns a channel that is closed once the app has exited, whether it
+// was stopped or exited on its own.
+func (p *Process) Exited() <-chan struct{} {
+	return p.done
+}
+
+// Running reports whether the app is still running.
+func (p *Process) Running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait blocks until the app exits, and then returns the same error as Err.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Err returns the error the app exited with, or nil if it exited successfully
+// or is still running.
+func (p *Process) Err() error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Stop asks the app to stop by sending it the StopSignal from its RunOptions,
+// and waits for it to exit. If it is still running once the StopTimeout from
+// its RunOptions has elapsed, it is killed. It is
+// safe to call more than once, and after the app has already exited.
+func (p *Process) Stop() {
+	p.once.Do(func() {
+		if !p.Running() {
+			return
+		}
+		p.signal(func(cmd *exec.Cmd, group bool) error {
+			return terminateProcess(cmd, group, p.stopSignal)
+		})
+		select {
+		case <-p.done:
+			if p.group {
+				// Make sure nothing else in the group outlived the app.
+				p.signal(killProcess)
+			}
+			return
+		case <-time.After(p.timeout):
+		}
+		p.log.infof("App didn't stop after %v, killing it...", p.timeout)
+		p.signal(killProcess)
+		<-p.done
+	})
+}
+
+// signal calls fn to signal the app, printing any errors but ignoring the app
+// having already exited.
+func (p *Process) signal(fn func(cmd *exec.Cmd, group bool) error) {
+	err := fn(p.cmd, p.group)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		p.log.errorf("Error stopping app: %v", err)
+	}
+}
+
+// RunAndWait works like Run, but rather than returning once the app has
+// started it waits for the app to exit. This is useful for using the same
+// pipeline as a one-off task rather than while watching for changes. If the app
+// exits with a non-zero status the *exec.ExitError describing it is returned,
+// which can be used to propagate the exit code:
+//
+//	err := pitstop.RunAndWait(pre, pitstop.ProcessCommand(opts, "./app"), nil)
+//	var exitErr *exec.ExitError
+//	if errors.As(err, &exitErr) {
+//		os.Exit(exitErr.ExitCode())
+//	}
+func RunAndWait(pre []Step, run ProcessStep, post []Step) error {
+	for _, step := range pre {
+		err := step.Build()
+		if err != nil {
+			return err
+		}
+	}
+	proc, err := run.Start()
+	if err != nil {
+		return err
+	}
+	for _, step := range post {
+		err := step.Build()
+		if err != nil {
+			proc.Stop()
+			return err
+		}
+	}
+	return proc.Wait()
+}
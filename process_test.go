@@ -0,0 +1,99 @@
+package pitstop_test
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestRunAndWait(t *testing.T) {
+	var steps []string
+	step := func(name string) pitstop.BuildFunc {
+		return func() error {
+			steps = append(steps, name)
+			return nil
+		}
+	}
+	err := pitstop.RunAndWait(
+		[]pitstop.Step{step("pre")},
+		pitstop.ProcessCommand(pitstop.RunOptions{}, "sh", "-c", "exit 3"),
+		[]pitstop.Step{step("post")},
+	)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("RunAndWait() err = %v; want an exit code of 3", err)
+	}
+	if len(steps) != 2 {
+		t.Errorf("steps = %v; want [pre post]", steps)
+	}
+
+	err = pitstop.RunAndWait(nil, pitstop.ProcessCommand(pitstop.RunOptions{}, "true"), nil)
+	if err != nil {
+		t.Errorf("RunAndWait() err = %v; want nil", err)
+	}
+}
+
+func TestProcessCommand_stdin(t *testing.T) {
+	var stdout bytes.Buffer
+	opts := pitstop.RunOptions{
+		Stdout: &stdout,
+		Stdin:  strings.NewReader("hello from stdin\n"),
+	}
+	proc, err := pitstop.ProcessCommand(opts, "cat").Start()
+	if err != nil {
+		t.Fatalf("ProcessCommand() err = %v; want nil", err)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Fatalf("Wait() err = %v; want nil", err)
+	}
+	if got, want := stdout.String(), "hello from stdin\n"; got != want {
+		t.Errorf("stdout = %q; want %q", got, want)
+	}
+}
+
+func TestProcessCommand_prefix(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	opts := pitstop.RunOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Prefix: "api | ",
+	}
+	proc, err := pitstop.ProcessCommand(opts, "sh", "-c", `printf 'one\ntwo\npartial'; echo oops >&2`).Start()
+	if err != nil {
+		t.Fatalf("ProcessCommand() err = %v; want nil", err)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Fatalf("Wait() err = %v; want nil", err)
+	}
+	if got, want := stdout.String(), "api | one\napi | two\napi | partial\n"; got != want {
+		t.Errorf("stdout = %q; want %q", got, want)
+	}
+	if got, want := stderr.String(), "api | oops\n"; got != want {
+		t.Errorf("stderr = %q; want %q", got, want)
+	}
+}
+
+func TestProcessCommand_timestamps(t *testing.T) {
+	var stdout bytes.Buffer
+	opts := pitstop.RunOptions{
+		Stdout:     &stdout,
+		Stderr:     &stdout,
+		Prefix:     "api | ",
+		Timestamps: true,
+	}
+	proc, err := pitstop.ProcessCommand(opts, "sh", "-c", `echo one; printf partial`).Start()
+	if err != nil {
+		t.Fatalf("ProcessCommand() err = %v; want nil", err)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Fatalf("Wait() err = %v; want nil", err)
+	}
+	got := timestamped.ReplaceAllString(stdout.String(), "")
+	if want := "api | one\napi | partial\n"; got != want {
+		t.Errorf("stdout without timestamps = %q; want %q", got, want)
+	}
+}
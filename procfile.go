@@ -0,0 +1,129 @@
+package pitstop
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// procfileLine matches a single "name: command" line in a Procfile.
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// procfileEntry is a single named process from a Procfile.
+type procfileEntry struct {
+	name    string
+	command string
+}
+
+// LoadProcfile reads the Procfile at path and returns a RunStep that starts
+// every process it defines, such as:
+//
+//	web: ./tmp/app -port 3000
+//	assets: npm run watch
+//
+// Each command is run using the platform's shell, so environment variables
+// and other shell features work the same way they do with Foreman. Every line
+// the processes write is prefixed with their name so their output can be told
+// apart. If any of the processes fail to start, the ones that already started
+// are stopped and an error is returned. The stop func stops all of them.
+//
+// Each process is started in its own process group so that anything started
+// by the shell is stopped along with it. See RunOptions.ProcessGroup for what
+// that means for Ctrl-C.
+func LoadProcfile(path string) (RunStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Procfile: %w", err)
+	}
+	defer f.Close()
+	entries, err := parseProcfile(f)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Procfile %q: %w", path, err)
+	}
+
+	var width int
+	for _, entry := range entries {
+		if len(entry.name) > width {
+			width = len(entry.name)
+		}
+	}
+	stdout := &lockedWriter{w: os.Stdout}
+	stderr := &lockedWriter{w: os.Stderr}
+	var procs []ProcessStep
+	for _, entry := range entries {
+		command, args := shellCommand(entry.command)
+		procs = append(procs, ProcessCommand(RunOptions{
+			Stdout:       stdout,
+			Stderr:       stderr,
+			Prefix:       fmt.Sprintf("%-*s | ", width, entry.name),
+			ProcessGroup: true,
+		}, command, args...))
+	}
+	return runFunc(func(sc stepContext) (func(), error) {
+		var started []*Process
+		stop := func() {
+			var wg sync.WaitGroup
+			for _, proc := range started {
+				wg.Add(1)
+				go func(proc *Process) {
+					defer wg.Done()
+					proc.Stop()
+				}(proc)
+			}
+			wg.Wait()
+		}
+		for i, start := range procs {
+			proc, err := startProcess(sc, start)
+			if err != nil {
+				stop()
+				return nil, fmt.Errorf("error starting %s: %w", entries[i].name, err)
+			}
+			started = append(started, proc)
+		}
+		return stop, nil
+	}), nil
+}
+
+// parseProcfile parses the entries in a Procfile. Blank lines and lines
+// starting with "#" are skipped.
+func parseProcfile(r io.Reader) ([]procfileEntry, error) {
+	var entries []procfileEntry
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := procfileLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected \"name: command\"", n)
+		}
+		if seen[m[1]] {
+			return nil, fmt.Errorf("line %d: duplicate process %q", n, m[1])
+		}
+		seen[m[1]] = true
+		entries = append(entries, procfileEntry{name: m[1], command: m[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no processes defined")
+	}
+	return entries, nil
+}
+
+// shellCommand returns the command used to run command with the platform's
+// shell.
+func shellCommand(command string) (string, []string) {
+	if runtime.GOOS == "windows" {
+		return "cmd", []string{"/C", command}
+	}
+	return "sh", []string{"-c", command}
+}
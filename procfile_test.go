@@ -0,0 +1,120 @@
+package pitstop_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestLoadProcfile(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+	done := func(name string) string {
+		return filepath.Join(dir, name+".done")
+	}
+	path := filepath.Join(dir, "Procfile")
+	procfile := "# Processes for local development\n" +
+		"web: echo hello && touch " + done("web") + " && sleep 10\n" +
+		"\n" +
+		"worker: echo working && touch " + done("worker") + " && sleep 10\n"
+	if err := ioutil.WriteFile(path, []byte(procfile), 0600); err != nil {
+		t.Fatalf("setup: writing Procfile: %v", err)
+	}
+
+	output := captureStdout(t)
+	run, err := pitstop.LoadProcfile(path)
+	if err != nil {
+		output()
+		t.Fatalf("LoadProcfile() err = %v; want nil", err)
+	}
+	stop, err := run.Run()
+	if err != nil {
+		output()
+		t.Fatalf("run() err = %v; want nil", err)
+	}
+	for _, name := range []string{"web", "worker"} {
+		if err := pitstop.WaitForFile(done(name), 5*time.Second)(); err != nil {
+			t.Errorf("%s didn't start: %v", name, err)
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("stop() didn't stop the processes")
+	}
+	got := output()
+	for _, want := range []string{"web    | hello\n", "worker | working\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q; want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestLoadProcfile_partialLine(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+	started := filepath.Join(dir, "started")
+	path := filepath.Join(dir, "Procfile")
+	procfile := "exits: printf 'full line\\npartial-no-newline'\n" +
+		"stopped: printf 'waiting' && touch " + started + " && sleep 10\n"
+	if err := ioutil.WriteFile(path, []byte(procfile), 0600); err != nil {
+		t.Fatalf("setup: writing Procfile: %v", err)
+	}
+
+	output := captureStdout(t)
+	run, err := pitstop.LoadProcfile(path)
+	if err != nil {
+		output()
+		t.Fatalf("LoadProcfile() err = %v; want nil", err)
+	}
+	stop, err := run.Run()
+	if err != nil {
+		output()
+		t.Fatalf("run() err = %v; want nil", err)
+	}
+	if err := pitstop.WaitForFile(started, 5*time.Second)(); err != nil {
+		t.Errorf("stopped didn't start: %v", err)
+	}
+	stop()
+	got := output()
+	for _, want := range []string{
+		"exits   | full line\n",
+		"exits   | partial-no-newline\n",
+		"stopped | waiting\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q; want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestLoadProcfile_invalid(t *testing.T) {
+	for name, procfile := range map[string]string{
+		"empty":     "# nothing to run\n",
+		"no name":   "./tmp/app\n",
+		"duplicate": "web: ./tmp/app\nweb: ./tmp/other\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"Procfile": procfile})
+			defer os.RemoveAll(dir)
+			_, err := pitstop.LoadProcfile(filepath.Join(dir, "Procfile"))
+			if err == nil {
+				t.Errorf("LoadProcfile() err = nil; want an error")
+			}
+		})
+	}
+	if _, err := pitstop.LoadProcfile("missing/Procfile"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadProcfile() err = %v; want a not exist error", err)
+	}
+}
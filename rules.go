@@ -0,0 +1,205 @@
+package pitstop
+
+import "time"
+
+// Rule routes changes to the files it matches to a specific set of build
+// steps, so that changes which don't affect the running app don't have to
+// restart it. For example, an app whose CSS is bundled separately might use:
+//
+//	Rules: []pitstop.Rule{{
+//		Match: []string{"*.css"},
+//		Pre:   []pitstop.Step{pitstop.BuildCommand("npm", "run", "css")},
+//	}}
+//
+// See Poller.Rules for how rules are chosen.
+type Rule struct {
+	// Match is a list of .gitignore style patterns. A changed file matches the
+	// rule if it matches any of them.
+	Match []string
+
+	// Pre are called in order when a file that matches the rule changes.
+	Pre []Step
+
+	// Restart will cause the app to be rebuilt and restarted using the
+	// Poller's Pre, Run, and Post after the rule's Pre have run.
+	Restart bool
+
+	// MinRebuildInterval overrides the Poller's MinRebuildInterval for changes
+	// handled by this rule, such as a negative value so that CSS changes are
+	// handled immediately while Go changes still wait. The default of 0 uses
+	// the Poller's MinRebuildInterval, and a negative value means there is no
+	// minimum. When the changed files are handled by different rules, or some
+	// aren't handled by any rule, the longest of their intervals is used so
+	// that no file is rebuilt sooner than it would be on its own.
+	MinRebuildInterval time.Duration
+
+	// SettleDelay overrides the Poller's SettleDelay for changes handled by
+	// this rule, such as a negative value so that CSS changes are handled as
+	// soon as they are found while Go changes still wait for a burst of saves
+	// to finish. Like MinRebuildInterval, 0 uses the Poller's SettleDelay, a
+	// negative value means there is no delay, and the longest delay of all the
+	// changed files is used, including any found while waiting.
+	SettleDelay time.Duration
+}
+
+// buildRule is a Rule with its Match patterns parsed.
+type buildRule struct {
+	Rule
+	match []ignoreRule
+}
+
+// parseRules parses the Match patterns of each rule.
+func parseRules(rules []Rule) []buildRule {
+	ret := make([]buildRule, len(rules))
+	for i, rule := range rules {
+		ret[i] = buildRule{Rule: rule, match: parseIgnorePatterns(rule.Match)}
+	}
+	return ret
+}
+
+// routeChanges decides which steps to run for the changed files, which are
+// found in dirs. Each file is handled by the first rule that matches it. pre
+// holds the Pre of every rule that handles at least one file, in the order
+// the rules are defined, and restart reports whether the app needs to be
+// rebuilt and restarted. That is the case if any of those rules has Restart
+// set, or if any file isn't handled by a rule.
+func routeChanges(rules []buildRule, dirs, changed []string) (pre []Step, restart bool) {
+	if len(rules) == 0 || len(changed) == 0 {
+		return nil, true
+	}
+	used := make([]bool, len(rules))
+	for _, path := range changed {
+		i := matchRule(rules, relToDirs(dirs, path))
+		if i < 0 {
+			restart = true
+			continue
+		}
+		used[i] = true
+	}
+	for i, rule := range rules {
+		if !used[i] {
+			continue
+		}
+		pre = append(pre, rule.Pre...)
+		if rule.Restart {
+			restart = true
+		}
+	}
+	return pre, restart
+}
+
+// minRebuildInterval returns the MinRebuildInterval to use for the changed
+// files, which are found in dirs. def is the Poller's MinRebuildInterval, which
+// is used for files that aren't handled by a rule or whose rule doesn't
+// override it.
+func minRebuildInterval(rules []buildRule, dirs, changed []string, def time.Duration) time.Duration {
+	return longestInterval(rules, dirs, changed, def, func(rule Rule) time.Duration {
+		return rule.MinRebuildInterval
+	})
+}
+
+// settleDelay works like minRebuildInterval, but for SettleDelay.
+func settleDelay(rules []buildRule, dirs, changed []string, def time.Duration) time.Duration {
+	return longestInterval(rules, dirs, changed, def, func(rule Rule) time.Duration {
+		return rule.SettleDelay
+	})
+}
+
+// longestInterval returns the longest of the intervals that field returns for
+// the rules handling each of the changed files, using def for files that
+// aren't handled by a rule or whose rule leaves it as 0.
+func longestInterval(rules []buildRule, dirs, changed []string, def time.Duration, field func(Rule) time.Duration) time.Duration {
+	var longest time.Duration
+	for _, path := range changed {
+		interval := def
+		if i := matchRule(rules, relToDirs(dirs, path)); i >= 0 && field(rules[i].Rule) != 0 {
+			interval = field(rules[i].Rule)
+		}
+		if interval > longest {
+			longest = interval
+		}
+	}
+	return longest
+}
+
+// Handler runs a build step whenever a file it matches changes, such as to
+// regenerate code from a schema:
+//
+//	Handlers: []pitstop.Handler{{
+//		Match: []string{"db/schema.sql"},
+//		Build: pitstop.BuildCommand("sqlc", "generate"),
+//	}}
+//
+// Unlike Rules, where each file is only handled by the first rule matching
+// it, every handler matching at least one of the changed files is run. See
+// Poller.Handlers for when they run.
+type Handler struct {
+	// Match is a list of .gitignore style patterns. A changed file matches the
+	// handler if it matches any of them.
+	Match []string
+
+	// Build is called when a file that matches the handler changes.
+	Build Step
+
+	// Instead will cause the files the handler matches to only run Build,
+	// rather than also being routed through Rules and rebuilding the app like
+	// any other change. By default Build runs in addition to that.
+	Instead bool
+}
+
+// buildHandler is a Handler with its Match patterns parsed.
+type buildHandler struct {
+	Handler
+	match []ignoreRule
+}
+
+// parseHandlers parses the Match patterns of each handler.
+func parseHandlers(handlers []Handler) []buildHandler {
+	ret := make([]buildHandler, len(handlers))
+	for i, h := range handlers {
+		ret[i] = buildHandler{Handler: h, match: parseIgnorePatterns(h.Match)}
+	}
+	return ret
+}
+
+// routeHandlers returns the Build of every handler that matches at least one
+// of the changed files, which are found in dirs, in the order the handlers
+// are defined. rest holds the changed files that still need to be routed
+// through Rules, which is every file that isn't matched by a handler with
+// Instead set.
+func routeHandlers(handlers []buildHandler, dirs, changed []string) (pre []Step, rest []string) {
+	if len(handlers) == 0 {
+		return nil, changed
+	}
+	used := make([]bool, len(handlers))
+	for _, path := range changed {
+		rel := relToDirs(dirs, path)
+		instead := false
+		for i, h := range handlers {
+			if matchFile(h.match, rel) {
+				used[i] = true
+				instead = instead || h.Instead
+			}
+		}
+		if !instead {
+			rest = append(rest, path)
+		}
+	}
+	for i, h := range handlers {
+		if used[i] && h.Build != nil {
+			pre = append(pre, h.Build)
+		}
+	}
+	return pre, rest
+}
+
+// matchRule returns the index of the first rule that matches the slash
+// separated path rel, or -1 if none do.
+func matchRule(rules []buildRule, rel string) int {
+	for i, rule := range rules {
+		if matchFile(rule.match, rel) {
+			return i
+		}
+	}
+	return -1
+}
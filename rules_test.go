@@ -0,0 +1,344 @@
+package pitstop_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestPoller_Rules(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":               "",
+		"static/app.css":        "",
+		"templates/index.tmpl":  "",
+		"templates/layout.tmpl": "",
+	})
+	defer os.RemoveAll(dir)
+
+	var steps []string
+	step := func(name string) pitstop.BuildFunc {
+		return func() error {
+			steps = append(steps, name)
+			return nil
+		}
+	}
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Rules: []pitstop.Rule{
+			{Match: []string{"*.css"}, Pre: []pitstop.Step{step("css")}},
+			{Match: []string{"templates/"}, Pre: []pitstop.Step{step("templates")}, Restart: true},
+			// Never used, since the earlier rule matches first.
+			{Match: []string{"*.tmpl"}, Pre: []pitstop.Step{step("tmpl")}},
+		},
+		Pre: []pitstop.Step{step("pre")},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			steps = append(steps, "run")
+			return func() { steps = append(steps, "stop") }, nil
+		}),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	waitForBuild := func() {
+		t.Helper()
+		for {
+			select {
+			case e := <-events:
+				if e.Type == pitstop.BuildFinished {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timed out waiting for a build")
+			}
+		}
+	}
+	waitForBuild()
+
+	for i, tc := range []struct {
+		touch []string
+		want  []string
+	}{
+		{[]string{"static/app.css"}, []string{"css"}},
+		{[]string{"templates/index.tmpl"}, []string{"stop", "templates", "pre", "run"}},
+		{[]string{"static/app.css", "main.go"}, []string{"stop", "css", "pre", "run"}},
+	} {
+		clock.waitForBlock(t)
+		steps = nil
+		for _, name := range tc.touch {
+			touchAt(t, dir, name, start.Add(time.Duration(i)*time.Second+500*time.Millisecond))
+		}
+		clock.Advance(time.Second)
+		waitForBuild()
+		if !reflect.DeepEqual(steps, tc.want) {
+			t.Errorf("after changing %v, steps = %v; want %v", tc.touch, steps, tc.want)
+		}
+	}
+}
+
+func TestPoller_Handlers(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":        "",
+		"db/schema.sql":  "",
+		"openapi.yaml":   "",
+		"static/app.css": "",
+	})
+	defer os.RemoveAll(dir)
+
+	var steps []string
+	step := func(name string) pitstop.BuildFunc {
+		return func() error {
+			steps = append(steps, name)
+			return nil
+		}
+	}
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		Clock:        clock,
+		Rules: []pitstop.Rule{
+			{Match: []string{"*.css"}, Pre: []pitstop.Step{step("css")}},
+		},
+		Handlers: []pitstop.Handler{
+			{Match: []string{"*.sql"}, Build: step("models")},
+			{Match: []string{"openapi.yaml"}, Build: step("client"), Instead: true},
+			// Handlers aren't exclusive, so this runs along with the others.
+			{Match: []string{"*.sql", "*.yaml"}, Build: step("docs")},
+		},
+		Pre: []pitstop.Step{step("pre")},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			steps = append(steps, "run")
+			return func() { steps = append(steps, "stop") }, nil
+		}),
+	}
+	events, cancel := p.Events()
+	defer cancel()
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	waitForBuild := func() {
+		t.Helper()
+		for {
+			select {
+			case e := <-events:
+				if e.Type == pitstop.BuildFinished {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timed out waiting for a build")
+			}
+		}
+	}
+	waitForBuild()
+
+	for i, tc := range []struct {
+		touch []string
+		want  []string
+	}{
+		{[]string{"db/schema.sql"}, []string{"stop", "models", "docs", "pre", "run"}},
+		{[]string{"openapi.yaml"}, []string{"client", "docs"}},
+		{[]string{"openapi.yaml", "static/app.css"}, []string{"client", "docs", "css"}},
+		{[]string{"openapi.yaml", "main.go"}, []string{"stop", "client", "docs", "pre", "run"}},
+	} {
+		clock.waitForBlock(t)
+		steps = nil
+		for _, name := range tc.touch {
+			touchAt(t, dir, name, start.Add(time.Duration(i)*time.Second+500*time.Millisecond))
+		}
+		clock.Advance(time.Second)
+		waitForBuild()
+		if !reflect.DeepEqual(steps, tc.want) {
+			t.Errorf("after changing %v, steps = %v; want %v", tc.touch, steps, tc.want)
+		}
+	}
+}
+
+func TestPoller_RulesMinRebuildInterval(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":        "",
+		"static/app.css": "",
+	})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:                dir,
+		ScanInterval:       time.Second,
+		MinRebuildInterval: 10 * time.Second,
+		Clock:              clock,
+		Rules: []pitstop.Rule{{
+			Match:              []string{"*.css"},
+			Restart:            true,
+			MinRebuildInterval: -1,
+		}},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+	clock.waitForBlock(t)
+
+	// CSS changes skip the cooldown.
+	touchAt(t, dir, "static/app.css", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild immediately after a CSS change")
+	}
+	clock.waitForBlock(t)
+
+	// A Go change alongside a CSS change uses the longer cooldown.
+	touchAt(t, dir, "static/app.css", start.Add(1500*time.Millisecond))
+	touchAt(t, dir, "main.go", start.Add(1500*time.Millisecond))
+	clock.Advance(time.Second)
+	if wait := clock.waitForBlock(t); wait != 9*time.Second {
+		t.Errorf("waited %v before rebuilding; want 9s", wait)
+	}
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt before MinRebuildInterval elapsed")
+	default:
+	}
+	clock.Advance(9 * time.Second)
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after MinRebuildInterval elapsed")
+	}
+}
+
+func TestPoller_RulesMinRebuildInterval_changeWhileWaiting(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":        "",
+		"static/app.css": "",
+	})
+	defer os.RemoveAll(dir)
+
+	calls := make(chan string, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:                dir,
+		ScanInterval:       time.Second,
+		MinRebuildInterval: 10 * time.Second,
+		Clock:              clock,
+		Rules: []pitstop.Rule{{
+			Match: []string{"*.css"},
+			Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+				calls <- "css"
+				return nil
+			})},
+		}},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			calls <- "run"
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-calls
+	clock.waitForBlock(t)
+
+	// A Go file saved while a CSS change waits out MinRebuildInterval is
+	// handled by the same rebuild, so the app is restarted.
+	touchAt(t, dir, "static/app.css", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	if wait := clock.waitForBlock(t); wait != 9*time.Second {
+		t.Errorf("waited %v before rebuilding; want 9s", wait)
+	}
+	touchAt(t, dir, "main.go", start.Add(5*time.Second))
+	clock.Advance(9 * time.Second)
+	var got []string
+	for len(got) < 2 {
+		select {
+		case call := <-calls:
+			got = append(got, call)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("calls = %v; want [css run]", got)
+		}
+	}
+	if want := []string{"css", "run"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v; want %v", got, want)
+	}
+}
+
+func TestPoller_RulesSettleDelay(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":        "",
+		"static/app.css": "",
+	})
+	defer os.RemoveAll(dir)
+
+	calls := make(chan string, 10)
+	start := time.Now()
+	clock := newFakeClock(start)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Second,
+		SettleDelay:  5 * time.Second,
+		Clock:        clock,
+		Rules: []pitstop.Rule{{
+			Match:       []string{"*.css"},
+			SettleDelay: -1,
+			Pre: []pitstop.Step{pitstop.BuildFunc(func() error {
+				calls <- "css"
+				return nil
+			})},
+		}},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			calls <- "run"
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-calls
+	clock.waitForBlock(t)
+
+	// CSS changes don't wait for files to settle.
+	touchAt(t, dir, "static/app.css", start.Add(500*time.Millisecond))
+	clock.Advance(time.Second)
+	select {
+	case call := <-calls:
+		if call != "css" {
+			t.Errorf("call = %q; want css", call)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild immediately after a CSS change")
+	}
+	if wait := clock.waitForBlock(t); wait != time.Second {
+		t.Errorf("waited %v after a CSS change; want the 1s scan interval", wait)
+	}
+
+	// A Go change alongside a CSS change uses the longer delay.
+	touchAt(t, dir, "static/app.css", start.Add(1500*time.Millisecond))
+	touchAt(t, dir, "main.go", start.Add(1500*time.Millisecond))
+	clock.Advance(time.Second)
+	if wait := clock.waitForBlock(t); wait != 5*time.Second {
+		t.Errorf("waited %v before rebuilding; want 5s", wait)
+	}
+}
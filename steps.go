@@ -0,0 +1,570 @@
+package pitstop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Describe returns a Step that calls fn and is described by desc, so that
+// DryRun and TimedMiddleware can say what the step does:
+//
+//	pitstop.Describe("seed the database", func() error {
+//		return seed(db)
+//	})
+func Describe(desc string, fn BuildFunc) Step {
+	return describedStep{Step: fn, desc: desc}
+}
+
+// DescribeRun works like Describe, but for a RunFunc.
+func DescribeRun(desc string, fn RunFunc) RunStep {
+	return describedRun{RunStep: fn, desc: desc}
+}
+
+// describedStep is a Step with a description, along with the names of the
+// commands it runs, if it knows them, so they can be checked for on PATH
+// before polling starts.
+type describedStep struct {
+	Step
+	desc     string
+	commands []string
+}
+
+func (s describedStep) Describe() string {
+	return s.desc
+}
+
+func (s describedStep) commandNames() []string {
+	return s.commands
+}
+
+func (s describedStep) buildContext(sc stepContext) error {
+	return buildStep(sc, s.Step)
+}
+
+// describedRun is the RunStep version of describedStep. autoPort is the
+// AutoPort that picks the app's port, if any, so the poller can report it.
+type describedRun struct {
+	RunStep
+	desc     string
+	commands []string
+	autoPort *AutoPort
+}
+
+func (r describedRun) Describe() string {
+	return r.desc
+}
+
+func (r describedRun) commandNames() []string {
+	return r.commands
+}
+
+func (r describedRun) runContext(sc stepContext) (func(), error) {
+	return runStep(sc, r.RunStep)
+}
+
+// describedProcess is the ProcessStep version of describedStep.
+type describedProcess struct {
+	ProcessStep
+	desc     string
+	commands []string
+}
+
+func (p describedProcess) Describe() string {
+	return p.desc
+}
+
+func (p describedProcess) commandNames() []string {
+	return p.commands
+}
+
+func (p describedProcess) startContext(sc stepContext) (*Process, error) {
+	return startProcess(sc, p.ProcessStep)
+}
+
+// describeBuild returns step, described as running command with args.
+func describeBuild(step Step, command string, args []string) Step {
+	return describedStep{Step: step, desc: commandLine(command, args), commands: []string{command}}
+}
+
+// describeRun returns run, described as running command with args.
+func describeRun(run RunStep, command string, args []string) RunStep {
+	return describedRun{RunStep: run, desc: commandLine(command, args), commands: []string{command}}
+}
+
+// describeProcess returns start, described as running command with args.
+func describeProcess(start ProcessStep, command string, args []string) ProcessStep {
+	return describedProcess{ProcessStep: start, desc: commandLine(command, args), commands: []string{command}}
+}
+
+// stepContext is handed to each step a Poller runs, so that steps such as
+// Timed, and the Process started by RunCommand, log through the poller's
+// logger, and steps that wait, such as Retry, stop waiting once the poller is
+// stopped and use its Clock. A step run on its own, such as by calling its
+// Build method, gets the zero stepContext, which logs the same way a Poller
+// with the default Verbosity does and is never canceled.
+type stepContext struct {
+	log   logger
+	ctx   context.Context
+	clock Clock
+}
+
+// sleep waits for d to pass on sc's clock, and reports whether it did rather
+// than sc's context being done first.
+func (sc stepContext) sleep(d time.Duration) bool {
+	ctx, clock := sc.ctx, sc.clock
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	return sleep(ctx, clock, d)
+}
+
+// err returns the error from sc's context, if it is done.
+func (sc stepContext) err() error {
+	if sc.ctx == nil {
+		return nil
+	}
+	return sc.ctx.Err()
+}
+
+// stepFunc is a Step that uses the stepContext it is run with.
+type stepFunc func(sc stepContext) error
+
+func (fn stepFunc) Build() error {
+	return fn(stepContext{})
+}
+
+func (fn stepFunc) Describe() string {
+	return ""
+}
+
+func (fn stepFunc) buildContext(sc stepContext) error {
+	return fn(sc)
+}
+
+// runFunc is the RunStep version of stepFunc.
+type runFunc func(sc stepContext) (func(), error)
+
+func (fn runFunc) Run() (func(), error) {
+	return fn(stepContext{})
+}
+
+func (fn runFunc) Describe() string {
+	return ""
+}
+
+func (fn runFunc) runContext(sc stepContext) (func(), error) {
+	return fn(sc)
+}
+
+// processFunc is the ProcessStep version of stepFunc.
+type processFunc func(sc stepContext) (*Process, error)
+
+func (fn processFunc) Start() (*Process, error) {
+	return fn(stepContext{})
+}
+
+func (fn processFunc) Describe() string {
+	return ""
+}
+
+func (fn processFunc) startContext(sc stepContext) (*Process, error) {
+	return fn(sc)
+}
+
+// buildStep runs step with sc, if it uses a stepContext, and otherwise just
+// calls its Build method.
+func buildStep(sc stepContext, step Step) error {
+	if s, ok := step.(interface{ buildContext(stepContext) error }); ok {
+		return s.buildContext(sc)
+	}
+	return step.Build()
+}
+
+// runStep works like buildStep, but for a RunStep.
+func runStep(sc stepContext, run RunStep) (func(), error) {
+	if r, ok := run.(interface {
+		runContext(stepContext) (func(), error)
+	}); ok {
+		return r.runContext(sc)
+	}
+	return run.Run()
+}
+
+// startProcess works like buildStep, but for a ProcessStep.
+func startProcess(sc stepContext, start ProcessStep) (*Process, error) {
+	if p, ok := start.(interface {
+		startContext(stepContext) (*Process, error)
+	}); ok {
+		return p.startContext(sc)
+	}
+	return start.Start()
+}
+
+// commandLine returns command and args joined by spaces, such as
+// "go build -o app .".
+func commandLine(command string, args []string) string {
+	return strings.Join(append([]string{command}, args...), " ")
+}
+
+// inherit returns step, using the description and commands of from wherever
+// step doesn't have its own. It is used by steps that wrap from, so they are
+// described the same way.
+func inherit(step, from Step) Step {
+	ret := describedStep{Step: step, desc: step.Describe(), commands: stepCommands(step)}
+	if ret.desc == "" {
+		ret.desc = from.Describe()
+	}
+	if ret.commands == nil {
+		ret.commands = stepCommands(from)
+	}
+	return ret
+}
+
+// inheritRun works like inherit, but for a RunStep, and also keeps the
+// AutoPort of from.
+func inheritRun(run, from RunStep) RunStep {
+	ret := describedRun{RunStep: run, desc: run.Describe(), commands: stepCommands(run), autoPort: stepAutoPort(run)}
+	if ret.desc == "" {
+		ret.desc = from.Describe()
+	}
+	if ret.commands == nil {
+		ret.commands = stepCommands(from)
+	}
+	if ret.autoPort == nil {
+		ret.autoPort = stepAutoPort(from)
+	}
+	return ret
+}
+
+// stepCommands returns the names of the commands step runs, if it knows them.
+// step can be a Step, RunStep, or ProcessStep.
+func stepCommands(step interface{}) []string {
+	if c, ok := step.(interface{ commandNames() []string }); ok {
+		return c.commandNames()
+	}
+	return nil
+}
+
+// stepAutoPort returns the AutoPort that picks the port for the app run
+// starts, or nil if there isn't one.
+func stepAutoPort(run RunStep) *AutoPort {
+	if r, ok := run.(describedRun); ok {
+		return r.autoPort
+	}
+	return nil
+}
+
+// joinDescriptions joins descs, the descriptions of several steps, with sep,
+// such as "go vet ./... && go build .". Steps without a description are
+// described as undescribed, and if none of them have one, "" is returned.
+func joinDescriptions(descs []string, sep, undescribed string) string {
+	var described bool
+	joined := make([]string, len(descs))
+	for i, desc := range descs {
+		if desc == "" {
+			desc = undescribed
+		} else {
+			described = true
+		}
+		joined[i] = desc
+	}
+	if !described {
+		return ""
+	}
+	return strings.Join(joined, sep)
+}
+
+// Retry returns a Step that runs step, and if it returns an error, tries
+// again up to n more times, waiting delay between each attempt. If every
+// attempt fails the error from the last attempt is returned. When run by a
+// Poller, or by RunContext, it stops retrying once the poller is stopped or
+// the context is done, and returns the context's error. It is described the
+// same way as step.
+func Retry(n int, delay time.Duration, step Step) Step {
+	return inherit(stepFunc(func(sc stepContext) error {
+		err := buildStep(sc, step)
+		for i := 0; i < n && err != nil; i++ {
+			if !sc.sleep(delay) {
+				return sc.err()
+			}
+			err = buildStep(sc, step)
+		}
+		return err
+	}), step)
+}
+
+// Chain returns a Step that runs each of steps in order, stopping at and
+// returning the first error encountered. It is described by joining their
+// descriptions with " && ".
+func Chain(steps ...Step) Step {
+	var descs, commands []string
+	for _, step := range steps {
+		descs = append(descs, step.Describe())
+		commands = append(commands, stepCommands(step)...)
+	}
+	return describedStep{
+		Step: stepFunc(func(sc stepContext) error {
+			for _, step := range steps {
+				err := buildStep(sc, step)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+		desc:     joinDescriptions(descs, " && ", "build step"),
+		commands: commands,
+	}
+}
+
+// ChainAll returns a Step that runs every one of steps in order, even if
+// some of them fail. Any errors are joined together using errors.Join and
+// returned once every step has been run. It is described by joining their
+// descriptions with "; ".
+func ChainAll(steps ...Step) Step {
+	var descs, commands []string
+	for _, step := range steps {
+		descs = append(descs, step.Describe())
+		commands = append(commands, stepCommands(step)...)
+	}
+	return describedStep{
+		Step: stepFunc(func(sc stepContext) error {
+			var errs []error
+			for _, step := range steps {
+				err := buildStep(sc, step)
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		}),
+		desc:     joinDescriptions(descs, "; ", "build step"),
+		commands: commands,
+	}
+}
+
+// Once returns a Step that only runs step the first time it is run. Every
+// later run does nothing and returns nil, even if the first run failed. It is
+// useful for post steps that should only happen once per session, such as
+// opening a browser.
+func Once(step Step) Step {
+	var once sync.Once
+	return inherit(stepFunc(func(sc stepContext) error {
+		var err error
+		once.Do(func() {
+			err = buildStep(sc, step)
+		})
+		return err
+	}), step)
+}
+
+// Timed returns a Step that runs step and logs how long it took and whether
+// it succeeded, such as:
+//
+//	Step "go build" took 1.2s
+//
+// It is meant for finding which step of a slow build is the bottleneck. The
+// error from step is returned unchanged. Like the rest of the poller's output,
+// the message is hidden by Silent.
+func Timed(name string, step Step) Step {
+	return inherit(stepFunc(func(sc stepContext) error {
+		start := time.Now()
+		err := buildStep(sc, step)
+		if err != nil {
+			sc.log.infof("Step %q failed after %v", name, time.Since(start))
+		} else {
+			sc.log.infof("Step %q took %v", name, time.Since(start))
+		}
+		return err
+	}), step)
+}
+
+// TimedRun works like Timed, but for a RunStep. It logs how long run took to
+// return, which is how long it took to start the app rather than how long the
+// app ran for.
+func TimedRun(name string, run RunStep) RunStep {
+	return inheritRun(runFunc(func(sc stepContext) (func(), error) {
+		start := time.Now()
+		stop, err := runStep(sc, run)
+		if err != nil {
+			sc.log.infof("Starting %q failed after %v", name, time.Since(start))
+		} else {
+			sc.log.infof("Started %q in %v", name, time.Since(start))
+		}
+		return stop, err
+	}), run)
+}
+
+// MultiRun returns a RunStep that starts each of runs in order, for apps made
+// up of several processes that should be restarted together, such as an API
+// server and a worker. The stop func stops them in the reverse order, and is
+// safe to call more than once. If any of them fail to start, the ones that
+// already started are stopped before the error is returned. RunOptions.Prefix
+// can be used to tell their output apart:
+//
+//	Run: pitstop.MultiRun(
+//		pitstop.RunCommandWith(pitstop.RunOptions{Prefix: "api    | "}, "./tmp/api"),
+//		pitstop.RunCommandWith(pitstop.RunOptions{Prefix: "worker | "}, "./tmp/worker"),
+//	),
+//
+// It is described by joining their descriptions with " & ".
+func MultiRun(runs ...RunStep) RunStep {
+	var descs, commands []string
+	for _, run := range runs {
+		descs = append(descs, run.Describe())
+		commands = append(commands, stepCommands(run)...)
+	}
+	return describedRun{
+		RunStep: runFunc(func(sc stepContext) (func(), error) {
+			var stops []func()
+			var once sync.Once
+			stop := func() {
+				once.Do(func() {
+					for i := len(stops) - 1; i >= 0; i-- {
+						stops[i]()
+					}
+				})
+			}
+			for _, run := range runs {
+				s, err := runStep(sc, run)
+				if err != nil {
+					stop()
+					return nil, err
+				}
+				if s != nil {
+					stops = append(stops, s)
+				}
+			}
+			return stop, nil
+		}),
+		desc:     joinDescriptions(descs, " & ", "app"),
+		commands: commands,
+	}
+}
+
+// RunWhenChanged returns a Step that only runs step if any of the files
+// matching inputs, which are filepath.Match style globs such as "api/*.proto",
+// have changed since step last succeeded. A file being added or removed counts
+// as a change, and step is always run the first time. The inputs are checked
+// again after step succeeds, so files that step rewrites won't cause it to run
+// again on the next call. It is described the same way as step.
+func RunWhenChanged(inputs []string, step Step) Step {
+	var mu sync.Mutex
+	var last map[string]time.Time
+	return inherit(stepFunc(func(sc stepContext) error {
+		mu.Lock()
+		defer mu.Unlock()
+		current, err := globModTimes(inputs)
+		if err != nil {
+			return err
+		}
+		if last != nil && sameModTimes(current, last) {
+			return nil
+		}
+		if err := buildStep(sc, step); err != nil {
+			return err
+		}
+		last, err = globModTimes(inputs)
+		return err
+	}), step)
+}
+
+// Migrate returns a Step that runs a database migration command, but only
+// when the migration files in dir have changed since it last succeeded.
+// include is a list of .gitignore style patterns, the same as the Include of
+// a Watcher, so "*.sql" matches SQL files in dir and any of its
+// subdirectories. If include is empty every file in dir is a migration file.
+// It is intended to be used as a pre step so that a failed migration stops the
+// app from being run, while skipping the migration keeps rebuilds fast when
+// nothing migration related changed:
+//
+//	Pre: []pitstop.Step{
+//		pitstop.Migrate("db/migrations", []string{"*.sql"}, "migrate", "-path", "db/migrations", "-database", dbURL, "up"),
+//		pitstop.BuildCommand("go", "build", "-o", "./tmp/app", "."),
+//	}
+//
+// As with RunWhenChanged, a file being added or removed counts as a change,
+// the command is always run the first time, and a failed migration is tried
+// again on the next call. Like BuildCommand, it is described by the command
+// line.
+func Migrate(dir string, include []string, command string, args ...string) Step {
+	w := &Watcher{Dirs: []string{dir}, Include: include}
+	migrate := BuildCommand(command, args...)
+	var mu sync.Mutex
+	var last map[string]time.Time
+	return inherit(stepFunc(func(sc stepContext) error {
+		mu.Lock()
+		defer mu.Unlock()
+		current, err := scanModTimes(w)
+		if err != nil {
+			return err
+		}
+		if last != nil && sameModTimes(current, last) {
+			return nil
+		}
+		if err := buildStep(sc, migrate); err != nil {
+			return err
+		}
+		last, err = scanModTimes(w)
+		return err
+	}), migrate)
+}
+
+// scanModTimes returns the mtime of every file w scans, keyed by path.
+func scanModTimes(w *Watcher) (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, dir := range w.dirs() {
+		err := w.walk(dir, func(path string, info os.FileInfo) error {
+			modTimes[path] = info.ModTime()
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error checking migrations: %w", err)
+		}
+	}
+	return modTimes, nil
+}
+
+// globModTimes returns the mtime of every file matching patterns, keyed by
+// path.
+func globModTimes(patterns []string) (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error checking inputs: %w", err)
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				// It was removed since we globbed, so leave it out.
+				continue
+			}
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes, nil
+}
+
+// sameModTimes reports whether a and b contain the same paths and mtimes.
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, modTime := range a {
+		other, ok := b[path]
+		if !ok || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
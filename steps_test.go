@@ -0,0 +1,338 @@
+package pitstop_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestRetry(t *testing.T) {
+	for name, tc := range map[string]struct {
+		n         int
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		"success":              {n: 3, failures: 0, wantCalls: 1},
+		"succeeds on retry":    {n: 3, failures: 2, wantCalls: 3},
+		"succeeds on last try": {n: 3, failures: 3, wantCalls: 4},
+		"always fails":         {n: 3, failures: 10, wantCalls: 4, wantErr: true},
+		"no retries":           {n: 0, failures: 1, wantCalls: 1, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls int
+			fn := pitstop.Retry(tc.n, 0, pitstop.BuildFunc(func() error {
+				calls++
+				if calls <= tc.failures {
+					return fmt.Errorf("attempt %d failed", calls)
+				}
+				return nil
+			}))
+			err := fn.Build()
+			if calls != tc.wantCalls {
+				t.Errorf("calls = %d; want %d", calls, tc.wantCalls)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Retry() err = %v; want error: %v", err, tc.wantErr)
+			}
+			if err != nil && err.Error() != fmt.Sprintf("attempt %d failed", calls) {
+				t.Errorf("Retry() err = %v; want the last attempt's error", err)
+			}
+		})
+	}
+}
+
+func TestRetry_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	step := pitstop.Retry(3, time.Hour, pitstop.BuildFunc(func() error {
+		calls++
+		cancel()
+		return errors.New("not ready")
+	}))
+	done := make(chan error, 1)
+	go func() {
+		_, err := pitstop.RunContext(ctx, []pitstop.Step{step}, pitstop.RunFunc(func() (func(), error) { return func() {}, nil }), nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RunContext() err = %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Retry kept waiting after ctx was canceled")
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d; want 1", calls)
+	}
+}
+
+func TestChain(t *testing.T) {
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+	var calls []string
+	record := func(name string, err error) pitstop.BuildFunc {
+		return func() error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	t.Run("Chain", func(t *testing.T) {
+		calls = nil
+		err := pitstop.Chain(record("a", nil), record("b", errFirst), record("c", nil)).Build()
+		if err != errFirst {
+			t.Errorf("Chain() err = %v; want %v", err, errFirst)
+		}
+		if want := []string{"a", "b"}; !reflect.DeepEqual(calls, want) {
+			t.Errorf("calls = %v; want %v", calls, want)
+		}
+	})
+
+	t.Run("ChainAll", func(t *testing.T) {
+		calls = nil
+		err := pitstop.ChainAll(record("a", errFirst), record("b", nil), record("c", errSecond)).Build()
+		if !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+			t.Errorf("ChainAll() err = %v; want both errors", err)
+		}
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(calls, want) {
+			t.Errorf("calls = %v; want %v", calls, want)
+		}
+	})
+
+	t.Run("ChainAll success", func(t *testing.T) {
+		calls = nil
+		err := pitstop.ChainAll(record("a", nil), record("b", nil)).Build()
+		if err != nil {
+			t.Errorf("ChainAll() err = %v; want nil", err)
+		}
+	})
+}
+
+func TestOnce(t *testing.T) {
+	var calls int
+	fn := pitstop.Once(pitstop.BuildFunc(func() error {
+		calls++
+		return errors.New("failed")
+	}))
+	if err := fn.Build(); err == nil {
+		t.Errorf("first call err = nil; want the error from fn")
+	}
+	if err := fn.Build(); err != nil {
+		t.Errorf("second call err = %v; want nil", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times; want 1", calls)
+	}
+}
+
+func TestTimed(t *testing.T) {
+	errStep := errors.New("step failed")
+	output := captureStdout(t)
+	if err := pitstop.Timed("go build", pitstop.BuildFunc(func() error { return nil })).Build(); err != nil {
+		t.Errorf("Timed() of a passing step err = %v; want nil", err)
+	}
+	if err := pitstop.Timed("go vet", pitstop.BuildFunc(func() error { return errStep })).Build(); err != errStep {
+		t.Errorf("Timed() of a failing step err = %v; want %v unchanged", err, errStep)
+	}
+	stop, err := pitstop.TimedRun("app", pitstop.RunFunc(func() (func(), error) { return func() {}, nil })).Run()
+	if err != nil {
+		t.Errorf("TimedRun() err = %v; want nil", err)
+	} else {
+		stop()
+	}
+	if _, err := pitstop.TimedRun("worker", pitstop.RunFunc(func() (func(), error) { return nil, errStep })).Run(); err != errStep {
+		t.Errorf("TimedRun() of a failing app err = %v; want %v unchanged", err, errStep)
+	}
+	got := output()
+	for _, want := range []string{
+		`Step "go build" took `,
+		`Step "go vet" failed after `,
+		`Started "app" in `,
+		`Starting "worker" failed after `,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q; want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestMultiRun(t *testing.T) {
+	var calls []string
+	app := func(name string, err error) pitstop.RunFunc {
+		return func() (func(), error) {
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, "start "+name)
+			return func() { calls = append(calls, "stop "+name) }, nil
+		}
+	}
+
+	stop, err := pitstop.MultiRun(app("api", nil), app("worker", nil), app("vite", nil)).Run()
+	if err != nil {
+		t.Fatalf("MultiRun() err = %v; want nil", err)
+	}
+	stop()
+	stop()
+	want := []string{"start api", "start worker", "start vite", "stop vite", "stop worker", "stop api"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v; want %v", calls, want)
+	}
+
+	calls = nil
+	errStart := errors.New("failed to start")
+	_, err = pitstop.MultiRun(app("api", nil), app("worker", nil), app("vite", errStart)).Run()
+	if !errors.Is(err, errStart) {
+		t.Errorf("MultiRun() err = %v; want %v", err, errStart)
+	}
+	want = []string{"start api", "start worker", "stop worker", "stop api"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v; want %v", calls, want)
+	}
+}
+
+func TestRunWhenChanged(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"api/users.proto": "",
+		"api/posts.proto": "",
+		"main.go":         "",
+	})
+	defer os.RemoveAll(dir)
+
+	var calls int
+	fn := pitstop.RunWhenChanged([]string{filepath.Join(dir, "api", "*.proto")}, pitstop.BuildFunc(func() error {
+		calls++
+		// Rewriting an input shouldn't cause another run.
+		touchAt(t, dir, "api/users.proto", time.Now().Add(-time.Minute))
+		return nil
+	}))
+	for i, tc := range []struct {
+		change func()
+		want   int
+	}{
+		{func() {}, 1},
+		{func() {}, 1},
+		{func() { touch(t, dir, "main.go") }, 1},
+		{func() { touch(t, dir, "api/posts.proto") }, 2},
+		{func() { os.Remove(filepath.Join(dir, "api", "posts.proto")) }, 3},
+		{func() {}, 3},
+	} {
+		tc.change()
+		if err := fn.Build(); err != nil {
+			t.Fatalf("call %d: err = %v; want nil", i, err)
+		}
+		if calls != tc.want {
+			t.Errorf("call %d: fn called %d times; want %d", i, calls, tc.want)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"db/migrations/001_users.sql": "",
+		"db/migrations/README.md":     "",
+		"main.go":                     "",
+	})
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+	fail := filepath.Join(dir, "fail")
+	migrations := filepath.Join(dir, "db", "migrations")
+	// The migration command records each run, and fails while the fail file
+	// exists.
+	fn := pitstop.Migrate(migrations, []string{"*.sql"},
+		"sh", "-c", `echo run >> "$0" && test ! -e "$1"`, runs, fail)
+	for i, tc := range []struct {
+		change  func()
+		wantErr bool
+		want    int
+	}{
+		{func() {}, false, 1},
+		{func() { touch(t, dir, "main.go") }, false, 1},
+		{func() { touch(t, dir, "db/migrations/README.md") }, false, 1},
+		{func() {
+			ioutil.WriteFile(filepath.Join(migrations, "002_posts.sql"), nil, 0600)
+			ioutil.WriteFile(fail, nil, 0600)
+		}, true, 2},
+		// A failed migration is tried again on the next build.
+		{func() { os.Remove(fail) }, false, 3},
+		{func() {}, false, 3},
+		// Migrations in subdirectories are noticed too.
+		{func() {
+			os.MkdirAll(filepath.Join(migrations, "2024"), 0700)
+			ioutil.WriteFile(filepath.Join(migrations, "2024", "003_tags.sql"), nil, 0600)
+		}, false, 4},
+		{func() {}, false, 4},
+	} {
+		tc.change()
+		err := fn.Build()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("call %d: err = %v; want error %v", i, err, tc.wantErr)
+		}
+		b, _ := ioutil.ReadFile(runs)
+		if got := strings.Count(string(b), "run"); got != tc.want {
+			t.Errorf("call %d: migration ran %d times; want %d", i, got, tc.want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	build := pitstop.BuildCommand("go", "build", ".")
+	vet := pitstop.BuildCommand("go", "vet", "./...")
+	plain := pitstop.BuildFunc(func() error { return nil })
+	for name, tc := range map[string]struct {
+		step pitstop.Step
+		want string
+	}{
+		"BuildCommand":       {step: build, want: "go build ."},
+		"BuildFunc":          {step: plain, want: ""},
+		"Describe":           {step: pitstop.Describe("seed the database", plain), want: "seed the database"},
+		"Retry":              {step: pitstop.Retry(2, 0, build), want: "go build ."},
+		"Once":               {step: pitstop.Once(build), want: "go build ."},
+		"Timed":              {step: pitstop.Timed("build", build), want: "go build ."},
+		"RunWhenChanged":     {step: pitstop.RunWhenChanged([]string{"*.go"}, build), want: "go build ."},
+		"Migrate":            {step: pitstop.Migrate("db", nil, "migrate", "up"), want: "migrate up"},
+		"Chain":              {step: pitstop.Chain(vet, plain, build), want: "go vet ./... && build step && go build ."},
+		"ChainAll":           {step: pitstop.ChainAll(vet, build), want: "go vet ./...; go build ."},
+		"Chain undescribed":  {step: pitstop.Chain(plain, plain), want: ""},
+		"nested combinators": {step: pitstop.Retry(1, 0, pitstop.Chain(vet, pitstop.Once(build))), want: "go vet ./... && go build ."},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.step.Describe(); got != tc.want {
+				t.Errorf("Describe() = %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDescribeRun(t *testing.T) {
+	api := pitstop.RunCommand("./tmp/api", "-v")
+	plain := pitstop.RunFunc(func() (func(), error) { return func() {}, nil })
+	for name, tc := range map[string]struct {
+		run  pitstop.RunStep
+		want string
+	}{
+		"RunCommand":  {run: api, want: "./tmp/api -v"},
+		"RunFunc":     {run: plain, want: ""},
+		"DescribeRun": {run: pitstop.DescribeRun("api server", plain), want: "api server"},
+		"TimedRun":    {run: pitstop.TimedRun("api", api), want: "./tmp/api -v"},
+		"MultiRun":    {run: pitstop.MultiRun(api, plain), want: "./tmp/api -v & app"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.run.Describe(); got != tc.want {
+				t.Errorf("Describe() = %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package pitstop
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// RenderTemplate returns a BuildFunc that parses the text/template at src,
+// executes it with data, and writes the result to dst using the same file mode
+// as src. Any missing parent directories of dst are created. The template is
+// read every time the BuildFunc is called, so changes to it are picked up on
+// the next build. If the template fails to parse or execute, dst is left
+// untouched.
+func RenderTemplate(src, dst string, data interface{}) BuildFunc {
+	return func() error {
+		err := renderTemplate(src, dst, data)
+		if err != nil {
+			return fmt.Errorf("error rendering %q to %q: %w", src, dst, err)
+		}
+		return nil
+	}
+}
+
+func renderTemplate(src, dst string, data interface{}) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.ParseFiles(src)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, buf.Bytes(), info.Mode().Perm())
+}
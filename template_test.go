@@ -0,0 +1,47 @@
+package pitstop_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.tmpl":  `{"env": "{{.Env}}", "port": {{.Port}}}`,
+		"broken.tmpl":  `{{.Env`,
+		"missing.tmpl": `{{.Missing.Field}}`,
+	})
+	defer os.RemoveAll(dir)
+	data := struct {
+		Env  string
+		Port int
+	}{"dev", 3000}
+
+	dst := filepath.Join(dir, "out", "config.json")
+	err := pitstop.RenderTemplate(filepath.Join(dir, "config.tmpl"), dst, data)()
+	if err != nil {
+		t.Fatalf("RenderTemplate() err = %v; want nil", err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("reading rendered file: %v", err)
+	}
+	if want := `{"env": "dev", "port": 3000}`; string(got) != want {
+		t.Errorf("rendered %q; want %q", got, want)
+	}
+
+	for _, name := range []string{"broken.tmpl", "missing.tmpl", "nope.tmpl"} {
+		dst := filepath.Join(dir, name+".out")
+		err := pitstop.RenderTemplate(filepath.Join(dir, name), dst, data)()
+		if err == nil {
+			t.Errorf("RenderTemplate(%q) err = nil; want an error", name)
+		}
+		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+			t.Errorf("RenderTemplate(%q) wrote %s despite failing", name, dst)
+		}
+	}
+}
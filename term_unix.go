@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package pitstop
+
+import (
+	"fmt"
+	"os"
+)
+
+// clearScreen clears the terminal if stdout is one.
+func clearScreen() {
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	fmt.Print("\033[H\033[2J")
+}
@@ -0,0 +1,17 @@
+package pitstop
+
+import (
+	"os"
+	"os/exec"
+)
+
+// clearScreen clears the terminal if stdout is one. Older Windows consoles
+// don't understand ANSI escape codes, so cls is used instead.
+func clearScreen() {
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	cmd := exec.Command("cmd", "/c", "cls")
+	cmd.Stdout = os.Stdout
+	cmd.Run()
+}
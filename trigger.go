@@ -0,0 +1,70 @@
+package pitstop
+
+import (
+	"context"
+	"time"
+)
+
+// Trigger is a source of rebuilds other than files changing, such as a timer,
+// a remote schema changing, or a message arriving on a queue. Wait blocks
+// until the next rebuild should happen, returning nil when it should or an
+// error if the trigger can't continue. Wait should return ctx.Err() once ctx
+// is done.
+//
+// A Poller calls Wait in a loop for each of its Triggers, and rebuilds every
+// time it returns nil. Rebuilds requested while one is already pending are
+// combined, just like with Poller.Trigger.
+type Trigger interface {
+	Wait(ctx context.Context) error
+}
+
+// TickerTrigger returns a Trigger that fires every d.
+func TickerTrigger(d time.Duration) Trigger {
+	return tickerTrigger{d: d}
+}
+
+type tickerTrigger struct {
+	d time.Duration
+}
+
+func (t tickerTrigger) Wait(ctx context.Context) error {
+	timer := time.NewTimer(t.d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WatcherTrigger returns a Trigger that fires when w finds a change, scanning
+// every interval. This is the same file polling a Poller does on its own,
+// which makes it possible to combine the watchers of several directories with
+// different settings in a single Poller using DisableScan.
+func WatcherTrigger(w *Watcher, interval time.Duration) Trigger {
+	return &watcherTrigger{w: w, interval: interval, since: time.Now()}
+}
+
+type watcherTrigger struct {
+	w        *Watcher
+	interval time.Duration
+	since    time.Time
+}
+
+func (t *watcherTrigger) Wait(ctx context.Context) error {
+	for {
+		timer := time.NewTimer(t.interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		scanned := time.Now()
+		if t.w.DidChange(t.since) {
+			t.since = scanned
+			return nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package pitstop_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+// chanTrigger is a pitstop.Trigger that fires every time a value is sent on
+// its channel.
+type chanTrigger chan struct{}
+
+func (t chanTrigger) Wait(ctx context.Context) error {
+	select {
+	case <-t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestPoller_Triggers(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	builds := make(chan struct{}, 10)
+	trigger := make(chanTrigger)
+	p := pitstop.Poller{
+		Dir:          dir,
+		ScanInterval: time.Millisecond,
+		DisableScan:  true,
+		Triggers:     []pitstop.Trigger{trigger},
+		Run: pitstop.RunFunc(func() (func(), error) {
+			builds <- struct{}{}
+			return func() {}, nil
+		}),
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() err = %v; want nil", err)
+	}
+	defer p.Stop()
+	<-builds
+
+	// With DisableScan a file change shouldn't cause a rebuild.
+	touch(t, dir, "main.go")
+	select {
+	case <-builds:
+		t.Fatalf("rebuilt after a file change with DisableScan set")
+	case <-time.After(50 * time.Millisecond):
+	}
+	trigger <- struct{}{}
+	select {
+	case <-builds:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("didn't rebuild after the trigger fired")
+	}
+}
+
+func TestTickerTrigger(t *testing.T) {
+	trigger := pitstop.TickerTrigger(10 * time.Millisecond)
+	if err := trigger.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() err = %v; want nil", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pitstop.TickerTrigger(time.Hour).Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait() err = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestWatcherTrigger(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+
+	trigger := pitstop.WatcherTrigger(&pitstop.Watcher{Dirs: []string{dir}}, 10*time.Millisecond)
+	fired := make(chan error, 1)
+	go func() {
+		fired <- trigger.Wait(context.Background())
+	}()
+	select {
+	case <-fired:
+		t.Fatalf("Wait() returned before any changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	touch(t, dir, "main.go")
+	select {
+	case err := <-fired:
+		if err != nil {
+			t.Errorf("Wait() err = %v; want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Wait() didn't return after a change")
+	}
+}
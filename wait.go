@@ -0,0 +1,104 @@
+package pitstop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// waitInterval is how long the WaitFor BuildFuncs wait between attempts.
+const waitInterval = 100 * time.Millisecond
+
+// WaitForHTTP returns a BuildFunc that repeatedly sends GET requests to url
+// until it responds with a 2xx status code. If that doesn't happen before
+// timeout elapses an error is returned. This is most useful as the first Post
+// function for apps that take a moment to start serving requests.
+func WaitForHTTP(url string, timeout time.Duration) BuildFunc {
+	return func() error {
+		client := http.Client{Timeout: waitInterval * 10}
+		deadline := time.Now().Add(timeout)
+		for {
+			res, err := client.Get(url)
+			if err == nil {
+				res.Body.Close()
+				if res.StatusCode >= 200 && res.StatusCode < 300 {
+					return nil
+				}
+				err = fmt.Errorf("unexpected status: %s", res.Status)
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("error waiting for %q: timed out after %v: %w", url, timeout, err)
+			}
+			time.Sleep(waitInterval)
+		}
+	}
+}
+
+// WaitForPort returns a BuildFunc that repeatedly tries to open a TCP
+// connection to addr until one succeeds. If that doesn't happen before timeout
+// elapses an error is returned.
+func WaitForPort(addr string, timeout time.Duration) BuildFunc {
+	return func() error {
+		deadline := time.Now().Add(timeout)
+		for {
+			conn, err := net.DialTimeout("tcp", addr, waitInterval*10)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("error waiting for %q: timed out after %v: %w", addr, timeout, err)
+			}
+			time.Sleep(waitInterval)
+		}
+	}
+}
+
+// WaitForFile returns a BuildFunc that waits for a file to exist at path. It is
+// useful for sequencing around tools that write their outputs asynchronously,
+// such as a bundler that writes a manifest once it finishes. If the file still
+// doesn't exist after timeout, an error wrapping context.DeadlineExceeded is
+// returned. The file may not be fully written yet when it first appears; see
+// WaitForStableFile for a way to wait until it is.
+func WaitForFile(path string, timeout time.Duration) BuildFunc {
+	return waitForFile(path, 0, timeout)
+}
+
+// WaitForStableFile works like WaitForFile, but also waits until the file is
+// not empty and its size hasn't changed for the stable duration, so that a file
+// which is still being written isn't used too early.
+func WaitForStableFile(path string, stable, timeout time.Duration) BuildFunc {
+	return waitForFile(path, stable, timeout)
+}
+
+func waitForFile(path string, stable, timeout time.Duration) BuildFunc {
+	return func() error {
+		deadline := time.Now().Add(timeout)
+		// size is the last size seen, and sized is when the file was first seen
+		// with that size.
+		size := int64(-1)
+		var sized time.Time
+		for {
+			info, err := os.Stat(path)
+			switch {
+			case err == nil && stable <= 0:
+				return nil
+			case err == nil && info.Size() != size:
+				size, sized = info.Size(), time.Now()
+			case err == nil && size > 0 && time.Since(sized) >= stable:
+				return nil
+			case err != nil && !os.IsNotExist(err):
+				return fmt.Errorf("error waiting for %q: %w", path, err)
+			case err != nil:
+				size = -1
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("error waiting for %q: timed out after %v: %w", path, timeout, context.DeadlineExceeded)
+			}
+			time.Sleep(waitInterval)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package pitstop_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+func TestWaitForHTTP(t *testing.T) {
+	var ready bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready {
+			ready = true
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	err := pitstop.WaitForHTTP(server.URL, time.Second)()
+	if err != nil {
+		t.Errorf("WaitForHTTP() err = %v; want nil", err)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	err = pitstop.WaitForHTTP(failing.URL, 200*time.Millisecond)()
+	if err == nil {
+		t.Errorf("WaitForHTTP() err = nil; want a timeout error")
+	}
+}
+
+func TestWaitForPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("setup: listening: %v", err)
+	}
+	addr := ln.Addr().String()
+	err = pitstop.WaitForPort(addr, time.Second)()
+	if err != nil {
+		t.Errorf("WaitForPort() err = %v; want nil", err)
+	}
+
+	ln.Close()
+	err = pitstop.WaitForPort(addr, 200*time.Millisecond)()
+	if err == nil {
+		t.Errorf("WaitForPort() err = nil; want a timeout error")
+	}
+}
+
+func TestWaitForFile(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "manifest.json")
+
+	err := pitstop.WaitForFile(path, 100*time.Millisecond)()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForFile() err = %v; want a deadline exceeded error", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(path, nil, 0600)
+	}()
+	if err := pitstop.WaitForFile(path, 5*time.Second)(); err != nil {
+		t.Errorf("WaitForFile() err = %v; want nil", err)
+	}
+}
+
+func TestWaitForStableFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"empty": ""})
+	defer os.RemoveAll(dir)
+
+	// An empty file is never considered stable.
+	err := pitstop.WaitForStableFile(filepath.Join(dir, "empty"), 50*time.Millisecond, 300*time.Millisecond)()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForStableFile() err = %v; want a deadline exceeded error", err)
+	}
+
+	// Simulate a tool that writes its output in chunks.
+	path := filepath.Join(dir, "bundle.js")
+	const chunks = 5
+	go func() {
+		f, err := os.Create(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for i := 0; i < chunks; i++ {
+			f.Write([]byte("x"))
+			time.Sleep(60 * time.Millisecond)
+		}
+	}()
+	if err := pitstop.WaitForStableFile(path, 300*time.Millisecond, 5*time.Second)(); err != nil {
+		t.Fatalf("WaitForStableFile() err = %v; want nil", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading file: %v", err)
+	}
+	if len(b) != chunks {
+		t.Errorf("file size = %d when WaitForStableFile returned; want %d", len(b), chunks)
+	}
+}
@@ -0,0 +1,597 @@
+package pitstop
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watcher scans one or more directories and their subdirectories for changes.
+// The zero value is ready to use and will scan ".".
+type Watcher struct {
+	// Dirs is the list of directories to scan for file changes. This defaults
+	// to "." if it is empty.
+	Dirs []string
+
+	// MaxDepth limits how many levels of subdirectories will be scanned for
+	// changes. This defaults to 0, which means there is no limit, and negative
+	// values also mean there is no limit, as with DidChangeMaxDepth. Use
+	// TopLevelOnly to skip subdirectories entirely.
+	MaxDepth int
+
+	// TopLevelOnly will cause the watcher to only scan the files directly
+	// inside each of Dirs, without any subdirectories. It overrides MaxDepth.
+	TopLevelOnly bool
+
+	// RespectGitignore will cause the watcher to parse any .gitignore files it
+	// finds while scanning and skip the paths they match. Each .gitignore only
+	// applies to the directory it is in and its subdirectories.
+	RespectGitignore bool
+
+	// Exclude is a list of file or directory paths that will never be scanned
+	// for changes. Relative paths are resolved from the current working
+	// directory, not from Dirs.
+	Exclude []string
+
+	// IgnoreDirs is a list of directories that are never scanned, such as
+	// "build/generated". Relative paths are relative to each directory in
+	// Dirs, and absolute paths are used as is. Unlike Ignore, each entry is
+	// matched against the directory's whole cleaned path rather than as a
+	// pattern, so "build/generated" only matches that one directory and not
+	// "build/generated2" or "src/build/generated". Matching directories are
+	// pruned along with everything inside them, without being read.
+	IgnoreDirs []string
+
+	// Ignore is a list of .gitignore style patterns. Paths inside Dirs that
+	// match any of them won't be scanned. Patterns containing a slash are
+	// relative to each directory in Dirs.
+	Ignore []string
+
+	// IgnoreFile is the name of a .gitignore style file, such as
+	// ".pitstopignore", to read from each of Dirs every time it is scanned.
+	// Its patterns are added to Ignore for that directory only, so changes to
+	// the file take effect on the next scan. Unlike RespectGitignore, files
+	// with the same name in subdirectories aren't read.
+	IgnoreFile string
+
+	// Include is a list of .gitignore style patterns, such as "*.go". If it
+	// isn't empty, only files matching at least one of the patterns will be
+	// treated as changes.
+	Include []string
+
+	// Patterns is a list of globs, such as "**/*.go" and "config.yaml", that
+	// are matched against the slash separated path of each file relative to
+	// the directory in Dirs it was found in. Unlike Include, every pattern is
+	// anchored to that directory, and a "**" segment matches any number of
+	// subdirectories, so "cmd/**/*.go" only matches Go files inside cmd. A
+	// leading "./" is ignored. If it isn't empty, only files matching at least
+	// one of the patterns will be treated as changes. It can be combined with
+	// Include, in which case a file has to match both.
+	Patterns []string
+
+	// HashCompare will cause the watcher to compare the contents of files
+	// rather than only their mtimes. A file whose mtime changed but whose
+	// contents are identical, such as after running touch, won't be reported
+	// as a change. Files are only hashed when their mtime changes, but this is
+	// still more expensive than the default. A Watcher using HashCompare keeps
+	// track of file hashes between scans and isn't safe for concurrent use.
+	// Files that are gone are forgotten by the next ScanFiles, Scan, or
+	// ChangedFiles, so a file that is removed and created again counts as a
+	// new file.
+	HashCompare bool
+
+	// FollowSymlinks will cause the watcher to scan the directories and files
+	// that symlinks point to. By default symlinks aren't followed, so changes
+	// behind a symlinked directory aren't noticed. Each directory is only
+	// scanned once, so a symlink that points at one of its own parents won't
+	// cause the scan to loop forever.
+	FollowSymlinks bool
+
+	// MtimeGranularity is the resolution of the mtimes recorded by the
+	// filesystem being scanned. Some filesystems, such as FAT and many network
+	// mounts, only record mtimes to the nearest second or two, so a file saved
+	// just after a build can appear to have been modified before it. If this is
+	// set, mtimes and since are both truncated to it and a file modified in the
+	// same interval as since is treated as a change. The tradeoff is that files
+	// written by the build itself, or any other file saved in that interval, are
+	// also treated as changes, which can cause an extra rebuild. This defaults
+	// to 0, which compares the full mtime.
+	MtimeGranularity time.Duration
+
+	// OnWalkError is called with the path and error of each file or
+	// directory that can't be scanned, such as a subdirectory without read
+	// permission. Those paths are skipped so they don't stop the rest of the
+	// scan, and if OnWalkError is nil nothing reports them. Errors scanning
+	// one of Dirs itself are still returned by Scan.
+	OnWalkError func(path string, err error)
+
+	hashes map[string]*fileHash
+	// files is every file found by the last call to ScanChanges.
+	files map[string]fileState
+}
+
+// fileHash records the hash of a file's contents when it had modTime, along
+// with base, the hash of its contents as of the last `since` time the watcher
+// was asked about. A file has only changed if hash and base differ.
+type fileHash struct {
+	modTime time.Time
+	hash    string
+	base    string
+}
+
+// errStopWalk is used to halt a filepath.Walk early once we know the answer.
+var errStopWalk = errors.New("pitstop: stop walking")
+
+// DidChange will scan the watcher's directories looking for any files that
+// have changed after the provided `since` time.Time. If one is found, true is
+// returned. Otherwise false is returned.
+func (w *Watcher) DidChange(since time.Time) bool {
+	for _, dir := range w.dirs() {
+		var changed bool
+		w.walk(dir, func(path string, info os.FileInfo) error {
+			if w.changed(path, info, since) {
+				changed = true
+				return errStopWalk
+			}
+			return nil
+		})
+		if changed {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangedFiles works like DidChange, but rather than stopping at the first
+// change it scans every directory and returns the paths of all the files that
+// changed. Paths are prefixed with the directory in Dirs they were found in.
+// They are sorted and each is only returned once, even if it was found in
+// more than one of Dirs, so the result is the same for every scan of the same
+// changes.
+func (w *Watcher) ChangedFiles(since time.Time) []string {
+	changed, _ := w.Scan(since)
+	return changed
+}
+
+// Scan works like ChangedFiles, but also returns any errors encountered while
+// scanning, such as one of the directories not existing. Directories that
+// can't be scanned don't stop the others from being scanned, so changed is
+// still accurate for the rest.
+func (w *Watcher) Scan(since time.Time) (changed []string, err error) {
+	files, err := w.ScanFiles(since)
+	for _, file := range files {
+		changed = append(changed, file.Path)
+	}
+	return changed, err
+}
+
+// ChangedFile is a file found by ScanFiles, along with the FileInfo it had
+// when it was scanned.
+type ChangedFile struct {
+	Path string
+	// Info describes the file when it was scanned. If the watcher is
+	// following symlinks, it describes what a symlink points to rather than
+	// the link itself.
+	Info os.FileInfo
+}
+
+// ScanFiles works like Scan, but returns the FileInfo of each changed file
+// along with its path, so things like its mtime can be checked without
+// calling os.Stat again. Files are sorted by Path and each is only returned
+// once.
+func (w *Watcher) ScanFiles(since time.Time) ([]ChangedFile, error) {
+	var changed []ChangedFile
+	var errs []error
+	// found holds every file scanned, and failed every directory that couldn't
+	// be, so the hashes of files that are gone can be dropped.
+	found := make(map[string]bool)
+	var failed []string
+	for _, dir := range w.dirs() {
+		err := w.walk(dir, func(path string, info os.FileInfo) error {
+			found[path] = true
+			if w.changed(path, info, since) {
+				changed = append(changed, ChangedFile{Path: path, Info: info})
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error scanning %q: %w", dir, err))
+			failed = append(failed, dir)
+		}
+	}
+	w.pruneHashes(found, failed)
+	sort.SliceStable(changed, func(i, j int) bool {
+		return changed[i].Path < changed[j].Path
+	})
+	unique := changed[:0]
+	for i, file := range changed {
+		if i == 0 || file.Path != changed[i-1].Path {
+			unique = append(unique, file)
+		}
+	}
+	return unique, errors.Join(errs...)
+}
+
+// Changes is the difference between the files found by two scans, as
+// returned by ScanChanges. Each list is sorted.
+type Changes struct {
+	// Added holds the files that weren't found by the previous scan.
+	Added []string
+	// Modified holds the files whose mtime or size is different than it was
+	// in the previous scan.
+	Modified []string
+	// Removed holds the files found by the previous scan that are gone.
+	Removed []string
+}
+
+// Empty reports whether nothing changed.
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Removed) == 0
+}
+
+// fileState is what ScanChanges compares to tell if a file was modified.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// ScanChanges scans every file the watcher would and compares them to the
+// files found the last time ScanChanges was called, rather than to a since
+// time the way Scan does. Unlike Scan it notices files that were removed.
+// The first call reports every file as Added. If one of Dirs can't be
+// scanned an error is returned, and the files found in it last time are
+// assumed to be unchanged rather than reported as Removed. HashCompare and
+// MtimeGranularity don't apply to ScanChanges. A Watcher keeps track of the
+// files between calls, so ScanChanges isn't safe for concurrent use.
+func (w *Watcher) ScanChanges() (Changes, error) {
+	files := make(map[string]fileState)
+	var errs []error
+	for _, dir := range w.dirs() {
+		err := w.walk(dir, func(path string, info os.FileInfo) error {
+			files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error scanning %q: %w", dir, err))
+			for path, state := range w.files {
+				if inAnyDir([]string{dir}, path) {
+					files[path] = state
+				}
+			}
+		}
+	}
+	var c Changes
+	for path, state := range files {
+		prev, ok := w.files[path]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, path)
+		case !prev.modTime.Equal(state.modTime) || prev.size != state.size:
+			c.Modified = append(c.Modified, path)
+		}
+	}
+	for path := range w.files {
+		if _, ok := files[path]; !ok {
+			c.Removed = append(c.Removed, path)
+		}
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Modified)
+	sort.Strings(c.Removed)
+	w.files = files
+	return c, errors.Join(errs...)
+}
+
+// changed reports whether the file at path has changed after since.
+func (w *Watcher) changed(path string, info os.FileInfo, since time.Time) bool {
+	modified := w.modifiedAfter(info.ModTime(), since)
+	if !w.HashCompare {
+		return modified
+	}
+	if w.hashes == nil {
+		w.hashes = make(map[string]*fileHash)
+	}
+	fh, ok := w.hashes[path]
+	if !ok {
+		fh = &fileHash{}
+		w.hashes[path] = fh
+	}
+	if !ok || !fh.modTime.Equal(info.ModTime()) {
+		hash, err := hashFile(path)
+		if err != nil {
+			// We can't tell, so err on the side of rebuilding.
+			return modified
+		}
+		fh.modTime = info.ModTime()
+		fh.hash = hash
+	}
+	if !modified {
+		fh.base = fh.hash
+		return false
+	}
+	// A file we haven't seen before has an empty base, so it counts as a
+	// change.
+	return fh.hash != fh.base
+}
+
+// hashAll returns the hash of every file the watcher would scan, keyed by
+// path. Hashes from prev are reused for files whose mtime hasn't changed since
+// it was returned, so only new and modified files are read. Files that can't
+// be read are left out.
+func (w *Watcher) hashAll(prev map[string]fileHash) map[string]fileHash {
+	hashes := make(map[string]fileHash, len(prev))
+	for _, dir := range w.dirs() {
+		w.walk(dir, func(path string, info os.FileInfo) error {
+			if fh, ok := prev[path]; ok && fh.modTime.Equal(info.ModTime()) {
+				hashes[path] = fh
+				return nil
+			}
+			hash, err := hashFile(path)
+			if err == nil {
+				hashes[path] = fileHash{modTime: info.ModTime(), hash: hash}
+			}
+			return nil
+		})
+	}
+	return hashes
+}
+
+// pruneHashes forgets the hashes of files that weren't found by a scan. Files
+// inside any of the failed directories are kept, since the scan couldn't tell
+// whether they are still there.
+func (w *Watcher) pruneHashes(found map[string]bool, failed []string) {
+	for path := range w.hashes {
+		if !found[path] && !inAnyDir(failed, path) {
+			delete(w.hashes, path)
+		}
+	}
+}
+
+// inAnyDir reports whether path is inside any of dirs.
+func inAnyDir(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedAfter reports whether modTime is after since, taking
+// MtimeGranularity into account.
+func (w *Watcher) modifiedAfter(modTime, since time.Time) bool {
+	if w.MtimeGranularity <= 0 {
+		return modTime.After(since)
+	}
+	return !modTime.Truncate(w.MtimeGranularity).Before(since.Truncate(w.MtimeGranularity))
+}
+
+// hashFile returns a hash of the contents of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
+func (w *Watcher) dirs() []string {
+	if len(w.Dirs) == 0 {
+		return []string{"."}
+	}
+	return w.Dirs
+}
+
+// maxDepth converts MaxDepth and TopLevelOnly into the form used by
+// DidChangeMaxDepth, where a negative value means there is no limit.
+func (w *Watcher) maxDepth() int {
+	switch {
+	case w.TopLevelOnly:
+		return 0
+	case w.MaxDepth <= 0:
+		return -1
+	}
+	return w.MaxDepth
+}
+
+// walk calls fn for every file in root that the watcher is configured to
+// scan. Directories that shouldn't be scanned are pruned entirely.
+func (w *Watcher) walk(root string, fn func(path string, info os.FileInfo) error) error {
+	maxDepth := w.maxDepth()
+	ignores := make(map[string][]ignoreRule)
+	if len(w.Ignore) > 0 {
+		ignores[root] = parseIgnorePatterns(w.Ignore)
+	}
+	if w.IgnoreFile != "" {
+		ignoreFile := filepath.Join(root, w.IgnoreFile)
+		rules, err := readIgnoreFile(ignoreFile)
+		if err != nil && !os.IsNotExist(err) {
+			w.walkError(ignoreFile, err)
+		}
+		// These come after Ignore so that they can negate its patterns, the
+		// same way later lines in a .gitignore do.
+		ignores[root] = append(ignores[root], rules...)
+	}
+	includes := parseIgnorePatterns(w.Include)
+	globs := parseGlobs(w.Patterns)
+	excludes := make(map[string]bool, len(w.Exclude))
+	for _, path := range w.Exclude {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		excludes[abs] = true
+	}
+	ignoreDirs := make(map[string]bool, len(w.IgnoreDirs))
+	for _, dir := range w.IgnoreDirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		ignoreDirs[abs] = true
+	}
+
+	// visited holds the real path of every directory reached through a
+	// symlink, so each is only scanned once.
+	visited := make(map[string]bool)
+	if w.FollowSymlinks {
+		if real, err := filepath.EvalSymlinks(root); err == nil {
+			visited[real] = true
+		}
+	}
+	var visit filepath.WalkFunc
+	visit = func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			if !os.IsNotExist(err) {
+				w.walkError(path, err)
+			}
+			// Either the file was removed while we were scanning or it can't
+			// be read, and neither should stop the rest of the scan.
+			return nil
+		}
+		if path != root && (excluded(excludes, path) || ignored(ignores, root, path, info.IsDir())) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if w.FollowSymlinks && info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				// A broken symlink has nothing to scan.
+				return nil
+			}
+			if !target.IsDir() {
+				info = target
+			} else {
+				real, err := filepath.EvalSymlinks(path)
+				if err != nil || visited[real] {
+					return nil
+				}
+				visited[real] = true
+				// Walk the target, but report paths as if they were inside the
+				// symlink so ignore rules and depth limits still apply.
+				return filepath.Walk(real, func(p string, info os.FileInfo, err error) error {
+					rel, relErr := filepath.Rel(real, p)
+					if relErr != nil {
+						return relErr
+					}
+					return visit(filepath.Join(path, rel), info, err)
+				})
+			}
+		}
+		if info.IsDir() {
+			if maxDepth >= 0 && depth(root, path) > maxDepth {
+				return filepath.SkipDir
+			}
+			if path != root && excluded(ignoreDirs, path) {
+				return filepath.SkipDir
+			}
+			if w.RespectGitignore {
+				ignoreFile := filepath.Join(path, ".gitignore")
+				rules, err := readIgnoreFile(ignoreFile)
+				if err != nil && !os.IsNotExist(err) {
+					w.walkError(ignoreFile, err)
+				}
+				if len(rules) > 0 {
+					ignores[path] = append(ignores[path], rules...)
+				}
+			}
+			return nil
+		}
+		if len(includes) > 0 && !included(includes, root, path) {
+			return nil
+		}
+		if len(globs) > 0 && !globbed(globs, root, path) {
+			return nil
+		}
+		return fn(path, info)
+	}
+	err := filepath.Walk(root, visit)
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// walkError reports an error scanning path to OnWalkError, if it is set.
+func (w *Watcher) walkError(path string, err error) {
+	if w.OnWalkError != nil {
+		w.OnWalkError(path, err)
+	}
+}
+
+// included reports whether the file at path, found while walking root, is
+// matched by the include rules.
+func included(includes []ignoreRule, root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	matched, _ := matchRules(includes, filepath.ToSlash(rel), false)
+	return matched
+}
+
+// parseGlobs splits each of the provided Patterns globs into its segments.
+func parseGlobs(patterns []string) [][]string {
+	var globs [][]string
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(path.Clean(filepath.ToSlash(pattern)), "/")
+		globs = append(globs, strings.Split(pattern, "/"))
+	}
+	return globs
+}
+
+// globbed reports whether the file at path, found while walking root, is
+// matched by any of the globs.
+func globbed(globs [][]string, root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for _, glob := range globs {
+		if matchSegments(glob, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether the absolute form of path is in excludes.
+func excluded(excludes map[string]bool, path string) bool {
+	if len(excludes) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && excludes[abs]
+}
+
+// depth returns how many directories deep path is relative to root. root
+// itself has a depth of 0.
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
@@ -0,0 +1,703 @@
+package pitstop_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/joncalhoun/pitstop"
+)
+
+// writeFiles creates a temp directory containing the provided files, which are
+// keyed by their slash separated path. Every file is given an mtime in the past
+// so that tests can control which files have changed using touch.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("setup: creating temp dir: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			t.Fatalf("setup: creating subdir: %v", err)
+		}
+		err = ioutil.WriteFile(path, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("setup: writing file: %v", err)
+		}
+		err = os.Chtimes(path, past, past)
+		if err != nil {
+			t.Fatalf("setup: setting file times: %v", err)
+		}
+	}
+	return dir
+}
+
+// touch sets the mtime of the slash separated path inside dir to the future.
+func touch(t *testing.T, dir, name string) {
+	t.Helper()
+	touchAt(t, dir, name, time.Now().Add(time.Hour))
+}
+
+// touchAt sets the mtime of the slash separated path inside dir to when.
+func touchAt(t *testing.T, dir, name string, when time.Time) {
+	t.Helper()
+	err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), when, when)
+	if err != nil {
+		t.Fatalf("setup: touching file: %v", err)
+	}
+}
+
+func TestWatcher_MaxDepth(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":       "",
+		"a/handler.go":  "",
+		"a/b/deeper.go": "",
+	})
+	defer os.RemoveAll(dir)
+	since := time.Now()
+	touch(t, dir, "a/b/deeper.go")
+
+	for maxDepth, want := range map[int]bool{
+		0:  true,
+		-1: true,
+		-2: true,
+		1:  false,
+		2:  true,
+	} {
+		w := pitstop.Watcher{Dirs: []string{dir}, MaxDepth: maxDepth}
+		if got := w.DidChange(since); got != want {
+			t.Errorf("DidChange(MaxDepth=%d) = %v; want %v", maxDepth, got, want)
+		}
+	}
+
+	touch(t, dir, "a/handler.go")
+	w := pitstop.Watcher{Dirs: []string{dir}, MaxDepth: 2, TopLevelOnly: true}
+	if w.DidChange(since) {
+		t.Errorf("DidChange(TopLevelOnly) = true; want subdirectories skipped")
+	}
+	touch(t, dir, "main.go")
+	if !w.DidChange(since) {
+		t.Errorf("DidChange(TopLevelOnly) = false after changing a top level file; want true")
+	}
+}
+
+func TestWatcher_RespectGitignore(t *testing.T) {
+	files := map[string]string{
+		".gitignore":            "build/\n*.log\n!keep.log\n/root.txt\n",
+		"main.go":               "",
+		"root.txt":              "",
+		"keep.log":              "",
+		"debug.log":             "",
+		"build/app":             "",
+		"sub/root.txt":          "",
+		"sub/.gitignore":        "*.tmp\n!debug.log\n",
+		"sub/data.tmp":          "",
+		"sub/debug.log":         "",
+		"sub/deeper/other.tmp":  "",
+		"other/data.tmp":        "",
+		"other/build/generated": "",
+	}
+	for name, want := range map[string]bool{
+		"main.go":               true,
+		"root.txt":              false,
+		"keep.log":              true,
+		"debug.log":             false,
+		"build/app":             false,
+		"sub/root.txt":          true,
+		"sub/data.tmp":          false,
+		"sub/debug.log":         true,
+		"sub/deeper/other.tmp":  false,
+		"other/data.tmp":        true,
+		"other/build/generated": false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, files)
+			defer os.RemoveAll(dir)
+			since := time.Now()
+			touch(t, dir, name)
+
+			w := pitstop.Watcher{Dirs: []string{dir}, RespectGitignore: true}
+			got := w.DidChange(since)
+			if got != want {
+				t.Errorf("DidChange() = %v; want %v", got, want)
+			}
+			w.RespectGitignore = false
+			if !w.DidChange(since) {
+				t.Errorf("DidChange() = false with RespectGitignore disabled; want true")
+			}
+		})
+	}
+}
+
+func TestWatcher_IgnoreDirs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":                      "",
+		"build/generated/api.go":       "",
+		"build/generated2/api.go":      "",
+		"src/build/generated/api.go":   "",
+		"build/generated/deep/mock.go": "",
+	})
+	defer os.RemoveAll(dir)
+	// A .gitignore that is a directory can't be read, so an error reported
+	// for it shows the directory it is in was walked.
+	unreadable := filepath.Join(dir, "build", "generated", "deep", ".gitignore")
+	if err := os.Mkdir(unreadable, 0755); err != nil {
+		t.Fatalf("setup: creating dir: %v", err)
+	}
+
+	since := time.Now()
+	for _, path := range []string{"main.go", "build/generated/api.go", "build/generated2/api.go", "src/build/generated/api.go", "build/generated/deep/mock.go"} {
+		touch(t, dir, path)
+	}
+	var walked []string
+	w := pitstop.Watcher{
+		Dirs:             []string{dir},
+		RespectGitignore: true,
+		OnWalkError: func(path string, err error) {
+			walked = append(walked, path)
+		},
+	}
+	if _, err := w.Scan(since); err != nil {
+		t.Fatalf("Scan() err = %v; want nil", err)
+	}
+	if want := []string{unreadable}; !reflect.DeepEqual(walked, want) {
+		t.Fatalf("walk errors without IgnoreDirs = %v; want %v", walked, want)
+	}
+
+	walked = nil
+	w.IgnoreDirs = []string{"./build/generated/"}
+	changed, err := w.Scan(since)
+	if err != nil {
+		t.Fatalf("Scan() err = %v; want nil", err)
+	}
+	want := []string{
+		filepath.Join(dir, "build/generated2/api.go"),
+		filepath.Join(dir, "main.go"),
+		filepath.Join(dir, "src/build/generated/api.go"),
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("Scan() = %v; want %v", changed, want)
+	}
+	if len(walked) != 0 {
+		t.Errorf("walk errors = %v; want the ignored dir not to be walked", walked)
+	}
+
+	w.IgnoreDirs = []string{filepath.Join(dir, "src")}
+	changed, err = w.Scan(since)
+	if err != nil {
+		t.Fatalf("Scan() err = %v; want nil", err)
+	}
+	want = []string{
+		filepath.Join(dir, "build/generated/api.go"),
+		filepath.Join(dir, "build/generated/deep/mock.go"),
+		filepath.Join(dir, "build/generated2/api.go"),
+		filepath.Join(dir, "main.go"),
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("Scan() with an absolute ignored dir = %v; want %v", changed, want)
+	}
+}
+
+func TestWatcher_Exclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":        "",
+		"tmp/app":        "",
+		"assets/app.css": "",
+	})
+	defer os.RemoveAll(dir)
+	app := filepath.Join(dir, "app")
+	build := pitstop.BuildFunc(func() error {
+		return ioutil.WriteFile(app, []byte("binary"), 0700)
+	})
+
+	// Simulate a build whose output lands after the poller records its build
+	// time, which would otherwise trigger another build every scan.
+	since := time.Now()
+	if err := build(); err != nil {
+		t.Fatalf("build() err = %v; want nil", err)
+	}
+	touch(t, dir, "app")
+	touch(t, dir, "tmp/app")
+	w := pitstop.Watcher{Dirs: []string{dir}}
+	if !w.DidChange(since) {
+		t.Fatalf("DidChange() = false without excludes; want true")
+	}
+	w.Exclude = []string{app, filepath.Join(dir, "tmp")}
+	if w.DidChange(since) {
+		t.Errorf("DidChange() = true with build outputs excluded; want false")
+	}
+
+	touch(t, dir, "assets/app.css")
+	if !w.DidChange(since) {
+		t.Errorf("DidChange() = false after a real change; want true")
+	}
+}
+
+func TestWatcher_HashCompare(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":    "package main",
+		"handler.go": "package main",
+	})
+	defer os.RemoveAll(dir)
+	since := time.Now()
+	w := pitstop.Watcher{Dirs: []string{dir}, HashCompare: true}
+	if w.DidChange(since) {
+		t.Fatalf("DidChange() = true before any changes; want false")
+	}
+
+	// The mtime changes but the contents are identical.
+	touch(t, dir, "main.go")
+	if w.DidChange(since) {
+		t.Errorf("DidChange() = true after touching a file; want false")
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "handler.go"), []byte("package handler"), 0600)
+	if err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	touch(t, dir, "handler.go")
+	if !w.DidChange(since) {
+		t.Errorf("DidChange() = false after changing a file's contents; want true")
+	}
+	// The change shouldn't be forgotten until since moves past it.
+	if !w.DidChange(since) {
+		t.Errorf("DidChange() = false on a second scan; want true")
+	}
+	if w.DidChange(time.Now().Add(2 * time.Hour)) {
+		t.Errorf("DidChange() = true after since moved past the change; want false")
+	}
+
+	_, err = ioutil.TempFile(dir, "")
+	if err != nil {
+		t.Fatalf("creating new file: %v", err)
+	}
+	if !w.DidChange(since) {
+		t.Errorf("DidChange() = false after creating a file; want true")
+	}
+
+	// A removed file is forgotten, so creating it again with the same
+	// contents is a change.
+	later := time.Now()
+	main := filepath.Join(dir, "main.go")
+	if err := os.Remove(main); err != nil {
+		t.Fatalf("removing file: %v", err)
+	}
+	w.Scan(later)
+	if err := ioutil.WriteFile(main, []byte("package main"), 0600); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	touch(t, dir, "main.go")
+	if changed, _ := w.Scan(later); !reflect.DeepEqual(changed, []string{main}) {
+		t.Errorf("Scan() = %v after recreating a removed file; want %v", changed, []string{main})
+	}
+}
+
+func TestWatcher_IgnoreFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		".pitstopignore":     "# generated assets\npublic/\n!public/keep.css\n",
+		"main.go":            "",
+		"public/app.css":     "",
+		"public/keep.css":    "",
+		"sub/.pitstopignore": "*.go\n",
+		"sub/sub.go":         "",
+		"drafts/post.md":     "",
+	})
+	defer os.RemoveAll(dir)
+	since := time.Now()
+	for _, path := range []string{"main.go", "public/app.css", "public/keep.css", "sub/sub.go", "drafts/post.md"} {
+		touch(t, dir, path)
+	}
+	w := pitstop.Watcher{
+		Dirs:       []string{dir},
+		Ignore:     []string{"drafts/"},
+		IgnoreFile: ".pitstopignore",
+	}
+	want := []string{
+		filepath.Join(dir, "main.go"),
+		// Only the file in the watched directory is read.
+		filepath.Join(dir, "sub", "sub.go"),
+	}
+	if got := w.ChangedFiles(since); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedFiles() = %v; want %v", got, want)
+	}
+}
+
+func TestLoadIgnoreFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		".pitstopignore": "# comment\n\npublic/\r\n!public/keep.css\n  \n*.log\n",
+	})
+	defer os.RemoveAll(dir)
+	got, err := pitstop.LoadIgnoreFile(filepath.Join(dir, ".pitstopignore"))
+	if err != nil {
+		t.Fatalf("LoadIgnoreFile() err = %v; want nil", err)
+	}
+	if want := []string{"public/", "!public/keep.css", "*.log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadIgnoreFile() = %q; want %q", got, want)
+	}
+	if _, err := pitstop.LoadIgnoreFile(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadIgnoreFile() err = %v; want a not exist error", err)
+	}
+}
+
+func TestWatcher_IgnoreInclude(t *testing.T) {
+	files := map[string]string{
+		"main.go":          "",
+		"main_test.go":     "",
+		"README.md":        "",
+		"tmp/app.go":       "",
+		"web/index.html":   "",
+		"web/tmp/cache.go": "",
+	}
+	w := pitstop.Watcher{
+		Ignore:  []string{"/tmp/", "*_test.go"},
+		Include: []string{"*.go", "web/*.html"},
+	}
+	for name, want := range map[string]bool{
+		"main.go":          true,
+		"main_test.go":     false,
+		"README.md":        false,
+		"tmp/app.go":       false,
+		"web/index.html":   true,
+		"web/tmp/cache.go": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, files)
+			defer os.RemoveAll(dir)
+			since := time.Now()
+			touch(t, dir, name)
+			w.Dirs = []string{dir}
+			got := w.DidChange(since)
+			if got != want {
+				t.Errorf("DidChange() = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWatcher_Patterns(t *testing.T) {
+	files := map[string]string{
+		"main.go":                "",
+		"config.yaml":            "",
+		"web/config.yaml":        "",
+		"README.md":              "",
+		"internal/a/b/c/deep.go": "",
+		"cmd/server/main.go":     "",
+		"cmd/server/notes.txt":   "",
+	}
+	w := pitstop.Watcher{
+		Patterns: []string{"**/*.go", "./config.yaml", "cmd/**/*.txt"},
+	}
+	for name, want := range map[string]bool{
+		"main.go":                true,
+		"config.yaml":            true,
+		"web/config.yaml":        false,
+		"README.md":              false,
+		"internal/a/b/c/deep.go": true,
+		"cmd/server/main.go":     true,
+		"cmd/server/notes.txt":   true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, files)
+			defer os.RemoveAll(dir)
+			since := time.Now()
+			touch(t, dir, name)
+			w.Dirs = []string{dir}
+			got := w.DidChange(since)
+			if got != want {
+				t.Errorf("DidChange() = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWatcher_ChangedFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":             "",
+		"handler.go":          "",
+		"templates/home.html": "",
+	})
+	defer os.RemoveAll(dir)
+	since := time.Now()
+	w := pitstop.Watcher{Dirs: []string{dir}}
+	if got := w.ChangedFiles(since); len(got) != 0 {
+		t.Errorf("ChangedFiles() = %v; want none", got)
+	}
+	touch(t, dir, "main.go")
+	touch(t, dir, "templates/home.html")
+	want := []string{
+		filepath.Join(dir, "main.go"),
+		filepath.Join(dir, "templates", "home.html"),
+	}
+	if got := w.ChangedFiles(since); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedFiles() = %v; want %v", got, want)
+	}
+}
+
+func TestWatcher_ScanFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"b.go":       "",
+		"a-b/x.go":   "",
+		"a/x.go":     "",
+		"api/api.go": "",
+	})
+	defer os.RemoveAll(dir)
+	since := time.Now()
+	for _, path := range []string{"b.go", "a/x.go", "a-b/x.go", "api/api.go"} {
+		touch(t, dir, path)
+	}
+	// api is scanned twice, but each of its files should only be reported once.
+	w := pitstop.Watcher{Dirs: []string{filepath.Join(dir, "api"), dir}}
+	want := []string{
+		filepath.Join(dir, "a-b", "x.go"),
+		filepath.Join(dir, "a", "x.go"),
+		filepath.Join(dir, "api", "api.go"),
+		filepath.Join(dir, "b.go"),
+	}
+	if got := w.ChangedFiles(since); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedFiles() = %v; want %v", got, want)
+	}
+	files, err := w.ScanFiles(since)
+	if err != nil {
+		t.Fatalf("ScanFiles() err = %v; want nil", err)
+	}
+	if len(files) != len(want) {
+		t.Fatalf("ScanFiles() returned %d files; want %d", len(files), len(want))
+	}
+	for i, file := range files {
+		if file.Path != want[i] {
+			t.Errorf("files[%d].Path = %q; want %q", i, file.Path, want[i])
+		}
+		if file.Info == nil || !file.Info.ModTime().After(since) {
+			t.Errorf("files[%d].Info = %v; want the changed file's info", i, file.Info)
+		}
+	}
+}
+
+func TestWatcher_ScanChanges(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":    "",
+		"handler.go": "",
+		"old.go":     "",
+	})
+	defer os.RemoveAll(dir)
+	w := pitstop.Watcher{Dirs: []string{dir}}
+	abs := func(paths ...string) []string {
+		var ret []string
+		for _, path := range paths {
+			ret = append(ret, filepath.Join(dir, path))
+		}
+		return ret
+	}
+	write := func(path, contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(contents), 0600); err != nil {
+			t.Fatalf("setup: writing %s: %v", path, err)
+		}
+	}
+
+	for _, step := range []struct {
+		name   string
+		change func()
+		want   pitstop.Changes
+	}{
+		{
+			name:   "first scan",
+			change: func() {},
+			want:   pitstop.Changes{Added: abs("handler.go", "main.go", "old.go")},
+		},
+		{
+			name:   "nothing changed",
+			change: func() {},
+		},
+		{
+			name:   "added",
+			change: func() { write("new.go", "package main") },
+			want:   pitstop.Changes{Added: abs("new.go")},
+		},
+		{
+			name:   "modified",
+			change: func() { touch(t, dir, "main.go") },
+			want:   pitstop.Changes{Modified: abs("main.go")},
+		},
+		{
+			name: "removed",
+			change: func() {
+				if err := os.Remove(filepath.Join(dir, "old.go")); err != nil {
+					t.Fatalf("setup: removing old.go: %v", err)
+				}
+			},
+			want: pitstop.Changes{Removed: abs("old.go")},
+		},
+		{
+			name: "mixed",
+			change: func() {
+				// The mtime is left alone, so only the size shows the change.
+				info, err := os.Stat(filepath.Join(dir, "handler.go"))
+				if err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+				write("handler.go", "package main")
+				touchAt(t, dir, "handler.go", info.ModTime())
+				write("a.go", "")
+				write("z.go", "")
+				if err := os.Rename(filepath.Join(dir, "new.go"), filepath.Join(dir, "renamed.go")); err != nil {
+					t.Fatalf("setup: renaming new.go: %v", err)
+				}
+			},
+			want: pitstop.Changes{
+				Added:    abs("a.go", "renamed.go", "z.go"),
+				Modified: abs("handler.go"),
+				Removed:  abs("new.go"),
+			},
+		},
+	} {
+		step.change()
+		got, err := w.ScanChanges()
+		if err != nil {
+			t.Fatalf("%s: ScanChanges() err = %v; want nil", step.name, err)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: ScanChanges() = %+v; want %+v", step.name, got, step.want)
+		}
+		if got.Empty() != (step.name == "nothing changed") {
+			t.Errorf("%s: Empty() = %t", step.name, got.Empty())
+		}
+	}
+
+	// Files in a directory that can't be scanned aren't reported as removed.
+	moved := dir + ".moved"
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatalf("setup: moving dir: %v", err)
+	}
+	defer os.RemoveAll(moved)
+	if got, err := w.ScanChanges(); err == nil || !got.Empty() {
+		t.Errorf("ScanChanges() of a missing dir = %+v, %v; want no changes and an error", got, err)
+	}
+	if err := os.Rename(moved, dir); err != nil {
+		t.Fatalf("setup: moving dir back: %v", err)
+	}
+	if got, err := w.ScanChanges(); err != nil || !got.Empty() {
+		t.Errorf("ScanChanges() once the dir is back = %+v, %v; want no changes", got, err)
+	}
+}
+
+func TestWatcher_OnWalkError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":             "",
+		"secret/secret.go": "",
+		"z.go":             "",
+	})
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	if err := os.Chmod(secret, 0); err != nil {
+		t.Fatalf("setup: removing permissions: %v", err)
+	}
+	defer os.Chmod(secret, 0700)
+	if _, err := ioutil.ReadDir(secret); err == nil {
+		t.Skip("permissions aren't enforced, such as when running as root")
+	}
+
+	since := time.Now()
+	touch(t, dir, "z.go")
+	var errored []string
+	w := pitstop.Watcher{
+		Dirs: []string{dir},
+		OnWalkError: func(path string, err error) {
+			errored = append(errored, path)
+		},
+	}
+	changed, err := w.Scan(since)
+	if err != nil {
+		t.Errorf("Scan() err = %v; want nil", err)
+	}
+	if want := []string{filepath.Join(dir, "z.go")}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("Scan() = %v; want %v", changed, want)
+	}
+	if want := []string{secret}; !reflect.DeepEqual(errored, want) {
+		t.Errorf("OnWalkError called with %v; want %v", errored, want)
+	}
+	if !pitstop.DidChange(dir, since) {
+		t.Errorf("DidChange() = false; want the change after the unreadable directory to be found")
+	}
+}
+
+func TestWatcher_FollowSymlinks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	shared := writeFiles(t, map[string]string{"pkg/shared.go": ""})
+	defer os.RemoveAll(shared)
+	if err := os.Symlink(shared, filepath.Join(dir, "shared")); err != nil {
+		t.Skipf("creating symlink: %v", err)
+	}
+	// A symlink to one of its own parents shouldn't cause an endless scan.
+	if err := os.Symlink(dir, filepath.Join(dir, "loop")); err != nil {
+		t.Fatalf("setup: creating symlink: %v", err)
+	}
+
+	since := time.Now()
+	w := pitstop.Watcher{Dirs: []string{dir}, FollowSymlinks: true}
+	if w.DidChange(since) {
+		t.Fatalf("DidChange() = true before any changes; want false")
+	}
+	touch(t, shared, "pkg/shared.go")
+	want := []string{filepath.Join(dir, "shared", "pkg", "shared.go")}
+	if got := w.ChangedFiles(since); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedFiles() = %v; want %v", got, want)
+	}
+	if !pitstop.DidChangeFollow(dir, since) {
+		t.Errorf("DidChangeFollow() = false after a change behind a symlink; want true")
+	}
+	w.FollowSymlinks = false
+	if w.DidChange(since) {
+		t.Errorf("DidChange() = true without FollowSymlinks; want false")
+	}
+}
+
+func TestWatcher_MtimeGranularity(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":    "",
+		"handler.go": "",
+	})
+	defer os.RemoveAll(dir)
+	// Simulate a filesystem that only records whole seconds: main.go is saved
+	// just after since, but its mtime is rounded down to before it.
+	since := time.Now().Truncate(time.Second).Add(-time.Minute).Add(500 * time.Millisecond)
+	touchAt(t, dir, "main.go", since.Truncate(time.Second))
+	touchAt(t, dir, "handler.go", since.Truncate(time.Second).Add(-time.Second))
+
+	w := pitstop.Watcher{Dirs: []string{dir}}
+	if w.DidChange(since) {
+		t.Errorf("DidChange() = true without MtimeGranularity; want false")
+	}
+	w.MtimeGranularity = time.Second
+	want := []string{filepath.Join(dir, "main.go")}
+	if got := w.ChangedFiles(since); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedFiles() = %v; want %v", got, want)
+	}
+}
+
+func TestWatcher_Scan(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": ""})
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing")
+	since := time.Now()
+	touch(t, dir, "main.go")
+
+	w := pitstop.Watcher{Dirs: []string{missing, dir}}
+	changed, err := w.Scan(since)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Scan() err = %v; want a not exist error", err)
+	}
+	want := []string{filepath.Join(dir, "main.go")}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("Scan() changed = %v; want %v", changed, want)
+	}
+}